@@ -7,8 +7,12 @@ import (
 
 type QError struct {
 	error error
+	kind  Kind
 }
 
+// Kind 异常类型，用于调用方区分特定的异常，为空时表明未指定类型
+type Kind string
+
 type ErrorThrower interface {
 	Error(err *QError)
 }
@@ -18,6 +22,21 @@ func New(message string) *QError {
 	return &QError{error: errors.New("QError:" + message)}
 }
 
+// NewKind 自定义一个指定类型的QError异常
+func NewKind(kind Kind, message string) *QError {
+	return &QError{error: errors.New("QError:" + message), kind: kind}
+}
+
+// Kind 获取异常类型
+func (qError *QError) Kind() Kind {
+	return qError.kind
+}
+
+// IsKind 判断异常是否为指定类型
+func (qError *QError) IsKind(kind Kind) bool {
+	return qError != nil && qError.kind == kind
+}
+
 // 实现error接口
 func (qError *QError) Error() string {
 	return qError.error.Error()
@@ -1,6 +1,9 @@
 package logger
 
-import "QuantumUtils/errors"
+import (
+	"QuantumUtils/errors"
+	"sync"
+)
 
 // QLogger QLogger接口，由GetLogger统一返回
 type QLogger interface {
@@ -24,9 +27,12 @@ func (q *QLoggerAccessor) SetLogger(l QLogger) {
 
 // 多Logger对象分别管理
 var loggerMap = make(map[string]QLogger)
+var loggerMu sync.Mutex
 
 // GetQLogger 无则创建，有则获取QLogger
 func GetQLogger(name string) QLogger {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
 	logger, ok := loggerMap[name]
 	if !ok {
 		newLogger(name)
@@ -1,6 +1,9 @@
 package logger
 
-import "go.uber.org/zap/zapcore"
+import (
+	"go.uber.org/zap/zapcore"
+	"sync"
+)
 
 // QLogConfig QLogger配置文件
 type QLogConfig struct {
@@ -17,14 +20,19 @@ type QLogConfig struct {
 }
 
 var logConfMap = make(map[string]*QLogConfig)
+var logConfMu sync.Mutex
 
 // SetLogConfig 设置日志配置
 func SetLogConfig(name string, config QLogConfig) {
+	logConfMu.Lock()
+	defer logConfMu.Unlock()
 	logConfMap[name] = &config
 }
 
 // GetLogConfig 获取日志配置
 func GetLogConfig(name string) *QLogConfig {
+	logConfMu.Lock()
+	defer logConfMu.Unlock()
 	conf, ok := logConfMap[name]
 	if !ok {
 		logConfMap[name] = &QLogConfig{
@@ -0,0 +1,97 @@
+package acl
+
+import (
+	"QuantumUtils/qnet/auth"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `{
+	"default": "deny",
+	"rules": [
+		{"route": "admin.audit", "principals": ["auditor"], "effect": "allow"},
+		{"route": "admin.*", "roles": ["admin"], "effect": "allow"},
+		{"route": "admin.*", "effect": "deny"},
+		{"route": "public.ping", "effect": "allow"},
+		{"route": "*", "principals": ["*"], "effect": "allow"}
+	]
+}`
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := &auth.Principal{Name: "alice", Roles: []string{"admin"}}
+	auditor := &auth.Principal{Name: "auditor"}
+	user := &auth.Principal{Name: "bob", Roles: []string{"user"}}
+	cases := []struct {
+		principal *auth.Principal
+		route     string
+		allowed   bool
+	}{
+		{admin, "admin.reload", true},
+		{auditor, "admin.audit", true},
+		{auditor, "admin.reload", false},
+		{user, "admin.reload", false},
+		{user, "order.create", true},
+		{user, "", true},
+		{nil, "public.ping", true},
+		{nil, "order.create", false},
+		{nil, "", false},
+	}
+	for _, c := range cases {
+		if allowed, _ := policy.Evaluate(c.principal, c.route); allowed != c.allowed {
+			t.Errorf("Evaluate(%v, %q) = %v, want %v", c.principal, c.route, allowed, c.allowed)
+		}
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"rules": [{"route": "a.*.b", "effect": "allow"}]}`,
+		`{"rules": [{"route": "a", "effect": "maybe"}]}`,
+		`{"rules": [{"route": "", "effect": "allow"}]}`,
+		`{"default": "sometimes"}`,
+		`not json`,
+	} {
+		if _, err := ParsePolicy([]byte(data)); err == nil {
+			t.Errorf("expected %s to be rejected", data)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(`{"default": "deny"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e, err := LoadEnforcer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := e.Policy().Evaluate(nil, "order.create"); allowed {
+		t.Fatal("expected initial policy to deny")
+	}
+	if err := os.WriteFile(path, []byte(`{"default": "allow"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := e.Policy().Evaluate(nil, "order.create"); !allowed {
+		t.Error("expected reloaded policy to allow")
+	}
+
+	// 加载失败时保留原有策略
+	if err := os.WriteFile(path, []byte(`{"default": "perhaps"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Reload(); err == nil {
+		t.Error("expected invalid policy to fail")
+	}
+	if allowed, _ := e.Policy().Evaluate(nil, "order.create"); !allowed {
+		t.Error("expected previous policy to be kept")
+	}
+}
@@ -0,0 +1,150 @@
+package acl
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/logger"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// LoggerName 记录拒绝授权的日志名称
+const LoggerName = "QTPACL"
+
+// Enforcer 授权执行器，在消息交给回调前以连接认证通过的主体检查授权策略
+// 策略可在运行中通过SetPolicy替换，或通过Reload、Watch从文件重新加载
+type Enforcer struct {
+	logger.QLoggerAccessor
+	policy atomic.Pointer[Policy]
+	// 策略文件路径，为空时不能从文件重新加载
+	path string
+}
+
+// NewEnforcer 以policy创建授权执行器，拒绝授权的消息记录在名称为LoggerName的日志中
+func NewEnforcer(policy *Policy) (*Enforcer, *errors.QError) {
+	e := &Enforcer{}
+	if err := e.SetPolicy(policy); err != nil {
+		return nil, err
+	}
+	logger.GetLogConfig(LoggerName).Level = logger.WarnLevel
+	e.SetLogger(logger.GetQLogger(LoggerName))
+	return e, nil
+}
+
+// LoadEnforcer 从JSON策略文件创建授权执行器
+func LoadEnforcer(path string) (*Enforcer, *errors.QError) {
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	e, err := NewEnforcer(policy)
+	if err != nil {
+		return nil, err
+	}
+	e.path = path
+	return e, nil
+}
+
+// Policy 获取当前的授权策略，返回的策略不应被修改
+func (e *Enforcer) Policy() *Policy {
+	return e.policy.Load()
+}
+
+// SetPolicy 替换授权策略，对之后收到的消息生效
+func (e *Enforcer) SetPolicy(policy *Policy) *errors.QError {
+	if policy == nil {
+		return errors.New("授权策略不能为空")
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	e.policy.Store(policy)
+	return nil
+}
+
+// Reload 从策略文件重新加载授权策略，加载失败时保留原有策略
+func (e *Enforcer) Reload() *errors.QError {
+	if e.path == "" {
+		return errors.New("授权执行器未关联策略文件")
+	}
+	policy, err := LoadPolicy(e.path)
+	if err != nil {
+		return err
+	}
+	return e.SetPolicy(policy)
+}
+
+// Watch 每隔interval检查一次策略文件，文件修改后重新加载，返回停止检查的函数
+// 加载失败时记录日志并保留原有策略
+func (e *Enforcer) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	modTime := time.Time{}
+	if info, err := os.Stat(e.path); err == nil {
+		modTime = info.ModTime()
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				info, err := os.Stat(e.path)
+				if err != nil || info.ModTime().Equal(modTime) {
+					continue
+				}
+				modTime = info.ModTime()
+				if err := e.Reload(); err != nil {
+					err.WithMessage("授权策略重新加载失败，继续使用原有策略")
+					e.GetLogger().Warn(err.Error())
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once atomic.Bool
+	return func() {
+		if once.CompareAndSwap(false, true) {
+			close(done)
+		}
+	}
+}
+
+// Allowed 判断是否允许sender所在的连接发送data，拒绝时记录日志
+func (e *Enforcer) Allowed(sender *send.QTPSender, data *qc.QTPData) bool {
+	principal := sender.Principal()
+	route := data.Route()
+	allowed, rule := e.Policy().Evaluate(principal, route)
+	if !allowed {
+		name := "<未认证>"
+		if principal != nil {
+			name = principal.Name
+		}
+		if conn, ok := sender.GetQTPConn().(net.Conn); ok {
+			name += "(" + conn.RemoteAddr().String() + ")"
+		}
+		by := "默认策略"
+		if rule != nil {
+			by = "规则" + rule.Route
+		}
+		e.GetLogger().Warn("拒绝" + name + "发送路由为\"" + route + "\"的消息，依据" + by)
+	}
+	return allowed
+}
+
+// Interceptor 执行授权检查的入站拦截器，拒绝授权的消息不会交给回调，发送方的LCE收到qc.PermissionDeniedError类型的异常
+// 数据流在第一个分片时以数据流的路由授权，拒绝授权时SendStream返回该异常；
+// 对端发起的逻辑通道以通道名称为路由授权，拒绝授权时OpenChannel返回该异常，授权后通道内的消息不再逐条检查
+func (e *Enforcer) Interceptor() receive.InboundInterceptor {
+	return func(sender *send.QTPSender, data *qc.QTPData, next receive.InboundHandler) {
+		if !e.Allowed(sender, data) {
+			data.RejectWith(qc.Nack{Reason: "没有发送路由\"" + data.Route() + "\"的权限", Code: qc.NackPermissionDenied})
+			return
+		}
+		next(sender, data)
+	}
+}
@@ -0,0 +1,115 @@
+package acl
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/qc"
+	"encoding/json"
+	"os"
+)
+
+// 授权策略由按顺序匹配的规则组成，第一条同时匹配消息路由与连接主体的规则决定是否允许，没有规则匹配时使用Default
+// 路由规则与CallBackHandler.Route相同：完整的路由名称、以".*"结尾的前缀规则或单独的"*"，没有路由的消息只能被"*"匹配
+
+// Effect 规则的效果
+type Effect string
+
+const (
+	// Allow 允许
+	Allow Effect = "allow"
+	// Deny 拒绝
+	Deny Effect = "deny"
+)
+
+// AnyPrincipal Rule.Principals中匹配所有已认证主体的名称
+const AnyPrincipal = "*"
+
+// Rule 授权规则
+type Rule struct {
+	// 路由规则
+	Route string `json:"route"`
+	// 匹配的主体名称，AnyPrincipal匹配所有已认证主体
+	Principals []string `json:"principals,omitempty"`
+	// 匹配的角色，主体拥有任一角色即匹配
+	Roles []string `json:"roles,omitempty"`
+	// 规则的效果
+	Effect Effect `json:"effect"`
+}
+
+// 规则是否匹配主体，Principals与Roles均为空时匹配所有连接（包括未认证的连接）
+func (r *Rule) matchPrincipal(principal *auth.Principal) bool {
+	if len(r.Principals) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, name := range r.Principals {
+		if name == AnyPrincipal || name == principal.Name {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy 授权策略
+type Policy struct {
+	// 没有规则匹配时的效果，为空时拒绝
+	Default Effect `json:"default,omitempty"`
+	// 按顺序匹配的规则
+	Rules []Rule `json:"rules"`
+}
+
+// Validate 校验策略中的效果与路由规则
+func (p *Policy) Validate() *errors.QError {
+	if p.Default != "" && p.Default != Allow && p.Default != Deny {
+		return errors.New("非法的默认效果: " + string(p.Default))
+	}
+	for _, rule := range p.Rules {
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return errors.New("路由规则" + rule.Route + "的效果非法: " + string(rule.Effect))
+		}
+		if err := qc.CheckRoutePattern(rule.Route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate 判断是否允许principal发送路由为route的消息，同时返回决定结果的规则，由Default决定时规则为nil
+// principal为nil表示未认证的连接
+func (p *Policy) Evaluate(principal *auth.Principal, route string) (bool, *Rule) {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if qc.MatchRoute(rule.Route, route) && rule.matchPrincipal(principal) {
+			return rule.Effect == Allow, rule
+		}
+	}
+	return p.Default == Allow, nil
+}
+
+// ParsePolicy 解析JSON格式的授权策略
+func ParsePolicy(data []byte) (*Policy, *errors.QError) {
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, errors.New("授权策略解析失败，" + err.Error())
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// LoadPolicy 从JSON文件加载授权策略
+func LoadPolicy(path string) (*Policy, *errors.QError) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return ParsePolicy(data)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+)
+
+// Principal 认证通过的连接主体
+type Principal struct {
+	// 主体名称
+	Name string
+	// 主体拥有的角色
+	Roles []string
+	// 认证方式
+	Method string
+}
+
+// HasRole 判断主体是否拥有role角色
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator 服务端认证器，同一个认证器会被所有连接并发使用
+type Authenticator interface {
+	// Method 认证方式名称，需与客户端Credential.Method一致
+	Method() string
+	// Challenge 生成发送给客户端的质询数据，不需要质询时返回nil
+	Challenge() ([]byte, *errors.QError)
+	// Verify 校验客户端对challenge的应答，认证通过时返回主体
+	Verify(challenge []byte, response qc.AuthResponse) (*Principal, *errors.QError)
+}
+
+// Credential 客户端凭据，用于应答服务端的认证质询
+type Credential interface {
+	// Method 认证方式名称
+	Method() string
+	// Respond 应答服务端发送的质询数据
+	Respond(challenge []byte) (qc.AuthResponse, *errors.QError)
+}
+
+// 认证失败的异常
+func failed(reason string) *errors.QError {
+	return errors.NewKind(qc.AuthError, reason)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"QuantumUtils/qnet/qc"
+	"testing"
+)
+
+func TestTokenAuthenticator(t *testing.T) {
+	a := NewTokenAuthenticator(map[string]Principal{
+		"t-admin": {Name: "admin", Roles: []string{"admin"}},
+		"t-guest": {Name: "guest"},
+	})
+	response, _ := Token("t-admin").Respond(nil)
+	principal, err := a.Verify(nil, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "admin" || !principal.HasRole("admin") || principal.Method != MethodToken {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+	if _, err := a.Verify(nil, qc.AuthResponse{Method: MethodToken, Proof: []byte("t-admin2")}); !err.IsKind(qc.AuthError) {
+		t.Errorf("expected invalid token to fail, got %v", err)
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("s3cret")
+	a := NewHMACAuthenticator(map[string]HMACKey{"svc": {Secret: secret, Roles: []string{"writer"}}})
+	challenge, err := a.Challenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := HMAC{Identity: "svc", Secret: secret}.Respond(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	principal, err := a.Verify(challenge, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "svc" || !principal.HasRole("writer") {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+
+	// 应答无法用于其他质询
+	other, _ := a.Challenge()
+	if _, err := a.Verify(other, response); !err.IsKind(qc.AuthError) {
+		t.Errorf("expected replayed response to fail, got %v", err)
+	}
+	wrong, _ := HMAC{Identity: "svc", Secret: []byte("guess")}.Respond(challenge)
+	if _, err := a.Verify(challenge, wrong); !err.IsKind(qc.AuthError) {
+		t.Errorf("expected wrong secret to fail, got %v", err)
+	}
+	unknown, _ := HMAC{Identity: "nobody", Secret: secret}.Respond(challenge)
+	if _, err := a.Verify(challenge, unknown); !err.IsKind(qc.AuthError) {
+		t.Errorf("expected unknown identity to fail, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// MethodHMAC HMAC质询应答认证，客户端以密钥对服务端的随机质询计算HMAC-SHA256，密钥不会在连接上传输
+const MethodHMAC = "hmac-sha256"
+
+// 质询数据的byte长度
+const challengeSize = 32
+
+// HMACKey 一个身份的HMAC密钥
+type HMACKey struct {
+	// 密钥
+	Secret []byte
+	// 认证通过后主体拥有的角色
+	Roles []string
+}
+
+// HMACAuthenticator HMAC质询应答认证器
+type HMACAuthenticator struct {
+	keys map[string]HMACKey
+}
+
+// NewHMACAuthenticator 以身份与密钥的对应关系创建HMAC认证器，认证通过的主体以身份为名称
+func NewHMACAuthenticator(keys map[string]HMACKey) *HMACAuthenticator {
+	return &HMACAuthenticator{keys: keys}
+}
+
+// Method 实现Authenticator
+func (a *HMACAuthenticator) Method() string {
+	return MethodHMAC
+}
+
+// Challenge 实现Authenticator，生成随机质询
+func (a *HMACAuthenticator) Challenge() ([]byte, *errors.QError) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return challenge, nil
+}
+
+// Verify 实现Authenticator
+func (a *HMACAuthenticator) Verify(challenge []byte, response qc.AuthResponse) (*Principal, *errors.QError) {
+	key, ok := a.keys[response.Identity]
+	if !ok {
+		// 未知身份同样计算一次HMAC，避免通过响应时间判断身份是否存在
+		hmac.Equal(sign(nil, challenge), response.Proof)
+		return nil, failed("身份或签名无效")
+	}
+	if !hmac.Equal(sign(key.Secret, challenge), response.Proof) {
+		return nil, failed("身份或签名无效")
+	}
+	return &Principal{Name: response.Identity, Roles: key.Roles, Method: MethodHMAC}, nil
+}
+
+// HMAC HMAC凭据
+type HMAC struct {
+	// 身份
+	Identity string
+	// 密钥
+	Secret []byte
+}
+
+// Method 实现Credential
+func (c HMAC) Method() string {
+	return MethodHMAC
+}
+
+// Respond 实现Credential，以密钥对质询计算HMAC-SHA256
+func (c HMAC) Respond(challenge []byte) (qc.AuthResponse, *errors.QError) {
+	if len(challenge) != challengeSize {
+		return qc.AuthResponse{}, failed("质询数据长度无效")
+	}
+	return qc.AuthResponse{Method: MethodHMAC, Identity: c.Identity, Proof: sign(c.Secret, challenge)}, nil
+}
+
+func sign(secret []byte, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(MethodHMAC))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
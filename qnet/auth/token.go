@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"crypto/subtle"
+)
+
+// MethodToken 令牌认证，客户端直接发送令牌，建议在TLS连接上使用
+const MethodToken = "token"
+
+// TokenAuthenticator 令牌认证器，每个令牌对应一个主体
+type TokenAuthenticator struct {
+	tokens map[string]Principal
+}
+
+// NewTokenAuthenticator 以令牌与主体的对应关系创建令牌认证器，主体的Method会被设置为MethodToken
+func NewTokenAuthenticator(tokens map[string]Principal) *TokenAuthenticator {
+	a := &TokenAuthenticator{tokens: make(map[string]Principal, len(tokens))}
+	for token, principal := range tokens {
+		principal.Method = MethodToken
+		a.tokens[token] = principal
+	}
+	return a
+}
+
+// NewSharedSecretAuthenticator 创建共享密钥认证器，持有secret的客户端均认证为名称为name的主体
+func NewSharedSecretAuthenticator(secret string, name string, roles ...string) *TokenAuthenticator {
+	return NewTokenAuthenticator(map[string]Principal{secret: {Name: name, Roles: roles}})
+}
+
+// Method 实现Authenticator
+func (a *TokenAuthenticator) Method() string {
+	return MethodToken
+}
+
+// Challenge 实现Authenticator，令牌认证不需要质询
+func (a *TokenAuthenticator) Challenge() ([]byte, *errors.QError) {
+	return nil, nil
+}
+
+// Verify 实现Authenticator，以固定时间比较所有令牌，避免通过响应时间猜测令牌
+func (a *TokenAuthenticator) Verify(challenge []byte, response qc.AuthResponse) (*Principal, *errors.QError) {
+	var matched *Principal
+	for token, principal := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), response.Proof) == 1 {
+			p := principal
+			matched = &p
+		}
+	}
+	if matched == nil {
+		return nil, failed("令牌无效")
+	}
+	return matched, nil
+}
+
+// Token 令牌凭据
+type Token string
+
+// Method 实现Credential
+func (t Token) Method() string {
+	return MethodToken
+}
+
+// Respond 实现Credential
+func (t Token) Respond(challenge []byte) (qc.AuthResponse, *errors.QError) {
+	return qc.AuthResponse{Method: MethodToken, Proof: []byte(t)}, nil
+}
@@ -5,6 +5,7 @@ import (
 	"QuantumUtils/goroutine"
 	"QuantumUtils/logger"
 	"QuantumUtils/qnet/connect"
+	"QuantumUtils/qnet/encrypt"
 	"QuantumUtils/qnet/qio"
 	"QuantumUtils/qnet/receive"
 	"QuantumUtils/qnet/send"
@@ -13,6 +14,14 @@ import (
 
 // NewQuantumClient 新建一个基于普通TCP连接的Client
 func NewQuantumClient(address string, sConf send.QTPSenderConfig, wConf qio.QTPWriterConfig, handler *receive.CallBackHandler) (*send.QTPSender, *errors.QError) {
+	conf := QTPClientConfigDefault()
+	conf.SConf = sConf
+	conf.WConf = wConf
+	return NewQuantumClientWithConfig(address, conf, handler)
+}
+
+// NewQuantumClientWithConfig 使用指定配置新建一个基于普通TCP连接的Client
+func NewQuantumClientWithConfig(address string, conf QTPClientConfig, handler *receive.CallBackHandler) (*send.QTPSender, *errors.QError) {
 	reconnectHandle := func() (conn net.Conn, err *errors.QError) {
 		return GetDial(address)
 	}
@@ -25,13 +34,30 @@ func NewQuantumClient(address string, sConf send.QTPSenderConfig, wConf qio.QTPW
 	qLogger := logger.GetQLogger("QTPClient")
 
 	gm := &goroutine.GoManager{}
-	rc := connect.NewReconnecter(sConf.ReconnectAttempts, reconnectHandle)
+	rc := connect.NewReconnecter(conf.SConf.ReconnectAttempts, reconnectHandle)
 	qConn := connect.NewQTPConn(conn, rc)
 
-	sender := send.NewSender(wConf.SendCap)
+	sender := send.NewSender(conf.WConf.SendCap)
 	receiver := receive.NewQTPReceiver()
-	writer := qio.NewQTPWriter(qConn, wConf)
-	reader := qio.NewQTPReader(qConn, qio.QTPReaderConfigDefault())
+	writer := qio.NewQTPWriter(qConn, conf.WConf)
+	reader := qio.NewQTPReader(qConn, conf.RConf)
+
+	// 设置配置
+	sender.SetConfig(conf.SConf)
+	rc.OnReconnected(receiver.Reconnected)
+	rc.OnReconnected(sender.Reconnected)
+	rc.OnRewrite(sender.DropStale)
+
+	// 设置加密会话
+	if conf.SConf.EConfig.Keyring != nil {
+		session, err := encrypt.NewSession(conf.SConf.EConfig)
+		if err != nil {
+			_ = qConn.Close()
+			return nil, err
+		}
+		sender.SetSession(session)
+		reader.SetSession(session)
+	}
 
 	// 设置conn
 	sender.SetQTPConn(qConn)
@@ -41,6 +67,7 @@ func NewQuantumClient(address string, sConf send.QTPSenderConfig, wConf qio.QTPW
 	sender.SetLogger(qLogger)
 	rc.SetLogger(qLogger)
 	receiver.SetLogger(qLogger)
+	reader.SetLogger(qLogger)
 
 	// 设置gm
 	sender.SetGoManager(gm)
@@ -63,5 +90,12 @@ func NewQuantumClient(address string, sConf send.QTPSenderConfig, wConf qio.QTPW
 	receiver.Start()
 	writer.Start()
 	sender.Start()
+	// 配置了凭据时等待服务端的认证结果
+	if conf.SConf.Credential != nil {
+		if err := sender.WaitAuth(); err != nil {
+			_ = sender.Close()
+			return nil, err
+		}
+	}
 	return sender, nil
 }
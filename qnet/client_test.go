@@ -0,0 +1,1268 @@
+package qnet
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/acl"
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/encrypt"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qio"
+	"QuantumUtils/qnet/ratelimit"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"QuantumUtils/qnet/sign"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 在随机端口上启动一个Server
+func startServer(t *testing.T, cb *receive.CallBackHandler, conf QTPServerConfig) *QTPServer {
+	server, err := NewQuantumServerWithConfig("127.0.0.1:0", cb, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	return server
+}
+
+// 等待cond成立，超时则测试失败
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name":"quantum","value":42}`), 200)
+	received := make(chan []byte, 1)
+	serverCb := receive.NewCallBackHandler()
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- append([]byte(nil), data.Data...)
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.CConfig.Codecs = []string{"zlib", "gzip"}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	waitFor(t, func() bool { return sender.CompressCodec() == "zlib" })
+
+	done := make(chan *errors.QError, 1)
+	sender.SendSyncACK(payload, qc.JSON, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; !bytes.Equal(data, payload) {
+		t.Error("payload mismatch after compression")
+	}
+}
+
+func TestChecksumNegotiation(t *testing.T) {
+	received := make(chan *qc.QTPData, 1)
+	serverCb := receive.NewCallBackHandler()
+	serverCb.AsyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- data
+	})
+	serverConf := QTPServerConfigDefault()
+	serverConf.SConf.Checksum = true
+	server := startServer(t, serverCb, serverConf)
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.Checksum = true
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	waitFor(t, sender.Checksum)
+
+	done := make(chan *errors.QError, 1)
+	sender.SendAsyncACK([]byte("checked"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	data := <-received
+	if !data.Header.HasChecksum() || string(data.Data) != "checked" {
+		t.Errorf("unexpected frame %+v", data.Header)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	received := make(chan *qc.QTPData, 1)
+	serverCb := receive.NewCallBackHandler()
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- data
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	conf := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	conf.Extensions.SetRoute("user.login")
+	done := make(chan *errors.QError, 1)
+	sender.Send([]byte(`{}`), conf, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	data := <-received
+	if data.Header.Version != 2 || data.Route() != "user.login" {
+		t.Errorf("unexpected frame version %d route %q", data.Header.Version, data.Route())
+	}
+
+	// 只允许v1版本时无法发送带扩展的消息
+	v1Conf := QTPClientConfigDefault()
+	v1Conf.SConf.Versions = []uint8{1}
+	v1Sender, err := NewQuantumClientWithConfig(server.Addr().String(), v1Conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v1Sender.Close()
+	v1Sender.Send([]byte(`{}`), conf, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	if err := <-done; !err.IsKind(qc.UnsupportedError) {
+		t.Errorf("expected v1 connection to reject extensions, got %v", err)
+	}
+	if v1Sender.Version() != 1 {
+		t.Errorf("expected v1, got v%d", v1Sender.Version())
+	}
+}
+
+func TestFrameSizeLimit(t *testing.T) {
+	closed := make(chan *errors.QError, 2)
+	serverCb := receive.NewCallBackHandler()
+	serverCb.ConnClosed(func(err *errors.QError) {
+		closed <- err
+	})
+	serverConf := QTPServerConfigDefault()
+	serverConf.RConf.MaxFrameSize = 1024
+	server := startServer(t, serverCb, serverConf)
+
+	// 超长的消息与解压后超长的消息都会被拒绝
+	for _, codecs := range [][]string{nil, {"gzip"}} {
+		conf := QTPClientConfigDefault()
+		conf.SConf.CConfig.Codecs = codecs
+		sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codecs != nil {
+			waitFor(t, func() bool { return sender.CompressCodec() == "gzip" })
+		}
+		sender.SendNoACK(make([]byte, 4096), qc.BINARY, func(seq uint64, err *errors.QError) {})
+		select {
+		case err := <-closed:
+			if !err.IsKind(qc.FrameSizeError) {
+				t.Errorf("expected FrameSizeError, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("server did not close the connection")
+		}
+		_ = sender.Close()
+	}
+}
+
+// 读取到指定位置后返回异常的Reader
+type failingReader struct {
+	data []byte
+	fail int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.fail <= 0 {
+		return 0, io.ErrClosedPipe
+	}
+	if len(p) > r.fail {
+		p = p[:r.fail]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	r.fail -= n
+	return n, nil
+}
+
+func TestSendStream(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	_, _ = rand.Read(payload)
+	gate := make(chan struct{})
+	type result struct {
+		route string
+		data  []byte
+		err   error
+	}
+	streams := make(chan result, 2)
+	received := make(chan []byte, 1)
+	serverCb := receive.NewCallBackHandler()
+	serverCb.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		<-gate
+		data, err := io.ReadAll(stream)
+		streams <- result{route: stream.Header.Extensions.Route(), data: data, err: err}
+	})
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- append([]byte(nil), data.Data...)
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.STConfig = send.StreamConfig{ChunkSize: 16 << 10, Window: 2}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	sent := make(chan *errors.QError, 1)
+	go func() {
+		streamConf := qc.QTPConfig{Encode: qc.BINARY}
+		streamConf.Extensions.SetRoute("upload")
+		sent <- sender.SendStream(bytes.NewReader(payload), streamConf)
+	}()
+	// 接收方未读取时发送被阻塞，但其他消息依然可以穿插发送
+	sender.SendSyncACK([]byte("interleaved"), qc.BINARY, func(seq uint64, err *errors.QError) {})
+	if data := <-received; string(data) != "interleaved" {
+		t.Errorf("unexpected message %q", data)
+	}
+	select {
+	case err := <-sent:
+		t.Fatalf("SendStream returned before the stream was read: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(gate)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	res := <-streams
+	if res.err != nil || res.route != "upload" || !bytes.Equal(res.data, payload) {
+		t.Errorf("stream mismatch: route %q, %d bytes, %v", res.route, len(res.data), res.err)
+	}
+
+	// 发送方读取失败时中止数据流
+	if err := sender.SendStream(&failingReader{data: payload, fail: 40 << 10}, qc.QTPConfig{Encode: qc.BINARY}); err == nil {
+		t.Error("expected SendStream to report the read error")
+	}
+	if res := <-streams; res.err == nil || len(res.data) != 40<<10 {
+		t.Errorf("expected aborted stream after %d bytes, got %d bytes, %v", 40<<10, len(res.data), res.err)
+	}
+}
+
+func TestStreamReconnect(t *testing.T) {
+	payload := make([]byte, 1<<20)
+	conns := make(chan *send.QTPSender, 2)
+	opened := make(chan struct{}, 2)
+	readErrs := make(chan error, 2)
+	gate := make(chan struct{})
+	serverCb := receive.NewCallBackHandler()
+	serverCb.ConnInit(func(sender *send.QTPSender) {
+		conns <- sender
+	})
+	serverCb.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		opened <- struct{}{}
+		<-gate
+		_, err := io.ReadAll(stream)
+		readErrs <- err
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.STConfig = send.StreamConfig{ChunkSize: 16 << 10, Window: 2}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	serverSide := <-conns
+
+	// 发送方等待额度时连接断开，重连后SendStream返回异常而不是一直阻塞
+	sent := make(chan *errors.QError, 1)
+	go func() {
+		sent <- sender.SendStream(bytes.NewReader(payload), qc.QTPConfig{Encode: qc.BINARY})
+	}()
+	<-opened
+	_ = serverSide.GetQTPConn().Close()
+	select {
+	case err := <-sent:
+		if err == nil {
+			t.Error("expected SendStream to fail after reconnect")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("SendStream blocked after reconnect")
+	}
+	close(gate)
+	if err := <-readErrs; err == nil || err == io.EOF {
+		t.Errorf("expected receiving stream to fail, got %v", err)
+	}
+
+	// 重连后可以发送新的数据流
+	<-conns
+	if err := sender.SendStream(bytes.NewReader(payload), qc.QTPConfig{Encode: qc.BINARY}); err != nil {
+		t.Fatal(err)
+	}
+	<-opened
+	if err := <-readErrs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannels(t *testing.T) {
+	gate := make(chan struct{})
+	slow := make(chan string, 4)
+	closed := make(chan *errors.QError, 1)
+	serverCb := receive.NewCallBackHandler()
+	slowH := receive.NewChannelHandler()
+	slowH.SyncACK(func(ch *send.Channel, data *qc.QTPData) {
+		<-gate
+		slow <- string(data.Data)
+	})
+	slowH.Closed(func(ch *send.Channel, err *errors.QError) {
+		closed <- err
+	})
+	serverCb.Channel("slow", slowH)
+	echoH := receive.NewChannelHandler()
+	echoH.SyncACK(func(ch *send.Channel, data *qc.QTPData) {
+		ch.SendAsyncACK(append([]byte("echo:"), data.Data...), qc.BINARY, func(seq uint64, err *errors.QError) {})
+	})
+	serverCb.Channel("echo", echoH)
+	received := make(chan string, 1)
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- string(data.Data)
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	if _, err := receive.OpenChannel(sender, "unknown", receive.NewChannelHandler()); err == nil {
+		t.Error("expected unknown channel to be rejected")
+	}
+	slowCh, err := receive.OpenChannel(sender, "slow", receive.NewChannelHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoes := make(chan string, 1)
+	clientEchoH := receive.NewChannelHandler()
+	clientEchoH.AsyncACK(func(ch *send.Channel, data *qc.QTPData) {
+		echoes <- string(data.Data)
+	})
+	echoCh, err := receive.OpenChannel(sender, "echo", clientEchoH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slowCh.ID() == echoCh.ID() {
+		t.Fatalf("channels share id %d", slowCh.ID())
+	}
+
+	// slow通道的SyncACK回调阻塞时，连接上的其他消息与其他通道不受影响
+	lce := func(seq uint64, err *errors.QError) {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	slowCh.SendSyncACK([]byte("first"), qc.BINARY, lce)
+	slowCh.SendSyncACK([]byte("second"), qc.BINARY, lce)
+	sender.SendSyncACK([]byte("main"), qc.BINARY, lce)
+	if data := <-received; data != "main" {
+		t.Errorf("unexpected message %q", data)
+	}
+	echoCh.SendSyncACK([]byte("ping"), qc.BINARY, lce)
+	if data := <-echoes; data != "echo:ping" {
+		t.Errorf("unexpected echo %q", data)
+	}
+
+	// 通道内的消息依然按发送顺序处理
+	close(gate)
+	if first, second := <-slow, <-slow; first != "first" || second != "second" {
+		t.Errorf("unexpected order %q, %q", first, second)
+	}
+	if err := slowCh.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-closed; err == nil {
+		t.Error("expected the acceptor to see the channel closed by its peer")
+	}
+	done := make(chan *errors.QError, 1)
+	slowCh.SendNoACK([]byte("late"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	if err := <-done; err == nil {
+		t.Error("expected send on a closed channel to fail")
+	}
+}
+
+type order struct {
+	ID    int
+	Items []string
+}
+
+func TestTypedMessages(t *testing.T) {
+	received := make(chan order, 2)
+	serverCb := receive.NewCallBackHandler()
+	receive.Handle(serverCb, func(ctx *receive.Context, o order) {
+		if o.ID < 0 {
+			ctx.Nack("非法的订单ID")
+			return
+		}
+		received <- o
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	deliver := func(v any, conf qc.QTPConfig) *errors.QError {
+		result := make(chan *errors.QError, 1)
+		if err := send.Send(sender, v, conf, func(seq uint64, err *errors.QError) {
+			result <- err
+		}); err != nil {
+			return err
+		}
+		return <-result
+	}
+	want := order{ID: 7, Items: []string{"a", "b"}}
+	for _, encode := range []qc.Encode{qc.JSON, qc.GOB} {
+		if err := deliver(want, qc.QTPConfig{Encode: encode, ACKType: qc.SyncACK}); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got.ID != want.ID || len(got.Items) != 2 {
+			t.Errorf("encode %d: unexpected order %+v", encode, got)
+		}
+	}
+
+	// 解码失败与回调拒绝都以NACK应答
+	if err := deliver([]byte("{"), qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.AsyncACK}); !err.IsKind(qc.NackError) {
+		t.Errorf("expected decode failure to be nacked, got %v", err)
+	}
+	if err := deliver(order{ID: -1}, qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}); !err.IsKind(qc.NackError) {
+		t.Errorf("expected rejected order to be nacked, got %v", err)
+	}
+	if err := deliver(make(chan int), qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}); err == nil {
+		t.Error("expected marshal failure")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	received := make(chan string, 4)
+	if err := receive.HandleRoute(serverCb, "order.create", func(ctx *receive.Context, o order) {
+		received <- ctx.Route()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := serverCb.Route("admin.*", func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- data.Route()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	deliver := func(route string) *errors.QError {
+		conf := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+		conf.Extensions.SetRoute(route)
+		result := make(chan *errors.QError, 1)
+		if err := send.Send(sender, order{ID: 1}, conf, func(seq uint64, err *errors.QError) {
+			result <- err
+		}); err != nil {
+			return err
+		}
+		return <-result
+	}
+	for _, route := range []string{"order.create", "admin.reload"} {
+		if err := deliver(route); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != route {
+			t.Errorf("route %q dispatched as %q", route, got)
+		}
+	}
+	if err := deliver("order.delete"); !err.IsKind(qc.RouteNotFoundError) {
+		t.Errorf("expected RouteNotFound, got %v", err)
+	}
+
+	// 运行中增删路由
+	serverCb.RemoveRoute("admin.*")
+	if err := deliver("admin.reload"); !err.IsKind(qc.RouteNotFoundError) {
+		t.Errorf("expected removed route to be gone, got %v", err)
+	}
+	serverCb.Fallback(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- "fallback:" + data.Route()
+	})
+	if err := deliver("admin.reload"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "fallback:admin.reload" {
+		t.Errorf("unexpected fallback dispatch %q", got)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	received := make(chan string, 4)
+	var order []string
+	serverCb.Use(receive.Recover(), func(sender *send.QTPSender, data *qc.QTPData, next receive.InboundHandler) {
+		order = append(order, "outer")
+		next(sender, data)
+	}, func(sender *send.QTPSender, data *qc.QTPData, next receive.InboundHandler) {
+		order = append(order, "inner")
+		if string(data.Data) == "FORBIDDEN" {
+			data.Reject("forbidden")
+			return
+		}
+		data.Data = append([]byte("in:"), data.Data...)
+		next(sender, data)
+	})
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		if string(data.Data) == "in:PANIC" {
+			panic("boom")
+		}
+		received <- string(data.Data)
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.Interceptors = []send.OutboundInterceptor{func(sr *qio.SendReq, next send.OutboundHandler) *errors.QError {
+		switch string(sr.Data) {
+		case "drop":
+			return nil
+		case "deny":
+			return errors.New("denied")
+		}
+		sr.Data = bytes.ToUpper(sr.Data)
+		return next(sr)
+	}}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	deliver := func(data string) *errors.QError {
+		result := make(chan *errors.QError, 1)
+		sender.SendSyncACK([]byte(data), qc.BINARY, func(seq uint64, err *errors.QError) {
+			result <- err
+		})
+		return <-result
+	}
+	if err := deliver("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "in:HELLO" {
+		t.Errorf("unexpected intercepted data %q", got)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("unexpected interceptor order %v", order)
+	}
+	// 出站拦截器短路与拒绝
+	if err := deliver("drop"); !err.IsKind(qc.DroppedError) {
+		t.Errorf("expected dropped message to report %s, got %v", qc.DroppedError, err)
+	}
+	if err := deliver("deny"); err == nil {
+		t.Error("expected denied message to fail")
+	}
+	// 入站拦截器拒绝与panic恢复
+	if err := deliver("forbidden"); !err.IsKind(qc.NackError) {
+		t.Errorf("expected NACK, got %v", err)
+	}
+	if err := deliver("panic"); !err.IsKind(qc.NackError) {
+		t.Errorf("expected NACK after panic, got %v", err)
+	}
+	select {
+	case got := <-received:
+		t.Errorf("unexpected message %q reached the callback", got)
+	default:
+	}
+}
+
+func TestStreamChannelInterceptors(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	seen := make(chan string, 8)
+	serverCb.Use(func(sender *send.QTPSender, data *qc.QTPData, next receive.InboundHandler) {
+		kind := "message"
+		if _, ok := data.Header.Extensions.Stream(); ok {
+			kind = "stream"
+		} else if _, ok := data.Channel(); ok {
+			kind = "channel"
+		}
+		seen <- kind + ":" + data.Route()
+		if data.Route() == "private" {
+			data.Reject("private")
+			return
+		}
+		next(sender, data)
+	})
+	streams := make(chan string, 2)
+	serverCb.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		data, _ := io.ReadAll(stream)
+		streams <- stream.Header.Extensions.Route() + ":" + strconv.Itoa(len(data))
+	})
+	serverCb.Channel("public", receive.NewChannelHandler())
+	serverCb.Channel("private", receive.NewChannelHandler())
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.STConfig = send.StreamConfig{ChunkSize: 1 << 10, Window: 2}
+	var outbound atomic.Int32
+	conf.SConf.Interceptors = []send.OutboundInterceptor{func(sr *qio.SendReq, next send.OutboundHandler) *errors.QError {
+		outbound.Add(1)
+		return next(sr)
+	}}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// 数据流只有第一个分片经过两端的拦截器
+	payload := make([]byte, 8<<10)
+	streamConf := qc.QTPConfig{Encode: qc.BINARY}
+	streamConf.Extensions.SetRoute("public")
+	if err := sender.SendStream(bytes.NewReader(payload), streamConf); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-streams; got != "public:8192" {
+		t.Errorf("unexpected stream %q", got)
+	}
+	if got := <-seen; got != "stream:public" {
+		t.Errorf("unexpected interception %q", got)
+	}
+	if n := outbound.Load(); n != 1 {
+		t.Errorf("expected the outbound interceptor to see 1 chunk, saw %d", n)
+	}
+	streamConf.Extensions.SetRoute("private")
+	if err := sender.SendStream(bytes.NewReader(payload), streamConf); !err.IsKind(qc.NackError) {
+		t.Errorf("expected rejected stream, got %v", err)
+	}
+	<-seen
+
+	// 对端发起的通道以通道名称为路由经过拦截器
+	if _, err := receive.OpenChannel(sender, "public", receive.NewChannelHandler()); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-seen; got != "channel:public" {
+		t.Errorf("unexpected interception %q", got)
+	}
+	if _, err := receive.OpenChannel(sender, "private", receive.NewChannelHandler()); !err.IsKind(qc.NackError) {
+		t.Errorf("expected rejected channel, got %v", err)
+	}
+	select {
+	case got := <-streams:
+		t.Errorf("rejected stream %q reached the callback", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	inits := make(chan string, 4)
+	serverCb.ConnInit(func(sender *send.QTPSender) {
+		inits <- sender.Principal().Name
+	})
+	received := make(chan string, 4)
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- sender.Principal().Name + ":" + string(data.Data)
+	})
+	secret := []byte("s3cret")
+	serverConf := QTPServerConfigDefault()
+	serverConf.Authenticator = auth.NewHMACAuthenticator(map[string]auth.HMACKey{"svc": {Secret: secret}})
+	serverConf.SConf.AuthTimeout = 500 * time.Millisecond
+	server := startServer(t, serverCb, serverConf)
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.Credential = auth.HMAC{Identity: "svc", Secret: secret}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if got := <-inits; got != "svc" {
+		t.Errorf("unexpected principal %q in ConnInit", got)
+	}
+	result := make(chan *errors.QError, 1)
+	sender.SendSyncACK([]byte("hello"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		result <- err
+	})
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "svc:hello" {
+		t.Errorf("unexpected message %q", got)
+	}
+
+	// 错误的密钥与不匹配的认证方式
+	for _, credential := range []auth.Credential{auth.HMAC{Identity: "svc", Secret: []byte("guess")}, auth.Token("svc")} {
+		conf.SConf.Credential = credential
+		if _, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler()); !err.IsKind(qc.AuthError) {
+			t.Errorf("expected %T to fail authentication, got %v", credential, err)
+		}
+	}
+
+	// 未配置凭据的客户端发送的消息不会交给回调
+	anonymous, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer anonymous.Close()
+	anonymous.SendNoACK([]byte("sneaky"), qc.BINARY, func(seq uint64, err *errors.QError) {})
+	time.Sleep(800 * time.Millisecond)
+	select {
+	case got := <-received:
+		t.Errorf("unauthenticated message %q reached the callback", got)
+	case got := <-inits:
+		t.Errorf("unauthenticated connection %q reached ConnInit", got)
+	default:
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	received := make(chan string, 4)
+	serverCb.Fallback(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- data.Route()
+	})
+	policy, err := acl.ParsePolicy([]byte(`{"rules": [
+		{"route": "admin.*", "roles": ["admin"], "effect": "allow"},
+		{"route": "order.*", "principals": ["*"], "effect": "allow"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enforcer, err := acl.NewEnforcer(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCb.Use(enforcer.Interceptor())
+	streams := make(chan string, 2)
+	serverCb.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		_, _ = io.Copy(io.Discard, stream)
+		streams <- stream.Header.Extensions.Route()
+	})
+	serverCb.Channel("admin.console", receive.NewChannelHandler())
+	serverConf := QTPServerConfigDefault()
+	serverConf.Authenticator = auth.NewTokenAuthenticator(map[string]auth.Principal{
+		"t-admin": {Name: "alice", Roles: []string{"admin"}},
+		"t-user":  {Name: "bob"},
+	})
+	server := startServer(t, serverCb, serverConf)
+
+	senders := make(map[string]*send.QTPSender)
+	dial := func(token string) func(route string) *errors.QError {
+		conf := QTPClientConfigDefault()
+		conf.SConf.Credential = auth.Token(token)
+		sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sender.Close() })
+		senders[token] = sender
+		return func(route string) *errors.QError {
+			conf := qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.SyncACK}
+			conf.Extensions.SetRoute(route)
+			result := make(chan *errors.QError, 1)
+			sender.Send(nil, conf, func(seq uint64, err *errors.QError) {
+				result <- err
+			})
+			return <-result
+		}
+	}
+	admin, user := dial("t-admin"), dial("t-user")
+	for _, route := range []string{"admin.reload", "order.create"} {
+		if err := admin(route); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != route {
+			t.Errorf("route %q dispatched as %q", route, got)
+		}
+	}
+	if err := user("admin.reload"); !err.IsKind(qc.PermissionDeniedError) {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+
+	// 数据流以路由、逻辑通道以名称授权
+	streamConf := qc.QTPConfig{Encode: qc.BINARY}
+	streamConf.Extensions.SetRoute("admin.upload")
+	if err := senders["t-user"].SendStream(bytes.NewReader([]byte("payload")), streamConf); !err.IsKind(qc.PermissionDeniedError) {
+		t.Errorf("expected PermissionDenied for the stream, got %v", err)
+	}
+	if _, err := receive.OpenChannel(senders["t-user"], "admin.console", receive.NewChannelHandler()); !err.IsKind(qc.PermissionDeniedError) {
+		t.Errorf("expected PermissionDenied for the channel, got %v", err)
+	}
+	if err := senders["t-admin"].SendStream(bytes.NewReader([]byte("payload")), streamConf); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-streams; got != "admin.upload" {
+		t.Errorf("unexpected stream %q", got)
+	}
+	if _, err := receive.OpenChannel(senders["t-admin"], "admin.console", receive.NewChannelHandler()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-streams:
+		t.Errorf("unauthorized stream %q reached the callback", got)
+	default:
+	}
+
+	// 运行中替换策略
+	if err := enforcer.SetPolicy(&acl.Policy{Default: acl.Allow}); err != nil {
+		t.Fatal(err)
+	}
+	if err := user("admin.reload"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "admin.reload" {
+		t.Errorf("unexpected dispatch %q", got)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	keyring := func(psk []byte) *encrypt.Keyring {
+		k, err := encrypt.NewKeyring(1, psk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	serverCb := receive.NewCallBackHandler()
+	received := make(chan *qc.QTPData, 4)
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		received <- &qc.QTPData{Header: data.Header, Data: append([]byte(nil), data.Data...)}
+	})
+	closed := make(chan *errors.QError, 1)
+	serverCb.ConnClosed(func(err *errors.QError) {
+		closed <- err
+	})
+	serverConf := QTPServerConfigDefault()
+	serverConf.SConf.EConfig.Keyring = keyring(psk)
+	serverConf.SConf.EConfig.Required = true
+	server := startServer(t, serverCb, serverConf)
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.EConfig.Keyring = keyring(psk)
+	conf.SConf.CConfig.Codecs = []string{"gzip"}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	payload := bytes.Repeat([]byte("secret payload "), 200)
+	result := make(chan *errors.QError, 1)
+	sender.SendSyncACK(payload, qc.BINARY, func(seq uint64, err *errors.QError) {
+		result <- err
+	})
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	data := <-received
+	if !data.Encrypted() || !bytes.Equal(data.Data, payload) {
+		t.Errorf("unexpected message: encrypted %v, %d bytes", data.Encrypted(), len(data.Data))
+	}
+	if !sender.Encrypted() {
+		t.Error("expected encryption to be negotiated")
+	}
+
+	// 密钥不一致时认证失败，服务端断开连接；未配置密钥的客户端发送的明文消息同样被拒绝
+	for _, k := range []*encrypt.Keyring{keyring([]byte("0123456789abcdef0123456789abcdeX")), nil} {
+		conf := QTPClientConfigDefault()
+		conf.SConf.EConfig.Keyring = k
+		other, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+		if err != nil {
+			t.Fatal(err)
+		}
+		other.SendNoACK([]byte("hello"), qc.BINARY, func(seq uint64, err *errors.QError) {})
+		select {
+		case err := <-closed:
+			if !err.IsKind(qc.DecryptError) {
+				t.Errorf("expected DecryptError, got %v", err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("server did not close the connection")
+		}
+		_ = other.Close()
+	}
+}
+
+// 等待应答的加密消息在重连后以新连接的密钥无法解密，重连时以异常结束，不会导致新连接因解密失败断开
+func TestEncryptionReconnect(t *testing.T) {
+	keyring, err := encrypt.NewKeyring(1, []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan *send.QTPSender, 4)
+	received := make(chan string, 4)
+	closed := make(chan *errors.QError, 4)
+	var dropped atomic.Bool
+	serverCb := receive.NewCallBackHandler()
+	serverCb.ConnInit(func(sender *send.QTPSender) {
+		conns <- sender
+	})
+	serverCb.ConnClosed(func(err *errors.QError) {
+		closed <- err
+	})
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {
+		if !dropped.Swap(true) {
+			// 应答前断开连接，消息在客户端等待重发
+			_ = sender.GetQTPConn().Close()
+			return
+		}
+		received <- string(data.Data)
+	})
+	serverConf := QTPServerConfigDefault()
+	serverConf.SConf.EConfig.Keyring = keyring
+	serverConf.SConf.EConfig.Required = true
+	server := startServer(t, serverCb, serverConf)
+
+	conf := QTPClientConfigDefault()
+	conf.SConf.EConfig.Keyring = keyring
+	conf.WConf.RConfig.RetryTimeout = 100 * time.Millisecond
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	<-conns
+
+	result := make(chan *errors.QError, 1)
+	sender.SendSyncACK([]byte("pending"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		result <- err
+	})
+	select {
+	case err := <-result:
+		if err == nil || !err.IsKind(qc.StaleError) {
+			t.Errorf("expected the pending encrypted message to end with StaleError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending message did not end")
+	}
+	<-conns
+
+	// 新连接可以正常收发加密消息，且没有因解密失败断开
+	sender.SendSyncACK([]byte("after"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		result <- err
+	})
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; data != "after" {
+		t.Errorf("unexpected message %q", data)
+	}
+	time.Sleep(300 * time.Millisecond)
+	for {
+		select {
+		case err := <-closed:
+			if err.IsKind(qc.DecryptError) {
+				t.Fatalf("connection closed by %v", err)
+			}
+			continue
+		case <-conns:
+			t.Fatal("client reconnected more than once")
+		default:
+		}
+		break
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	trust := sign.NewTrustStore()
+	if err := trust.Add("deployer", pub); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := sign.NewVerifier(trust, "cmd.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCb := receive.NewCallBackHandler()
+	serverCb.Use(verifier.Interceptor())
+	received := make(chan string, 4)
+	serverCb.Fallback(func(sender *send.QTPSender, data *qc.QTPData) {
+		signer, _ := data.Signer()
+		received <- data.Route() + ":" + signer
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	dial := func(signer *sign.Signer) func(route string) *errors.QError {
+		conf := QTPClientConfigDefault()
+		if signer != nil {
+			interceptor, err := signer.Interceptor("cmd.*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.SConf.Interceptors = []send.OutboundInterceptor{interceptor}
+		}
+		sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sender.Close() })
+		return func(route string) *errors.QError {
+			conf := qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.SyncACK}
+			conf.Extensions.SetRoute(route)
+			result := make(chan *errors.QError, 1)
+			sender.Send([]byte("payload"), conf, func(seq uint64, err *errors.QError) {
+				result <- err
+			})
+			return <-result
+		}
+	}
+	signer, err := sign.NewSigner("deployer", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := dial(signer)
+	if err := trusted("cmd.deploy"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "cmd.deploy:deployer" {
+		t.Errorf("unexpected dispatch %q", got)
+	}
+
+	// 未签名的命令与不受信任的签名者被拒绝，不需要签名的路由不受影响
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	impostor, _ := sign.NewSigner("deployer", otherKey)
+	for _, deliver := range []func(string) *errors.QError{dial(nil), dial(impostor)} {
+		if err := deliver("cmd.deploy"); !err.IsKind(qc.SignatureError) {
+			t.Errorf("expected SignatureError, got %v", err)
+		}
+		if err := deliver("status.get"); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; got != "status.get:" {
+			t.Errorf("unexpected dispatch %q", got)
+		}
+	}
+}
+
+// 数据流的签名只能覆盖第一个分片，篡改后续分片无法被发现，因此必须签名的路由拒绝数据流
+func TestSignedStream(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	trust := sign.NewTrustStore()
+	if err := trust.Add("deployer", pub); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := sign.NewVerifier(trust, "cmd.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCb := receive.NewCallBackHandler()
+	serverCb.Use(verifier.Interceptor())
+	streams := make(chan string, 4)
+	serverCb.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		data, _ := io.ReadAll(stream)
+		streams <- stream.Header.Extensions.Route() + ":" + string(data)
+	})
+	server := startServer(t, serverCb, QTPServerConfigDefault())
+
+	signer, err := sign.NewSigner("deployer", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor, err := signer.Interceptor("cmd.deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := QTPClientConfigDefault()
+	conf.SConf.STConfig = send.StreamConfig{ChunkSize: 8, Window: 2}
+	conf.SConf.Interceptors = []send.OutboundInterceptor{
+		// 模拟为第一个分片签名、篡改后续分片的发送方
+		func(sr *qio.SendReq, next send.OutboundHandler) *errors.QError {
+			if sr.Config.Extensions.Route() == "cmd.forged" {
+				signer.Sign(&sr.Config, sr.Data)
+			}
+			return next(sr)
+		},
+		interceptor,
+	}
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	stream := func(route, payload string) *errors.QError {
+		streamConf := qc.QTPConfig{Encode: qc.BINARY}
+		streamConf.Extensions.SetRoute(route)
+		return sender.SendStream(bytes.NewReader([]byte(payload)), streamConf)
+	}
+	if err := stream("cmd.deploy", "version=1.2.0;run=true"); !err.IsKind(qc.UnsupportedError) {
+		t.Errorf("expected the signer to refuse the stream, got %v", err)
+	}
+	if err := stream("cmd.forged", "version=1.2.0;run=rm -rf"); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected the verifier to reject the stream, got %v", err)
+	}
+	if err := stream("files.upload", "unsigned data"); err != nil {
+		t.Fatal(err)
+	}
+	// 被拒绝的数据流没有交给回调
+	if got := <-streams; got != "files.upload:unsigned data" {
+		t.Errorf("unexpected stream %q", got)
+	}
+	select {
+	case got := <-streams:
+		t.Errorf("unexpected stream %q", got)
+	default:
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	send1 := func(sender *send.QTPSender) *errors.QError {
+		result := make(chan *errors.QError, 1)
+		sender.SendSyncACK([]byte("ping"), qc.BINARY, func(seq uint64, err *errors.QError) {
+			result <- err
+		})
+		return <-result
+	}
+
+	// 超过限制的消息以NACK拒绝，连接保持可用
+	serverCb := receive.NewCallBackHandler()
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {})
+	serverConf := QTPServerConfigDefault()
+	serverConf.RLConfig.Action = ratelimit.Nack
+	serverConf.RLConfig.PerConn = ratelimit.Rate{Messages: 0.5, MessageBurst: 2}
+	server := startServer(t, serverCb, serverConf)
+	sender, err := NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i < 2; i++ {
+		if err := send1(sender); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := send1(sender); !err.IsKind(qc.RateLimitError) {
+		t.Fatalf("expected RateLimitError, got %v", err)
+	}
+	if s := server.Limiter().Stats(); s.Admitted != 2 || s.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// 超过限制时断开连接
+	closed := make(chan *errors.QError, 1)
+	serverCb = receive.NewCallBackHandler()
+	serverCb.SyncACK(func(sender *send.QTPSender, data *qc.QTPData) {})
+	serverCb.ConnClosed(func(err *errors.QError) {
+		closed <- err
+	})
+	serverConf.RLConfig.Action = ratelimit.Disconnect
+	server = startServer(t, serverCb, serverConf)
+	sender, err = NewQuantumClientWithConfig(server.Addr().String(), QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i < 2; i++ {
+		if err := send1(sender); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sender.SendNoACK([]byte("ping"), qc.BINARY, func(seq uint64, err *errors.QError) {})
+	select {
+	case err := <-closed:
+		if !err.IsKind(qc.RateLimitError) {
+			t.Errorf("expected RateLimitError, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	waitFor(t, func() bool { return len(server.Limiter().IPStats()) == 0 })
+	if s := server.Limiter().Stats(); s.Disconnected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestConnectionGuard(t *testing.T) {
+	serverConf := QTPServerConfigDefault()
+	serverConf.Authenticator = auth.NewSharedSecretAuthenticator("s3cret", "svc")
+	serverConf.SConf.AuthTimeout = 500 * time.Millisecond
+	serverConf.GConfig.MaxConnsPerIP = 1
+	serverConf.GConfig.BanThreshold = 2
+	server := startServer(t, receive.NewCallBackHandler(), serverConf)
+	dial := func(token string) (*send.QTPSender, *errors.QError) {
+		conf := QTPClientConfigDefault()
+		conf.SConf.Credential = auth.Token(token)
+		conf.SConf.AuthTimeout = 500 * time.Millisecond
+		return NewQuantumClientWithConfig(server.Addr().String(), conf, receive.NewCallBackHandler())
+	}
+	// 未准入的连接被Server直接关闭
+	rejected := func() bool {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+
+	sender, err := dial("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 超过单个IP的连接数限制
+	if !rejected() {
+		t.Fatal("connection over the per ip limit was accepted")
+	}
+	if s := server.Guard().Stats(); s.Accepted != 1 || s.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	_ = sender.Close()
+	waitFor(t, func() bool { return server.Guard().Conns() == 0 })
+
+	// 多次认证失败后被临时封禁，解除封禁后恢复
+	waitFor(t, func() bool {
+		if _, err := dial("guess"); !err.IsKind(qc.AuthError) {
+			t.Fatalf("expected AuthError, got %v", err)
+		}
+		_, banned := server.Guard().Banned("127.0.0.1")
+		return banned
+	})
+	if !rejected() {
+		t.Fatal("banned address was accepted")
+	}
+	if s := server.Guard().Stats(); s.Violations < 2 || s.Bans != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	waitFor(t, func() bool { return server.Guard().Conns() == 0 })
+	if !server.Guard().Unban("127.0.0.1") {
+		t.Error("Unban reported no ban")
+	}
+	sender, err = dial("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = sender.Close()
+}
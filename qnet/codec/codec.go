@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"strconv"
+	"sync"
+)
+
+// Codec 数据编码方式，Encode会被写入消息头，接收方据此选择解码方式
+// 内置JSON、BINARY与GOB，其余编码方式（如protobuf、msgpack）可通过Register注册，收发两端需注册相同的编码方式
+type Codec interface {
+	// Encode 编码方式在消息头中的标识
+	Encode() qc.Encode
+	// Name 编码方式名称
+	Name() string
+	// Marshal 将v编码为byte切片
+	Marshal(v any) ([]byte, *errors.QError)
+	// Unmarshal 将data解码至v，v为指针
+	Unmarshal(data []byte, v any) *errors.QError
+}
+
+var (
+	codecMu     sync.RWMutex
+	codecs      = make(map[qc.Encode]Codec)
+	codecByName = make(map[string]Codec)
+)
+
+// Register 注册一个编码方式，标识超出qc.MaxEncode或标识、名称重复时会panic
+// 使用该编码方式发送消息前需确保对端也注册了相同的编码方式，否则对端会以NACK拒绝消息
+func Register(codec Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	if codec.Encode() > qc.MaxEncode {
+		panic("编码方式标识超出范围: " + strconv.Itoa(int(codec.Encode())))
+	}
+	if _, ok := codecs[codec.Encode()]; ok {
+		panic("编码方式标识重复注册: " + strconv.Itoa(int(codec.Encode())))
+	}
+	if _, ok := codecByName[codec.Name()]; ok {
+		panic("编码方式名称重复注册: " + codec.Name())
+	}
+	codecs[codec.Encode()] = codec
+	codecByName[codec.Name()] = codec
+}
+
+// Get 根据消息头中的标识获取编码方式
+func Get(encode qc.Encode) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecs[encode]
+	return codec, ok
+}
+
+// GetByName 根据名称获取编码方式
+func GetByName(name string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecByName[name]
+	return codec, ok
+}
+
+// Marshal 使用标识为encode的编码方式编码v
+func Marshal(encode qc.Encode, v any) ([]byte, *errors.QError) {
+	codec, ok := Get(encode)
+	if !ok {
+		return nil, errors.New("未注册的编码方式: " + strconv.Itoa(int(encode)))
+	}
+	return codec.Marshal(v)
+}
+
+// Unmarshal 使用标识为encode的编码方式将data解码至v
+func Unmarshal(encode qc.Encode, data []byte, v any) *errors.QError {
+	codec, ok := Get(encode)
+	if !ok {
+		return errors.New("未注册的编码方式: " + strconv.Itoa(int(encode)))
+	}
+	return codec.Unmarshal(data, v)
+}
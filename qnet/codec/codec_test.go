@@ -0,0 +1,93 @@
+package codec
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+}
+
+// 以"x,y"格式编码point的自定义编码方式
+type pointCodec struct{}
+
+const pointEncode qc.Encode = 64
+
+func (pointCodec) Encode() qc.Encode { return pointEncode }
+func (pointCodec) Name() string      { return "point" }
+func (pointCodec) Marshal(v any) ([]byte, *errors.QError) {
+	p, ok := v.(point)
+	if !ok {
+		return nil, errors.New("unsupported type")
+	}
+	return []byte(strings.Repeat("x", p.X) + "," + strings.Repeat("y", p.Y)), nil
+}
+func (pointCodec) Unmarshal(data []byte, v any) *errors.QError {
+	p, ok := v.(*point)
+	if !ok {
+		return errors.New("unsupported type")
+	}
+	parts := strings.Split(string(data), ",")
+	if len(parts) != 2 {
+		return errors.New("malformed point")
+	}
+	p.X, p.Y = len(parts[0]), len(parts[1])
+	return nil
+}
+
+func init() {
+	Register(pointCodec{})
+}
+
+func TestBuiltinCodecs(t *testing.T) {
+	for _, encode := range []qc.Encode{qc.JSON, qc.GOB} {
+		data, err := Marshal(encode, point{X: 3, Y: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p point
+		if err := Unmarshal(encode, data, &p); err != nil || p != (point{X: 3, Y: 4}) {
+			t.Errorf("encode %d: got %+v, %v", encode, p, err)
+		}
+	}
+	data, err := Marshal(qc.BINARY, "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	if err := Unmarshal(qc.BINARY, data, &raw); err != nil || string(raw) != "raw" {
+		t.Errorf("binary: got %q, %v", raw, err)
+	}
+	if _, err := Marshal(qc.BINARY, point{}); err == nil {
+		t.Error("expected binary codec to reject a struct")
+	}
+	if err := Unmarshal(qc.JSON, []byte("{"), &point{}); err == nil {
+		t.Error("expected malformed json to fail")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if c, ok := GetByName("point"); !ok || c.Encode() != pointEncode {
+		t.Fatal("custom codec not registered")
+	}
+	data, err := Marshal(pointEncode, point{X: 1, Y: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := Unmarshal(pointEncode, data, &p); err != nil || p != (point{X: 1, Y: 2}) {
+		t.Errorf("got %+v, %v", p, err)
+	}
+	if _, err := Marshal(qc.Encode(65), p); err == nil {
+		t.Error("expected unknown encode to fail")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected duplicate registration to panic")
+		}
+	}()
+	Register(pointCodec{})
+}
@@ -0,0 +1,113 @@
+package codec
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+func init() {
+	Register(jsonCodec{})
+	Register(binaryCodec{})
+	Register(gobCodec{})
+}
+
+// JSON编码
+type jsonCodec struct{}
+
+func (jsonCodec) Encode() qc.Encode {
+	return qc.JSON
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, *errors.QError) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return data, nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) *errors.QError {
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.New(err.Error())
+	}
+	return nil
+}
+
+// 二进制byte流，支持[]byte、string以及实现了encoding.BinaryMarshaler/BinaryUnmarshaler的类型
+type binaryCodec struct{}
+
+func (binaryCodec) Encode() qc.Encode {
+	return qc.BINARY
+}
+
+func (binaryCodec) Name() string {
+	return "binary"
+}
+
+func (binaryCodec) Marshal(v any) ([]byte, *errors.QError) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	case encoding.BinaryMarshaler:
+		data, err := value.MarshalBinary()
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		return data, nil
+	}
+	return nil, errors.New("BINARY编码不支持类型" + fmt.Sprintf("%T", v))
+}
+
+func (binaryCodec) Unmarshal(data []byte, v any) *errors.QError {
+	switch value := v.(type) {
+	case *[]byte:
+		*value = append((*value)[:0], data...)
+		return nil
+	case *string:
+		*value = string(data)
+		return nil
+	case encoding.BinaryUnmarshaler:
+		if err := value.UnmarshalBinary(data); err != nil {
+			return errors.New(err.Error())
+		}
+		return nil
+	}
+	return errors.New("BINARY编码不支持类型" + fmt.Sprintf("%T", v))
+}
+
+// encoding/gob编码，每个消息独立编码，包含完整的类型信息
+type gobCodec struct{}
+
+func (gobCodec) Encode() qc.Encode {
+	return qc.GOB
+}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, *errors.QError) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) *errors.QError {
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return errors.New(err.Error())
+	}
+	return nil
+}
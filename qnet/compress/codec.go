@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"strconv"
+	"sync"
+)
+
+// Codec 压缩算法，ID会被写入消息头的预留字节中，取值范围为1~15
+type Codec interface {
+	// ID 压缩算法在消息头中的标识
+	ID() uint8
+	// Name 压缩算法名称，用于连接建立时的协商
+	Name() string
+	// Compress 压缩数据
+	Compress(src []byte) ([]byte, *errors.QError)
+	// Decompress 解压数据
+	Decompress(src []byte) ([]byte, *errors.QError)
+}
+
+const (
+	// NONE 未压缩
+	NONE uint8 = iota
+	// GZIP gzip压缩
+	GZIP
+	// FLATE deflate压缩
+	FLATE
+	// ZLIB zlib压缩
+	ZLIB
+)
+
+var (
+	codecMu     sync.RWMutex
+	codecs      = make(map[uint8]Codec)
+	codecByName = make(map[string]Codec)
+	// 按注册顺序排列的压缩算法名称
+	codecNames []string
+)
+
+// Register 注册一个压缩算法，ID或名称重复时会panic
+func Register(codec Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	id := codec.ID()
+	if id == NONE || uint16(id) > qc.ReservedCompressMask {
+		panic("压缩算法ID超出范围: " + strconv.Itoa(int(id)))
+	}
+	if _, ok := codecs[id]; ok {
+		panic("压缩算法ID重复注册: " + strconv.Itoa(int(id)))
+	}
+	if _, ok := codecByName[codec.Name()]; ok {
+		panic("压缩算法名称重复注册: " + codec.Name())
+	}
+	codecs[id] = codec
+	codecByName[codec.Name()] = codec
+	codecNames = append(codecNames, codec.Name())
+}
+
+// Get 根据ID获取压缩算法
+func Get(id uint8) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecs[id]
+	return codec, ok
+}
+
+// GetByName 根据名称获取压缩算法
+func GetByName(name string) (Codec, bool) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	codec, ok := codecByName[name]
+	return codec, ok
+}
+
+// Names 获取所有已注册的压缩算法名称
+func Names() []string {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	names := make([]string, len(codecNames))
+	copy(names, codecNames)
+	return names
+}
+
+// Negotiate 按local的优先顺序选出第一个双方都支持的压缩算法，没有时返回nil
+func Negotiate(local []string, remote []string) Codec {
+	for _, name := range local {
+		for _, r := range remote {
+			if name != r {
+				continue
+			}
+			if codec, ok := GetByName(name); ok {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// LimitedCodec 可限制解压后长度的压缩算法，超过限制时提前终止解压，防止解压炸弹
+type LimitedCodec interface {
+	Codec
+	// DecompressLimit 解压数据，解压后长度超过maxSize时返回qc.FrameSizeError，maxSize不大于0时不限制
+	DecompressLimit(src []byte, maxSize int) ([]byte, *errors.QError)
+}
+
+// Decompress 根据消息头中的压缩标识解压数据，未压缩时原样返回
+func Decompress(header qc.QTPHeader, data []byte) ([]byte, *errors.QError) {
+	return DecompressLimit(header, data, 0)
+}
+
+// DecompressLimit 根据消息头中的压缩标识解压数据，解压后长度超过maxSize时返回qc.FrameSizeError，maxSize不大于0时不限制
+func DecompressLimit(header qc.QTPHeader, data []byte, maxSize int) ([]byte, *errors.QError) {
+	id := header.CompressID()
+	if id == NONE {
+		return data, nil
+	}
+	codec, ok := Get(id)
+	if !ok {
+		return nil, errors.New("解压失败，未知的压缩算法: " + strconv.Itoa(int(id)))
+	}
+	if limited, ok := codec.(LimitedCodec); ok {
+		return limited.DecompressLimit(data, maxSize)
+	}
+	result, err := codec.Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = qc.CheckFrameSize(len(result), maxSize); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
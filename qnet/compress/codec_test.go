@@ -0,0 +1,68 @@
+package compress
+
+import (
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"name":"quantum","value":42}`), 100)
+	for _, name := range Names() {
+		codec, ok := GetByName(name)
+		if !ok {
+			t.Fatalf("codec %s not found", name)
+		}
+		compressed, err := codec.Compress(payload)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(compressed) >= len(payload) {
+			t.Errorf("%s: compressed %d bytes to %d", name, len(payload), len(compressed))
+		}
+		header := qc.QTPHeader{Reserved: uint16(codec.ID())}
+		data, err := Decompress(header, compressed)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+}
+
+func TestDecompressUnknown(t *testing.T) {
+	header := qc.QTPHeader{Reserved: qc.ReservedCompressMask}
+	if _, err := Decompress(header, []byte("data")); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if codec := Negotiate([]string{"zlib", "gzip"}, []string{"gzip", "zlib"}); codec == nil || codec.Name() != "zlib" {
+		t.Errorf("expected local preference zlib, got %v", codec)
+	}
+	if codec := Negotiate([]string{"gzip"}, nil); codec != nil {
+		t.Errorf("expected no codec for legacy peer, got %s", codec.Name())
+	}
+	if codec := Negotiate([]string{"snappy"}, []string{"snappy"}); codec != nil {
+		t.Errorf("expected unregistered codec to be skipped, got %s", codec.Name())
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	codec, _ := Get(GZIP)
+	// 高压缩比的数据，解压后远大于压缩后的长度
+	compressed, err := codec.Compress(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := qc.QTPHeader{Reserved: uint16(GZIP)}
+	if _, err := DecompressLimit(header, compressed, 1024); !err.IsKind(qc.FrameSizeError) {
+		t.Errorf("expected FrameSizeError, got %v", err)
+	}
+	data, err := DecompressLimit(header, compressed, 1<<20)
+	if err != nil || len(data) != 1<<20 {
+		t.Errorf("decompress at limit failed: %d bytes, %v", len(data), err)
+	}
+}
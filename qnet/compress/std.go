@@ -0,0 +1,117 @@
+package compress
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	Register(&stdCodec{
+		id:   GZIP,
+		name: "gzip",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+	Register(&stdCodec{
+		id:   FLATE,
+		name: "flate",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	})
+	Register(&stdCodec{
+		id:   ZLIB,
+		name: "zlib",
+		newWriter: func(w io.Writer) (resetWriter, error) {
+			return zlib.NewWriterLevel(w, zlib.DefaultCompression)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	})
+}
+
+// 可复用的压缩Writer
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// 基于标准库实现的压缩算法，压缩Writer会被复用
+type stdCodec struct {
+	id        uint8
+	name      string
+	newWriter func(w io.Writer) (resetWriter, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func (c *stdCodec) ID() uint8 {
+	return c.id
+}
+
+func (c *stdCodec) Name() string {
+	return c.name
+}
+
+func (c *stdCodec) Compress(src []byte) ([]byte, *errors.QError) {
+	var buf bytes.Buffer
+	var writer resetWriter
+	if w := c.writers.Get(); w != nil {
+		writer = w.(resetWriter)
+		writer.Reset(&buf)
+	} else {
+		w, err := c.newWriter(&buf)
+		if err != nil {
+			return nil, errors.New(err.Error())
+		}
+		writer = w
+	}
+	defer c.writers.Put(writer)
+	if _, err := writer.Write(src); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *stdCodec) Decompress(src []byte) ([]byte, *errors.QError) {
+	return c.DecompressLimit(src, 0)
+}
+
+func (c *stdCodec) DecompressLimit(src []byte, maxSize int) ([]byte, *errors.QError) {
+	reader, err := c.newReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, errors.New("解压失败，" + err.Error())
+	}
+	defer reader.Close()
+	var limited io.Reader = reader
+	if maxSize > 0 {
+		// 多读取1byte用于判断是否超过限制
+		limited = io.LimitReader(reader, int64(maxSize)+1)
+	}
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, errors.New("解压失败，" + err.Error())
+	}
+	if maxSize > 0 && len(data) > maxSize {
+		return nil, errors.NewKind(qc.FrameSizeError, "解压失败，解压后的数据长度超过了限制的"+strconv.Itoa(maxSize)+"byte")
+	}
+	return data, nil
+}
@@ -0,0 +1,57 @@
+package qnet
+
+import (
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/guard"
+	"QuantumUtils/qnet/qio"
+	"QuantumUtils/qnet/ratelimit"
+	"QuantumUtils/qnet/send"
+)
+
+// QTPServerConfig QTPServer配置，对每一个连接生效
+type QTPServerConfig struct {
+	// Sender配置
+	SConf send.QTPSenderConfig
+	// Writer配置
+	WConf qio.QTPWriterConfig
+	// Reader配置
+	RConf qio.QTPReaderConfig
+	// 连接认证器，为nil时不认证，连接建立后直接调用ConnInit回调
+	// 配置后连接需在SConf.AuthTimeout内通过认证，认证通过后才会调用ConnInit回调并处理业务消息
+	// 认证通过前只接受控制消息，其他消息被直接丢弃，客户端需在收到认证结果后再发送业务消息
+	Authenticator auth.Authenticator
+	// 速率限制配置
+	RLConfig ratelimit.Config
+	// 连接准入配置，限制连接数、远端地址，并临时封禁多次违规的远端IP
+	GConfig guard.Config
+}
+
+// QTPServerConfigDefault Get默认QTPServer配置
+func QTPServerConfigDefault() QTPServerConfig {
+	return QTPServerConfig{
+		SConf:    send.QTPSenderConfigDefault(),
+		WConf:    qio.QTPWriterConfigDefault(),
+		RConf:    qio.QTPReaderConfigDefault(),
+		RLConfig: ratelimit.ConfigDefault(),
+		GConfig:  guard.ConfigDefault(),
+	}
+}
+
+// QTPClientConfig QTPClient配置
+type QTPClientConfig struct {
+	// Sender配置
+	SConf send.QTPSenderConfig
+	// Writer配置
+	WConf qio.QTPWriterConfig
+	// Reader配置
+	RConf qio.QTPReaderConfig
+}
+
+// QTPClientConfigDefault Get默认QTPClient配置
+func QTPClientConfigDefault() QTPClientConfig {
+	return QTPClientConfig{
+		SConf: send.QTPSenderConfigDefault(),
+		WConf: qio.QTPWriterConfigDefault(),
+		RConf: qio.QTPReaderConfigDefault(),
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"QuantumUtils/errors"
 	"io"
 	"net"
+	"sync/atomic"
 )
 
 type Closeable interface {
@@ -35,12 +36,26 @@ func RegisterClose(closeable Closeable, errorCatch func(err *errors.QError)) {
 }
 
 type QTPReConn struct {
-	conn net.Conn
+	// 当前使用的连接，重连后被替换，读写与关闭可能在不同协程中同时进行
+	conn atomic.Pointer[net.Conn]
 	rc   *Reconnecter
 }
 
+// 获取当前使用的连接
+func (c *QTPReConn) current() net.Conn {
+	return *c.conn.Load()
+}
+
+// 替换为重连得到的连接，替换时已主动关闭则同时关闭新连接
+func (c *QTPReConn) replace(conn net.Conn) {
+	c.conn.Store(&conn)
+	if c.rc.closed.Load() {
+		_ = conn.Close()
+	}
+}
+
 func (c *QTPReConn) Read(b []byte) (n int, err error) {
-	read, err := c.conn.Read(b)
+	read, err := c.current().Read(b)
 	if err != nil && c.rc != nil {
 	rer:
 		conn, qErr := c.rc.TryReconnect()
@@ -50,8 +65,8 @@ func (c *QTPReConn) Read(b []byte) (n int, err error) {
 		if qErr != nil {
 			return read, qErr
 		}
-		c.conn = conn
-		rRead, rErr := c.conn.Read(b)
+		c.replace(conn)
+		rRead, rErr := conn.Read(b)
 		if rErr != nil {
 			goto rer
 		}
@@ -60,7 +75,7 @@ func (c *QTPReConn) Read(b []byte) (n int, err error) {
 	return read, nil
 }
 func (c *QTPReConn) Write(b []byte) (n int, err error) {
-	write, err := c.conn.Write(b)
+	write, err := c.current().Write(b)
 	if err != nil && c.rc != nil {
 	rew:
 		conn, qErr := c.rc.TryReconnect()
@@ -70,26 +85,28 @@ func (c *QTPReConn) Write(b []byte) (n int, err error) {
 		if qErr != nil {
 			return write, qErr
 		}
-		c.conn = conn
-		rWrite, rErr := c.conn.Write(b)
-		if rErr != nil {
+		c.replace(conn)
+		data := b
+		if c.rc.rewrite != nil {
+			data = c.rc.rewrite(b)
+		}
+		if _, rErr := conn.Write(data); rErr != nil {
 			goto rew
 		}
-		return rWrite, rErr
+		return len(b), nil
 	}
 	return write, nil
 }
 func (c *QTPReConn) Close() error {
 	c.rc.Close()
-	return c.conn.Close()
+	return c.current().Close()
 }
 
 func NewQTPConn(conn net.Conn, rc *Reconnecter) QTPConn {
 	if rc == nil {
 		return conn
 	}
-	return &QTPReConn{
-		conn: conn,
-		rc:   rc,
-	}
+	reConn := &QTPReConn{rc: rc}
+	reConn.conn.Store(&conn)
+	return reConn
 }
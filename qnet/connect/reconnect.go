@@ -5,6 +5,7 @@ import (
 	"QuantumUtils/logger"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,9 +14,11 @@ type Reconnecter struct {
 	reconnectAttempts int                               // 掉线重连次数
 	reconnectHandle   func() (net.Conn, *errors.QError) // 重连方法
 	lastResult        ReconnecterResult                 // 上一次重连结果
-	inProgress        bool                              // 是否正在进行重连
-	mu                sync.RWMutex                      // 同步锁
-	closed            bool
+	inProgress        chan struct{}                     // 正在进行的重连，重连结束后关闭，为nil时表明没有进行中的重连
+	mu                sync.Mutex                        // 同步锁
+	closed            atomic.Bool
+	onReconnected     []func(conn net.Conn) // 重连成功后的回调
+	rewrite           func(b []byte) []byte // 在新连接上重写写入失败的数据前的处理
 }
 
 func NewReconnecter(attempts int, rcHandle func() (net.Conn, *errors.QError)) *Reconnecter {
@@ -23,19 +26,27 @@ func NewReconnecter(attempts int, rcHandle func() (net.Conn, *errors.QError)) *R
 		reconnectAttempts: attempts,
 		reconnectHandle:   rcHandle,
 		lastResult:        ReconnecterResult{},
-		inProgress:        false,
-		mu:                sync.RWMutex{},
 	}
 	return &rc
 }
 
+// OnReconnected 添加重连成功后的回调，回调会在新连接投入使用前被调用，需在连接使用前添加
+func (r *Reconnecter) OnReconnected(f func(conn net.Conn)) {
+	r.onReconnected = append(r.onReconnected, f)
+}
+
+// OnRewrite 设置在新连接上重写旧连接写入失败的数据前的处理，返回需要写出的数据，可去除在新连接上已失效的部分，需在连接使用前设置
+func (r *Reconnecter) OnRewrite(f func(b []byte) []byte) {
+	r.rewrite = f
+}
+
 type ReconnecterResult struct {
 	conn net.Conn
 	err  *errors.QError
 }
 
 func (r *Reconnecter) Close() {
-	r.closed = true
+	r.closed.Store(true)
 }
 
 // TryReconnect 尝试重连
@@ -44,40 +55,39 @@ func (r *Reconnecter) Close() {
 // 重连成功时能获得统一的conn对象
 // 当重连对象已主动关闭时，返回两个nil值
 func (r *Reconnecter) TryReconnect() (net.Conn, *errors.QError) {
-	defer func() {
-		if r.lastResult.err != nil {
-			r.Close()
-		}
-	}()
-	if r.closed {
+	if r.closed.Load() {
 		return nil, nil
 	}
-	r.mu.RLock()
-	if r.inProgress {
-		r.mu.RUnlock()
+	r.mu.Lock()
+	if r.inProgress != nil {
+		wait := r.inProgress
+		r.mu.Unlock()
 		// 等待reconnect完成
-		for r.inProgress {
-		}
+		<-wait
+		r.mu.Lock()
+		defer r.mu.Unlock()
 		return r.lastResult.conn, r.lastResult.err
-
 	}
-	r.mu.RUnlock()
-
-	// 设置inProgress为true，防止其他goroutine进入reconnect
-	r.mu.Lock()
-	r.inProgress = true
+	// 设置inProgress，防止其他goroutine进入reconnect
+	wait := make(chan struct{})
+	r.inProgress = wait
 	r.mu.Unlock()
 
 	c := make(chan ReconnecterResult, 1)
 	// 执行重连
 	go r.reconnect(c)
+	res := <-c
 
-	r.lastResult = <-c
 	// 重置inProgress，允许其他单个goroutine进入reconnect
 	r.mu.Lock()
-	r.inProgress = false
+	r.lastResult = res
+	r.inProgress = nil
 	r.mu.Unlock()
-	return r.lastResult.conn, r.lastResult.err
+	close(wait)
+	if res.err != nil {
+		r.Close()
+	}
+	return res.conn, res.err
 
 }
 
@@ -95,6 +105,9 @@ func (r *Reconnecter) reconnect(c chan<- ReconnecterResult) {
 			continue
 		}
 		r.GetLogger().Info("重连成功")
+		for _, f := range r.onReconnected {
+			f(res.conn)
+		}
 		return
 	}
 	r.GetLogger().Error("重新连接失败，超过重连次数")
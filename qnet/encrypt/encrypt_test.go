@@ -0,0 +1,220 @@
+package encrypt
+
+import (
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// RFC 5869 A.1
+func TestHKDF(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865"
+	if got := hex.EncodeToString(hkdf(ikm, salt, info, 42)); got != want {
+		t.Errorf("hkdf = %s, want %s", got, want)
+	}
+}
+
+// 创建两个完成握手的会话
+func pair(t *testing.T, a, b *Keyring) (*Session, *Session) {
+	conf := ConfigDefault()
+	conf.Keyring = a
+	sa, err := NewSession(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Keyring = b
+	sb, err := NewSession(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.SetPeerSalt(sb.Salt()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.SetPeerSalt(sa.Salt()); err != nil {
+		t.Fatal(err)
+	}
+	return sa, sb
+}
+
+// 以s加密配置为conf的消息，返回接收方看到的消息头与加密后的数据
+func seal(t *testing.T, s *Session, conf qc.QTPConfig, data string) (qc.QTPHeader, []byte) {
+	id, sealed, err := s.Seal(conf, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := qc.QTPHeader{Encode: conf.Encode, MsgType: conf.MsgType, ACKType: conf.ACKType, Reserved: conf.Reserved(), Extensions: conf.Extensions.Clone()}
+	header.Extensions.SetEncryption(id)
+	return header, sealed
+}
+
+var dataConf = qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.SyncACK}
+
+func TestSession(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	ka, _ := NewKeyring(1, psk)
+	kb, _ := NewKeyring(1, psk)
+	sa, sb := pair(t, ka, kb)
+
+	header, sealed := seal(t, sa, dataConf, "hello")
+	if bytes.Contains(sealed, []byte("hello")) {
+		t.Error("sealed data contains plaintext")
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := sb.Open(header, tampered); !err.IsKind(qc.DecryptError) {
+		t.Errorf("expected tampered message to fail, got %v", err)
+	}
+	plain, err := sb.Open(header, sealed)
+	if err != nil || string(plain) != "hello" {
+		t.Fatalf("Open = %q, %v", plain, err)
+	}
+	// 两个方向使用不同的密钥
+	if _, err := sa.Open(header, sealed); !err.IsKind(qc.DecryptError) {
+		t.Errorf("expected reflected message to fail, got %v", err)
+	}
+
+	// 密钥轮换
+	next := []byte("fedcba9876543210fedcba9876543210")
+	_ = ka.Add(2, next)
+	_ = kb.Add(2, next)
+	oldHeader, old := seal(t, sa, dataConf, "old")
+	if err := ka.Use(2); err != nil {
+		t.Fatal(err)
+	}
+	header, sealed = seal(t, sa, dataConf, "new")
+	if id, _ := header.Extensions.Encryption(); id != 2 {
+		t.Errorf("expected key 2, got %d", id)
+	}
+	if plain, err := sb.Open(header, sealed); err != nil || string(plain) != "new" {
+		t.Fatalf("Open after rotation = %q, %v", plain, err)
+	}
+	_ = kb.Use(2)
+	if err := kb.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sb.Open(oldHeader, old); !err.IsKind(qc.DecryptError) {
+		t.Errorf("expected removed key to fail, got %v", err)
+	}
+	if err := ka.Remove(2); err == nil {
+		t.Error("expected removing the current key to fail")
+	}
+}
+
+func TestSessionKeyMismatch(t *testing.T) {
+	ka, _ := NewKeyring(1, []byte("0123456789abcdef0123456789abcdef"))
+	kb, _ := NewKeyring(1, []byte("0123456789abcdef0123456789abcdeX"))
+	sa, sb := pair(t, ka, kb)
+	header, sealed := seal(t, sa, dataConf, "hello")
+	if _, err := sb.Open(header, sealed); !err.IsKind(qc.DecryptError) {
+		t.Errorf("expected mismatched key to fail, got %v", err)
+	}
+	if _, err := NewKeyring(1, []byte("short")); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+}
+
+func TestSessionHeaderAuthentication(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	ka, _ := NewKeyring(1, psk)
+	kb, _ := NewKeyring(1, psk)
+	sa, sb := pair(t, ka, kb)
+
+	conf := dataConf
+	conf.Compress = 1
+	conf.Extensions.SetRoute("order.create")
+	tampers := map[string]func(h *qc.QTPHeader){
+		"ack type": func(h *qc.QTPHeader) { h.ACKType = qc.NoACK },
+		"encode":   func(h *qc.QTPHeader) { h.Encode = qc.JSON },
+		"compress": func(h *qc.QTPHeader) { h.Reserved &^= qc.ReservedCompressMask },
+		"route":    func(h *qc.QTPHeader) { h.Extensions.SetRoute("admin.reload") },
+		"added":    func(h *qc.QTPHeader) { h.Extensions.SetTraceID("t") },
+		"removed":  func(h *qc.QTPHeader) { h.Extensions.Del(qc.ExtRoute) },
+	}
+	for name, tamper := range tampers {
+		header, sealed := seal(t, sa, conf, "payload")
+		tamper(&header)
+		if _, err := sb.Open(header, sealed); !err.IsKind(qc.DecryptError) {
+			t.Errorf("%s: expected tampered header to fail, got %v", name, err)
+		}
+	}
+	// 校验和与重发不影响认证
+	header, sealed := seal(t, sa, conf, "payload")
+	header.Reserved |= qc.ReservedChecksum
+	header.MsgType = qc.RETRY
+	if plain, err := sb.Open(header, sealed); err != nil || string(plain) != "payload" {
+		t.Fatalf("Open = %q, %v", plain, err)
+	}
+}
+
+func TestSessionReplay(t *testing.T) {
+	psk := []byte("0123456789abcdef0123456789abcdef")
+	ka, _ := NewKeyring(1, psk)
+	kb, _ := NewKeyring(1, psk)
+	sa, sb := pair(t, ka, kb)
+
+	lostHeader, lost := seal(t, sa, dataConf, "lost")
+	header, sealed := seal(t, sa, dataConf, "hello")
+	if _, err := sb.Open(header, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sb.Open(header, sealed); !err.IsKind(qc.ReplayError) {
+		t.Errorf("expected replayed message to fail, got %v", err)
+	}
+	// 重发的消息以原消息的nonce到达，原消息未送达时接受一次，之后视为重复
+	header.MsgType = qc.RETRY
+	if _, err := sb.Open(header, sealed); !err.IsKind(qc.ReplayError) {
+		t.Errorf("expected duplicate retry to fail, got %v", err)
+	}
+	if _, err := sb.Open(lostHeader, lost); !err.IsKind(qc.ReplayError) {
+		t.Errorf("expected out of order message to fail, got %v", err)
+	}
+	lostHeader.MsgType = qc.RETRY
+	if plain, err := sb.Open(lostHeader, lost); err != nil || string(plain) != "lost" {
+		t.Fatalf("Open retry = %q, %v", plain, err)
+	}
+	if _, err := sb.Open(lostHeader, lost); !err.IsKind(qc.ReplayError) {
+		t.Errorf("expected duplicate retry to fail, got %v", err)
+	}
+
+	// 重连后密钥更换，重放检测随之重置
+	_ = sa.Reset()
+	_ = sb.Reset()
+	_ = sa.SetPeerSalt(sb.Salt())
+	_ = sb.SetPeerSalt(sa.Salt())
+	header, sealed = seal(t, sa, dataConf, "again")
+	if _, err := sb.Open(header, sealed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	r := &replay{}
+	for _, n := range []uint64{1, 2, 5} {
+		if !r.accept(n, false) {
+			t.Fatalf("nonce %d rejected", n)
+		}
+	}
+	if r.accept(4, false) || r.accept(5, true) {
+		t.Fatal("stale nonce accepted")
+	}
+	if !r.accept(3, true) || r.accept(3, true) {
+		t.Fatal("unexpected retry result for nonce 3")
+	}
+	// 窗口滑动后复用的位置被清除
+	if !r.accept(5+replayWindow, false) {
+		t.Fatal("nonce rejected")
+	}
+	if r.accept(5, true) {
+		t.Fatal("nonce outside the window accepted")
+	}
+	if !r.accept(6, true) || !r.accept(4+replayWindow, true) {
+		t.Fatal("unseen nonce inside the window rejected")
+	}
+	if !r.accept(10*replayWindow, false) || r.accept(9*replayWindow, true) || !r.accept(9*replayWindow+1, true) {
+		t.Fatal("unexpected result after a large jump")
+	}
+}
@@ -0,0 +1,25 @@
+package encrypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// hkdf 按RFC 5869以HMAC-SHA256从secret派生length byte的密钥
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	out := make([]byte, 0, length+sha256.Size)
+	var block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(block)
+		expander.Write(info)
+		expander.Write([]byte{counter})
+		block = expander.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
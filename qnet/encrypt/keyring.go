@@ -0,0 +1,81 @@
+package encrypt
+
+import (
+	"QuantumUtils/errors"
+	"strconv"
+	"sync"
+)
+
+// MinKeySize 预共享密钥的最小byte长度
+const MinKeySize = 16
+
+// Keyring 预共享密钥集合，以ID区分密钥
+// 发送时使用当前密钥，接收时可使用集合中的任意密钥解密，轮换密钥时先在双方添加新密钥，再切换当前密钥，最后移除旧密钥
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint8][]byte
+	current uint8
+}
+
+// NewKeyring 以ID为id的密钥psk创建密钥集合，并将其设为当前密钥
+func NewKeyring(id uint8, psk []byte) (*Keyring, *errors.QError) {
+	k := &Keyring{keys: make(map[uint8][]byte)}
+	if err := k.Add(id, psk); err != nil {
+		return nil, err
+	}
+	k.current = id
+	return k, nil
+}
+
+// Add 添加或替换ID为id的密钥
+func (k *Keyring) Add(id uint8, psk []byte) *errors.QError {
+	if len(psk) < MinKeySize {
+		return errors.New("预共享密钥长度不能小于" + strconv.Itoa(MinKeySize) + "byte")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = append([]byte(nil), psk...)
+	return nil
+}
+
+// Use 将ID为id的密钥设为发送时使用的当前密钥
+func (k *Keyring) Use(id uint8) *errors.QError {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return errors.New("密钥" + strconv.Itoa(int(id)) + "不存在")
+	}
+	k.current = id
+	return nil
+}
+
+// Remove 移除ID为id的密钥，不能移除当前密钥
+func (k *Keyring) Remove(id uint8) *errors.QError {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.current == id {
+		return errors.New("不能移除当前密钥" + strconv.Itoa(int(id)))
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+// Current 获取当前密钥的ID
+func (k *Keyring) Current() uint8 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) currentKey() (uint8, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current]
+}
+
+func (k *Keyring) get(id uint8) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	psk, ok := k.keys[id]
+	return psk, ok
+}
@@ -0,0 +1,34 @@
+package encrypt
+
+// 重放窗口的大小，以nonce计
+const replayWindow = 1024
+
+// 一个接收密钥的重放检测，记录已接受的最大nonce，以及它之前replayWindow个nonce中哪些已被接受
+// 发送方的nonce随消息依次递增，DATA消息的nonce必须大于已接受的最大值
+// RETRY消息与原消息的密文相同，nonce在窗口内且未被接受时视为原消息丢失后的重发，否则为重复或重放
+type replay struct {
+	highest uint64
+	seen    [replayWindow / 64]uint64
+}
+
+// 检查nonce n是否可以接受，可以接受时记录n，retry为消息是否为RETRY消息
+func (r *replay) accept(n uint64, retry bool) bool {
+	if n > r.highest {
+		// 滑动窗口，清除移出窗口后被复用的位置
+		end := n
+		if n-r.highest > replayWindow {
+			end = r.highest + replayWindow
+		}
+		for m := r.highest + 1; m <= end; m++ {
+			r.seen[m%replayWindow/64] &^= 1 << (m % 64)
+		}
+		r.highest = n
+		r.seen[n%replayWindow/64] |= 1 << (n % 64)
+		return true
+	}
+	if !retry || r.highest-n >= replayWindow || r.seen[n%replayWindow/64]&(1<<(n%64)) != 0 {
+		return false
+	}
+	r.seen[n%replayWindow/64] |= 1 << (n % 64)
+	return true
+}
@@ -0,0 +1,264 @@
+package encrypt
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个连接的双方各自生成随机数并在能力声明中交换，以预共享密钥为secret、双方随机数为salt派生AES-256-GCM密钥
+// 两个方向的salt顺序相反，因此双方发送时使用不同的密钥；重连后随机数重新生成，密钥随之更换
+// 加密后的数据为12byte的nonce加密文，nonce由连接内递增的计数器生成
+// 密钥ID、消息类型、ACK类型、编码、压缩算法与除加密外的消息头扩展作为附加认证数据，篡改后无法解密
+// 接收方按密钥记录已接受的nonce，拒绝重放的消息，见replay
+
+const (
+	// SaltSize 握手随机数的byte长度
+	SaltSize = 32
+	// 派生密钥的byte长度
+	keySize = 32
+	// nonce的byte长度
+	nonceSize = 12
+)
+
+// 派生密钥时的info
+var keyInfo = []byte("QTP AES-256-GCM")
+
+// Config 加密配置
+type Config struct {
+	// 预共享密钥集合，为nil时不加密
+	Keyring *Keyring
+	// 是否要求加密，开启后对端不支持加密时无法发送消息，收到未加密的DATA消息时视为解密失败
+	Required bool
+	// 收到加密消息时等待对端握手随机数的最长时间
+	HandshakeTimeout time.Duration
+}
+
+// ConfigDefault Get默认加密配置，默认不加密
+func ConfigDefault() Config {
+	return Config{
+		Keyring:          nil,
+		Required:         false,
+		HandshakeTimeout: 3 * time.Second,
+	}
+}
+
+// 由密钥派生的AEAD
+type derived struct {
+	psk  []byte
+	aead cipher.AEAD
+	// 接收时的重放检测，发送使用的AEAD为nil
+	replay *replay
+}
+
+// Session 一个连接的加密会话，由该连接的QTPSender与QTPReader共用
+type Session struct {
+	conf Config
+	mu   sync.Mutex
+	// 本端随机数
+	local []byte
+	// 对端随机数，未收到时为nil
+	remote []byte
+	// 收到对端随机数后关闭
+	ready chan struct{}
+	// nonce计数器
+	counter atomic.Uint64
+	// 密钥的代数，每次Reset后加1
+	epoch atomic.Uint64
+	// 发送与接收使用的AEAD，以密钥ID为key
+	sealers map[uint8]derived
+	openers map[uint8]derived
+}
+
+// NewSession 以conf创建一个连接的加密会话
+func NewSession(conf Config) (*Session, *errors.QError) {
+	if conf.Keyring == nil {
+		return nil, errors.New("未配置预共享密钥")
+	}
+	s := &Session{conf: conf}
+	if err := s.Reset(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Required 是否要求加密
+func (s *Session) Required() bool {
+	return s.conf.Required
+}
+
+// Salt 获取本端的握手随机数
+func (s *Session) Salt() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.local...)
+}
+
+// SetPeerSalt 设置对端的握手随机数，设置后才能加解密
+func (s *Session) SetPeerSalt(salt []byte) *errors.QError {
+	if len(salt) != SaltSize {
+		return errors.NewKind(qc.DecryptError, "对端的握手随机数长度非法")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remote = append([]byte(nil), salt...)
+	s.sealers = make(map[uint8]derived)
+	s.openers = make(map[uint8]derived)
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+	return nil
+}
+
+// Ready 是否已收到对端的握手随机数
+func (s *Session) Ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remote != nil
+}
+
+// Reset 重新生成本端随机数并清除对端随机数，连接重连时调用
+func (s *Session) Reset() *errors.QError {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return errors.New(err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.local = salt
+	s.remote = nil
+	s.ready = make(chan struct{})
+	s.sealers = make(map[uint8]derived)
+	s.openers = make(map[uint8]derived)
+	s.epoch.Add(1)
+	return nil
+}
+
+// Epoch 当前密钥的代数，每次Reset后加1
+// 在Seal之前记录，代数改变后之前生成的密文在新连接上无法解密
+func (s *Session) Epoch() uint64 {
+	return s.epoch.Load()
+}
+
+// 获取密钥id对应的AEAD，seal为true时获取发送使用的AEAD
+func (s *Session) aead(id uint8, psk []byte, seal bool) (derived, *errors.QError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.remote == nil {
+		return derived{}, errors.NewKind(qc.DecryptError, "尚未收到对端的握手随机数")
+	}
+	cache, salt := s.openers, append(append([]byte(nil), s.remote...), s.local...)
+	if seal {
+		cache, salt = s.sealers, append(append([]byte(nil), s.local...), s.remote...)
+	}
+	if d, ok := cache[id]; ok && bytes.Equal(d.psk, psk) {
+		return d, nil
+	}
+	block, err := aes.NewCipher(hkdf(psk, salt, keyInfo, keySize))
+	if err != nil {
+		return derived{}, errors.New(err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return derived{}, errors.New(err.Error())
+	}
+	d := derived{psk: psk, aead: aead}
+	if !seal {
+		d.replay = &replay{}
+	}
+	cache[id] = d
+	return d, nil
+}
+
+// 附加认证数据，RETRY消息沿用原消息的密文，以DATA计算
+func additionalData(id uint8, msgType qc.MsgType, ackType qc.ACKType, encode qc.Encode, compress uint8, extensions qc.Extensions) []byte {
+	if msgType == qc.RETRY {
+		msgType = qc.DATA
+	}
+	buf := make([]byte, 0, 5+extensions.Size())
+	buf = append(buf, id, byte(msgType), byte(ackType), byte(encode), compress)
+	for _, ext := range extensions {
+		if ext.Type == qc.ExtEncryption {
+			continue
+		}
+		buf = append(buf, byte(ext.Type))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(ext.Value)))
+		buf = append(buf, ext.Value...)
+	}
+	return buf
+}
+
+// Seal 以当前密钥加密配置为conf的消息数据data，返回所用的密钥ID与nonce加密文
+// conf中除加密扩展外的消息头信息参与认证，加密后不能再修改
+func (s *Session) Seal(conf qc.QTPConfig, data []byte) (uint8, []byte, *errors.QError) {
+	id, psk := s.conf.Keyring.currentKey()
+	d, err := s.aead(id, psk, true)
+	if err != nil {
+		return 0, nil, err
+	}
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+d.aead.Overhead())
+	binary.BigEndian.PutUint64(nonce[nonceSize-8:], s.counter.Add(1))
+	aad := additionalData(id, conf.MsgType, conf.ACKType, conf.Encode, conf.Compress, conf.Extensions)
+	return id, d.aead.Seal(nonce, nonce, data, aad), nil
+}
+
+// Open 以消息头header中记录的密钥解密Seal生成的数据，尚未收到对端随机数时最多等待HandshakeTimeout
+// 密钥不存在、数据或消息头被篡改、密钥不匹配时返回qc.DecryptError类型的异常，重放的消息返回qc.ReplayError类型的异常
+func (s *Session) Open(header qc.QTPHeader, data []byte) ([]byte, *errors.QError) {
+	id, ok := header.Extensions.Encryption()
+	if !ok {
+		return nil, errors.NewKind(qc.DecryptError, "消息没有记录加密所用的密钥ID")
+	}
+	if err := s.waitReady(); err != nil {
+		return nil, err
+	}
+	psk, ok := s.conf.Keyring.get(id)
+	if !ok {
+		return nil, errors.NewKind(qc.DecryptError, "未知的密钥ID"+strconv.Itoa(int(id)))
+	}
+	d, err := s.aead(id, psk, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < nonceSize+d.aead.Overhead() {
+		return nil, errors.NewKind(qc.DecryptError, "加密数据长度不足")
+	}
+	aad := additionalData(id, header.MsgType, header.ACKType, header.Encode, header.CompressID(), header.Extensions)
+	plain, oErr := d.aead.Open(nil, data[:nonceSize], data[nonceSize:], aad)
+	if oErr != nil {
+		return nil, errors.NewKind(qc.DecryptError, "消息认证失败，数据被篡改或密钥不匹配")
+	}
+	// 认证通过后才记录nonce，伪造的消息不会影响重放检测
+	n := binary.BigEndian.Uint64(data[nonceSize-8 : nonceSize])
+	s.mu.Lock()
+	accepted := d.replay.accept(n, header.MsgType == qc.RETRY)
+	s.mu.Unlock()
+	if !accepted {
+		return nil, errors.NewKind(qc.ReplayError, "密钥ID"+strconv.Itoa(int(id))+"的nonce "+strconv.FormatUint(n, 10)+"已被使用，消息重放")
+	}
+	return plain, nil
+}
+
+func (s *Session) waitReady() *errors.QError {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+	timer := time.NewTimer(s.conf.HandshakeTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		return errors.NewKind(qc.DecryptError, "等待对端的握手随机数超时")
+	}
+}
@@ -0,0 +1,123 @@
+package guard
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// 一个远端IP的违规记录
+type offender struct {
+	// 当前时间窗口内的违规次数
+	count int
+	// 当前时间窗口的开始时间
+	since time.Time
+	// 封禁的截止时间，为零值时未被封禁
+	until time.Time
+}
+
+// Violate 记录远端IP的一次违规，BanWindow内违规达到BanThreshold次时临时封禁该IP，返回是否因此被封禁
+// 封禁只拒绝之后的新连接，不影响已建立的连接
+func (g *Guard) Violate(ip string, reason string) bool {
+	ip = normalize(ip)
+	g.violations.Add(1)
+	if g.conf.BanThreshold <= 0 {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	g.sweep(now)
+	o, ok := g.offenders[ip]
+	if !ok {
+		o = &offender{since: now}
+		g.offenders[ip] = o
+	} else if now.Sub(o.since) > g.conf.BanWindow {
+		o.count, o.since = 0, now
+	}
+	o.count++
+	if o.count < g.conf.BanThreshold {
+		return false
+	}
+	o.count, o.since, o.until = 0, now, now.Add(g.conf.BanDuration)
+	g.bans.Add(1)
+	if l := g.GetLogger(); l != nil {
+		l.Warn(ip + "在" + g.conf.BanWindow.String() + "内违规" + strconv.Itoa(g.conf.BanThreshold) + "次，封禁" + g.conf.BanDuration.String() + "，最后一次违规: " + reason)
+	}
+	return true
+}
+
+// Ban 手动封禁远端IP，持续d
+func (g *Guard) Ban(ip string, d time.Duration) {
+	ip = normalize(ip)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	o, ok := g.offenders[ip]
+	if !ok {
+		o = &offender{since: now}
+		g.offenders[ip] = o
+	}
+	o.until = now.Add(d)
+}
+
+// Unban 解除远端IP的封禁并清空违规记录，返回该IP是否处于封禁中
+func (g *Guard) Unban(ip string) bool {
+	ip = normalize(ip)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, banned := g.banned(ip, g.now())
+	delete(g.offenders, ip)
+	return banned
+}
+
+// Banned 远端IP是否处于封禁中，以及封禁的截止时间
+func (g *Guard) Banned(ip string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.banned(normalize(ip), g.now())
+}
+
+// Bans 当前处于封禁中的远端IP及其封禁的截止时间
+func (g *Guard) Bans() map[string]time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	result := make(map[string]time.Time)
+	for ip, o := range g.offenders {
+		if o.until.After(now) {
+			result[ip] = o.until
+		}
+	}
+	return result
+}
+
+// 需持有锁
+func (g *Guard) banned(ip string, now time.Time) (time.Time, bool) {
+	o, ok := g.offenders[ip]
+	if !ok || !o.until.After(now) {
+		return time.Time{}, false
+	}
+	return o.until, true
+}
+
+// 清理封禁已过期且时间窗口已结束的违规记录，每个时间窗口最多清理一次，需持有锁
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.swept) < g.conf.BanWindow {
+		return
+	}
+	g.swept = now
+	for ip, o := range g.offenders {
+		if !o.until.After(now) && now.Sub(o.since) > g.conf.BanWindow {
+			delete(g.offenders, ip)
+		}
+	}
+}
+
+// 统一IP的文本形式，与Admit使用的形式一致
+func normalize(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
@@ -0,0 +1,257 @@
+package guard
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/logger"
+	"QuantumUtils/qnet/qc"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config 连接准入配置
+type Config struct {
+	// 最大连接数，不大于0时不限制
+	MaxConns int
+	// 同一远端IP的最大连接数，不大于0时不限制
+	MaxConnsPerIP int
+	// 允许连接的CIDR列表，也可以是单个IP，为空时允许所有不在Deny中的地址
+	Allow []string
+	// 拒绝连接的CIDR列表，也可以是单个IP，优先于Allow
+	Deny []string
+	// 同一远端IP在BanWindow内违规达到该次数后被临时封禁，不大于0时不封禁
+	BanThreshold int
+	// 统计违规次数的时间窗口
+	BanWindow time.Duration
+	// 封禁时长
+	BanDuration time.Duration
+	// 视为违规的连接断开异常类型，认证失败总是视为违规
+	Violations []errors.Kind
+}
+
+// ConfigDefault Get默认连接准入配置，默认不限制连接且不封禁
+func ConfigDefault() Config {
+	return Config{
+		BanWindow:   time.Minute,
+		BanDuration: 10 * time.Minute,
+		Violations: []errors.Kind{
+			qc.FormatError,
+			qc.FrameSizeError,
+			qc.ChecksumError,
+			qc.DecryptError,
+			qc.ReplayError,
+			qc.RateLimitError,
+		},
+	}
+}
+
+// Enabled 是否配置了任一准入限制
+func (c Config) Enabled() bool {
+	return c.MaxConns > 0 || c.MaxConnsPerIP > 0 || len(c.Allow) > 0 || len(c.Deny) > 0 || c.BanThreshold > 0
+}
+
+// Stats 连接准入的统计
+type Stats struct {
+	// 准入的连接数量
+	Accepted uint64
+	// 拒绝的连接数量
+	Rejected uint64
+	// 记录的违规次数
+	Violations uint64
+	// 自动封禁的次数
+	Bans uint64
+}
+
+// Guard Server的连接准入控制，在接受连接后检查地址、封禁与连接数限制
+type Guard struct {
+	logger.QLoggerAccessor
+	conf  Config
+	allow []*net.IPNet
+	deny  []*net.IPNet
+
+	mu sync.Mutex
+	// 当前的连接数量
+	conns int
+	// 各远端IP当前的连接数量
+	ips map[string]int
+	// 违规或被封禁的远端IP
+	offenders map[string]*offender
+	// 上次清理过期违规记录的时间
+	swept time.Time
+
+	accepted   atomic.Uint64
+	rejected   atomic.Uint64
+	violations atomic.Uint64
+	bans       atomic.Uint64
+	// 获取当前时间，测试时替换
+	now func() time.Time
+}
+
+// NewGuard 以conf创建连接准入控制，CIDR非法时返回异常
+func NewGuard(conf Config) (*Guard, *errors.QError) {
+	allow, err := parseCIDRs(conf.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(conf.Deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Guard{
+		conf:      conf,
+		allow:     allow,
+		deny:      deny,
+		ips:       make(map[string]int),
+		offenders: make(map[string]*offender),
+		now:       time.Now,
+	}, nil
+}
+
+func parseCIDRs(list []string) ([]*net.IPNet, *errors.QError) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("非法的IP地址: " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("非法的CIDR: " + s)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permitted ip是否在允许连接的地址范围内，不考虑封禁与连接数
+func (g *Guard) Permitted(ip net.IP) bool {
+	if ip == nil {
+		return len(g.allow) == 0
+	}
+	if contains(g.deny, ip) {
+		return false
+	}
+	return len(g.allow) == 0 || contains(g.allow, ip)
+}
+
+// Admit 检查远端地址为addr的连接能否准入，准入后连接关闭时需调用Conn.Close
+func (g *Guard) Admit(addr net.Addr) (*Conn, *errors.QError) {
+	ip := ipOf(addr)
+	key := addr.String()
+	if ip != nil {
+		key = ip.String()
+	}
+	if !g.Permitted(ip) {
+		return nil, g.reject(key + "不在允许连接的地址范围内")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if until, ok := g.banned(key, g.now()); ok {
+		return nil, g.reject(key + "已被封禁至" + until.Format(time.DateTime))
+	}
+	if g.conf.MaxConns > 0 && g.conns >= g.conf.MaxConns {
+		return nil, g.reject("连接数达到上限" + strconv.Itoa(g.conf.MaxConns) + "，拒绝" + key)
+	}
+	if g.conf.MaxConnsPerIP > 0 && g.ips[key] >= g.conf.MaxConnsPerIP {
+		return nil, g.reject(key + "的连接数达到上限" + strconv.Itoa(g.conf.MaxConnsPerIP))
+	}
+	g.conns++
+	g.ips[key]++
+	g.accepted.Add(1)
+	return &Conn{guard: g, ip: key}, nil
+}
+
+func (g *Guard) reject(reason string) *errors.QError {
+	g.rejected.Add(1)
+	return errors.NewKind(qc.ConnRejectedError, "拒绝连接，"+reason)
+}
+
+// Conns 当前的连接数量
+func (g *Guard) Conns() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.conns
+}
+
+// Stats 连接准入的统计
+func (g *Guard) Stats() Stats {
+	return Stats{
+		Accepted:   g.accepted.Load(),
+		Rejected:   g.rejected.Load(),
+		Violations: g.violations.Load(),
+		Bans:       g.bans.Load(),
+	}
+}
+
+func ipOf(addr net.Addr) net.IP {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// Conn 一个准入的连接，为nil时所有操作无效
+type Conn struct {
+	guard  *Guard
+	ip     string
+	closed atomic.Bool
+}
+
+// IP 连接的远端IP
+func (c *Conn) IP() string {
+	return c.ip
+}
+
+// Violate 记录该连接的远端IP的一次违规，返回远端IP是否因此被封禁
+func (c *Conn) Violate(reason string) bool {
+	if c == nil {
+		return false
+	}
+	return c.guard.Violate(c.ip, reason)
+}
+
+// Close 连接关闭时释放占用的连接数，err为配置中视为违规的异常类型时记录一次违规，重复调用无效
+func (c *Conn) Close(err *errors.QError) {
+	if c == nil || !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if err != nil {
+		for _, kind := range c.guard.conf.Violations {
+			if err.IsKind(kind) {
+				c.guard.Violate(c.ip, err.Error())
+				break
+			}
+		}
+	}
+	g := c.guard
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.conns--
+	if g.ips[c.ip]--; g.ips[c.ip] <= 0 {
+		delete(g.ips, c.ip)
+	}
+}
@@ -0,0 +1,146 @@
+package guard
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"net"
+	"testing"
+	"time"
+)
+
+func addr(ip string, port int) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: port}
+}
+
+func TestAllowDeny(t *testing.T) {
+	conf := ConfigDefault()
+	conf.Allow = []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"}
+	conf.Deny = []string{"10.1.0.0/16"}
+	g, err := NewGuard(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"10.2.3.4":    true,
+		"10.1.2.3":    false,
+		"192.168.1.7": true,
+		"192.168.1.8": false,
+		"2001:db8::1": true,
+		"::1":         false,
+	}
+	for ip, permitted := range cases {
+		c, err := g.Admit(addr(ip, 1000))
+		if permitted != (err == nil) {
+			t.Errorf("%s: expected permitted=%v, got %v", ip, permitted, err)
+		}
+		if err != nil && !err.IsKind(qc.ConnRejectedError) {
+			t.Errorf("%s: unexpected error kind %v", ip, err)
+		}
+		c.Close(nil)
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip"} {
+		conf.Deny = []string{bad}
+		if _, err := NewGuard(conf); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	conf := ConfigDefault()
+	conf.MaxConns = 3
+	conf.MaxConnsPerIP = 2
+	g, _ := NewGuard(conf)
+
+	a1, err := g.Admit(addr("10.0.0.1", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := g.Admit(addr("10.0.0.1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Admit(addr("10.0.0.1", 3)); err == nil {
+		t.Fatal("per ip limit not enforced")
+	}
+	if _, err := g.Admit(addr("10.0.0.2", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Admit(addr("10.0.0.3", 1)); err == nil {
+		t.Fatal("total limit not enforced")
+	}
+	// 重复关闭只释放一次
+	a1.Close(nil)
+	a1.Close(nil)
+	if g.Conns() != 2 {
+		t.Fatalf("unexpected conns %d", g.Conns())
+	}
+	if _, err := g.Admit(addr("10.0.0.3", 1)); err != nil {
+		t.Fatal(err)
+	}
+	a2.Close(nil)
+	if s := g.Stats(); s.Accepted != 4 || s.Rejected != 2 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestBan(t *testing.T) {
+	now := time.Unix(0, 0)
+	conf := ConfigDefault()
+	conf.BanThreshold = 3
+	conf.BanWindow = time.Minute
+	conf.BanDuration = 5 * time.Minute
+	g, _ := NewGuard(conf)
+	g.now = func() time.Time { return now }
+
+	// 协议违规断开与主动记录的违规均计数，其他断开原因不计数
+	c, _ := g.Admit(addr("10.0.0.1", 1))
+	c.Close(errors.NewKind(qc.FormatError, "bad frame"))
+	c, _ = g.Admit(addr("10.0.0.1", 2))
+	c.Close(errors.New("EOF"))
+	if g.Stats().Violations != 1 {
+		t.Fatalf("unexpected violations %d", g.Stats().Violations)
+	}
+	// 时间窗口结束后重新计数
+	now = now.Add(2 * time.Minute)
+	c, _ = g.Admit(addr("10.0.0.1", 3))
+	c.Violate("auth")
+	if c.Violate("auth") {
+		t.Fatal("banned before reaching the threshold")
+	}
+	if !c.Violate("auth") {
+		t.Fatal("not banned after reaching the threshold")
+	}
+	c.Close(nil)
+	if _, err := g.Admit(addr("10.0.0.1", 4)); !err.IsKind(qc.ConnRejectedError) {
+		t.Fatalf("banned address admitted: %v", err)
+	}
+	if _, err := g.Admit(addr("10.0.0.2", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if until, ok := g.Bans()["10.0.0.1"]; !ok || !until.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("unexpected bans %v", g.Bans())
+	}
+
+	// 封禁到期后恢复，过期的违规记录被清理
+	now = now.Add(6 * time.Minute)
+	if _, banned := g.Banned("10.0.0.1"); banned {
+		t.Fatal("ban did not expire")
+	}
+	g.Violate("10.0.0.3", "other")
+	if len(g.offenders) != 1 {
+		t.Errorf("expired offenders not swept: %d", len(g.offenders))
+	}
+
+	g.Ban("::ffff:10.0.0.4", time.Minute)
+	if _, banned := g.Banned("10.0.0.4"); !banned {
+		t.Fatal("manual ban not applied")
+	}
+	if !g.Unban("10.0.0.4") || g.Unban("10.0.0.4") {
+		t.Error("unexpected Unban result")
+	}
+	if s := g.Stats(); s.Bans != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,35 @@
+package qc
+
+// 服务端配置了认证器时，连接建立后先以控制消息完成认证握手，认证通过后才开始处理业务消息
+// 服务端发送AuthChallenge，客户端以AuthResponse应答，服务端校验后发送AuthResult，认证失败时服务端关闭连接
+
+// AuthError 认证失败时的异常类型
+const AuthError = "Auth"
+
+// AuthChallenge 服务端发送的认证质询
+type AuthChallenge struct {
+	// 认证方式
+	Method string `json:"method"`
+	// 质询数据，不需要质询的认证方式为空
+	Challenge []byte `json:"challenge,omitempty"`
+}
+
+// AuthResponse 客户端对认证质询的应答
+type AuthResponse struct {
+	// 认证方式
+	Method string `json:"method"`
+	// 客户端声明的身份，不需要声明身份的认证方式为空
+	Identity string `json:"identity,omitempty"`
+	// 证明身份的数据，如令牌或质询的签名
+	Proof []byte `json:"proof,omitempty"`
+}
+
+// AuthResult 服务端的认证结果
+type AuthResult struct {
+	// 是否认证通过
+	OK bool `json:"ok"`
+	// 认证通过的主体名称
+	Principal string `json:"principal,omitempty"`
+	// 认证失败的原因
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,67 @@
+package qc
+
+import (
+	"encoding/binary"
+)
+
+// 逻辑通道在一个连接上承载多个互不影响的会话，通道内的消息带有ExtChannel扩展
+// 每个通道拥有独立的消息顺序、SyncACK语义与回调，通道之间不会互相阻塞
+// 通道由任意一端通过ControlChannelOpen发起，对端以ControlChannelAccept应答后才可在通道内发送消息
+
+// FeatureChannel 能力声明中表示支持逻辑通道的特性名称
+const FeatureChannel = "channel"
+
+// ChannelAcceptor 通道ID的最高位，接受方发送的消息与控制消息中置位
+// 双方各自为发起的通道分配ID，通过该位区分通道是由哪一端发起的
+const ChannelAcceptor uint32 = 1 << 31
+
+// ChannelClosedError 对端正常关闭通道时通道异常的类型
+const ChannelClosedError = "ChannelClosed"
+
+// ChannelOpen 发起逻辑通道
+type ChannelOpen struct {
+	// 发起方分配的通道ID，不包含ChannelAcceptor位
+	ID uint32 `json:"id"`
+	// 通道名称，接受方根据名称选择回调
+	Name string `json:"name"`
+}
+
+// ChannelAccept 接受方对ChannelOpen的应答
+type ChannelAccept struct {
+	// 通道ID，包含ChannelAcceptor位
+	ID       uint32 `json:"id"`
+	Accepted bool   `json:"accepted"`
+	// 拒绝原因
+	Reason string `json:"reason,omitempty"`
+	// 拒绝码，与Nack.Code相同
+	Code string `json:"code,omitempty"`
+}
+
+// ChannelClose 关闭逻辑通道，双方均可发送
+type ChannelClose struct {
+	// 通道ID，由接受方发送时包含ChannelAcceptor位
+	ID uint32 `json:"id"`
+	// 关闭原因
+	Reason string `json:"reason,omitempty"`
+}
+
+// Channel 获取逻辑通道ID，不存在或格式错误时返回false
+func (e Extensions) Channel() (uint32, bool) {
+	value, ok := e.Get(ExtChannel)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(value), true
+}
+
+// SetChannel 设置逻辑通道ID
+func (e *Extensions) SetChannel(id uint32) {
+	value := make([]byte, 4)
+	binary.LittleEndian.PutUint32(value, id)
+	e.Set(ExtChannel, value)
+}
+
+// Channel 获取消息所属的逻辑通道ID，不属于任何通道时返回false
+func (d *QTPData) Channel() (uint32, bool) {
+	return d.Header.Extensions.Channel()
+}
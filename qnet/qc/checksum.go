@@ -0,0 +1,56 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// 消息开启校验后，会在消息末尾追加4byte的CRC32C校验和，校验范围为校验和之前的消息头与数据，校验和不计入DataLength
+// 校验和只会在对端声明支持后发送，因此旧版本的对端不会收到带校验和的消息
+
+// ChecksumLength 校验和byte长度
+const ChecksumLength = 4
+
+// FeatureChecksum 支持CRC32C校验和的能力声明
+const FeatureChecksum = "crc32c"
+
+// ChecksumError 校验和不匹配，表明消息在传输中已损坏
+const ChecksumError errors.Kind = "Checksum"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum 计算CRC32C校验和
+func Checksum(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+// UpdateChecksum 在crc的基础上继续计算CRC32C校验和
+func UpdateChecksum(crc uint32, data []byte) uint32 {
+	return crc32.Update(crc, crcTable, data)
+}
+
+// AppendChecksum 计算frame的校验和并追加到frame之后
+func AppendChecksum(frame []byte) []byte {
+	return binary.LittleEndian.AppendUint32(frame, Checksum(frame))
+}
+
+// VerifyChecksum 校验crc是否与数据的校验和一致
+func VerifyChecksum(crc uint32, checksum []byte) *errors.QError {
+	if len(checksum) < ChecksumLength || binary.LittleEndian.Uint32(checksum) != crc {
+		return errors.NewKind(ChecksumError, "校验和不匹配，消息已损坏")
+	}
+	return nil
+}
+
+// SetMsgType 修改已包装消息的消息类型，若消息带有校验和则重新计算
+// 各版本的消息头前HeaderLength个byte布局一致
+func SetMsgType(frame []byte, msgType MsgType) {
+	frame[15] = byte(msgType)
+	reserved := binary.LittleEndian.Uint16(frame[21:HeaderLength])
+	if reserved&ReservedChecksum == 0 {
+		return
+	}
+	end := len(frame) - ChecksumLength
+	binary.LittleEndian.PutUint32(frame[end:], Checksum(frame[:end]))
+}
@@ -0,0 +1,76 @@
+package qc
+
+import (
+	"encoding/binary"
+)
+
+// 分片传输将一个数据流切分为多个带有ExtStream扩展的DATA消息，分片之间可穿插其他消息
+// 接收方每处理完一个分片，通过ControlStreamCredit控制消息向发送方归还一个发送额度，发送方额度用尽时等待，以此实现背压
+
+// FeatureStream 能力声明中表示支持分片传输的特性名称
+const FeatureStream = "stream"
+
+// ExtStream扩展值的byte长度：StreamID(8byte)+Index(4byte)+Flags(1byte)
+const streamExtLength = 13
+
+const (
+	// StreamEnd 数据流的最后一个分片
+	StreamEnd uint8 = 1 << iota
+	// StreamAbort 发送方中止了数据流，该分片同时也是最后一个分片
+	StreamAbort
+)
+
+// StreamChunk 分片信息
+type StreamChunk struct {
+	// 数据流ID，由发送方生成
+	ID uint64
+	// 分片序号，从0开始连续递增
+	Index uint32
+	// 分片标志，见StreamEnd、StreamAbort
+	Flags uint8
+}
+
+// End 是否为数据流的最后一个分片
+func (c StreamChunk) End() bool {
+	return c.Flags&(StreamEnd|StreamAbort) != 0
+}
+
+// Aborted 发送方是否中止了数据流
+func (c StreamChunk) Aborted() bool {
+	return c.Flags&StreamAbort != 0
+}
+
+// StreamCredit 接收方归还的发送额度
+type StreamCredit struct {
+	// 数据流ID
+	ID uint64 `json:"id"`
+	// 归还的分片数量
+	Credits int `json:"credits"`
+}
+
+// Stream 获取分片信息，不存在或格式错误时返回false
+func (e Extensions) Stream() (StreamChunk, bool) {
+	value, ok := e.Get(ExtStream)
+	if !ok || len(value) != streamExtLength {
+		return StreamChunk{}, false
+	}
+	return StreamChunk{
+		ID:    binary.LittleEndian.Uint64(value[:8]),
+		Index: binary.LittleEndian.Uint32(value[8:12]),
+		Flags: value[12],
+	}, true
+}
+
+// SetStream 设置分片信息
+func (e *Extensions) SetStream(chunk StreamChunk) {
+	value := make([]byte, streamExtLength)
+	binary.LittleEndian.PutUint64(value[:8], chunk.ID)
+	binary.LittleEndian.PutUint32(value[8:12], chunk.Index)
+	value[12] = chunk.Flags
+	e.Set(ExtStream, value)
+}
+
+// Stream 获取消息的分片信息，不是分片时返回false
+func (d *QTPData) Stream() (StreamChunk, bool) {
+	return d.Header.Extensions.Stream()
+}
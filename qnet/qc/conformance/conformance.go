@@ -0,0 +1,210 @@
+// Package conformance QTP协议一致性测试
+// testdata中包含各版本协议的标准测试向量，vectors.json描述每个向量的消息头、数据与预期结果，对应的.bin文件为完整的消息
+// Go实现可直接调用Run进行验证，其他语言的实现可读取vectors.json与.bin文件进行同样的验证
+package conformance
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"testing"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+// Extension 测试向量中的消息头扩展
+type Extension struct {
+	Type  qc.ExtType `json:"type"`
+	Value []byte     `json:"value"`
+}
+
+// Mutation 在合法消息的基础上构造非法消息
+type Mutation struct {
+	// 将Offset处的byte与Xor异或
+	Offset int  `json:"offset"`
+	Xor    byte `json:"xor"`
+	// 截断后的byte长度，为0时不截断
+	Truncate int `json:"truncate,omitempty"`
+}
+
+// Vector 测试向量
+type Vector struct {
+	// 向量名称
+	Name string `json:"name"`
+	// testdata中对应的消息文件
+	File string `json:"file"`
+	// 是否为合法消息，非法消息必须被解析器拒绝
+	Valid      bool        `json:"valid"`
+	Version    uint8       `json:"version"`
+	Seq        uint64      `json:"seq"`
+	Encode     qc.Encode   `json:"encode"`
+	MsgType    qc.MsgType  `json:"msgType"`
+	ACKType    qc.ACKType  `json:"ackType"`
+	Checksum   bool        `json:"checksum"`
+	Extensions []Extension `json:"extensions,omitempty"`
+	Payload    []byte      `json:"payload"`
+	// 非法消息的构造方式
+	Mutation *Mutation `json:"mutation,omitempty"`
+}
+
+// Config 获取生成该向量所使用的包装配置
+func (v Vector) Config() qc.QTPConfig {
+	conf := qc.QTPConfig{
+		Encode:   v.Encode,
+		MsgType:  v.MsgType,
+		ACKType:  v.ACKType,
+		Checksum: v.Checksum,
+	}
+	for _, ext := range v.Extensions {
+		conf.Extensions.Set(ext.Type, ext.Value)
+	}
+	return conf
+}
+
+// Vectors 获取所有测试向量
+func Vectors() ([]Vector, *errors.QError) {
+	b, err := testdata.ReadFile("testdata/vectors.json")
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	var vectors []Vector
+	if err := json.Unmarshal(b, &vectors); err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return vectors, nil
+}
+
+// Frame 获取测试向量对应的消息
+func Frame(v Vector) ([]byte, *errors.QError) {
+	b, err := testdata.ReadFile("testdata/" + v.File)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return b, nil
+}
+
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+// Build 使用包装器按测试向量生成消息，用于生成testdata
+func Build(newEncoder func(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder, v Vector) ([]byte, *errors.QError) {
+	_, frame, err := newEncoder(fixedSeq(v.Seq)).Encode(v.Payload, v.Config())
+	if err != nil {
+		return nil, err
+	}
+	if m := v.Mutation; m != nil {
+		if m.Offset < len(frame) {
+			frame[m.Offset] ^= m.Xor
+		}
+		if m.Truncate > 0 && m.Truncate < len(frame) {
+			frame = frame[:m.Truncate]
+		}
+	}
+	return frame, nil
+}
+
+// Implementation 待验证的QTP实现
+type Implementation struct {
+	// 实现的协议版本，仅验证该版本的测试向量
+	Version uint8
+	// 新建解析器
+	NewParser func() qc.QTPParser
+	// 新建包装器
+	NewEncoder func(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder
+}
+
+// Run 使用所有该版本的测试向量验证实现
+// 合法消息需被Parse与ParseReader正确解析，且包装结果与向量完全一致；非法消息需被拒绝
+func Run(t *testing.T, impl Implementation) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, v := range vectors {
+		if v.Version != impl.Version {
+			continue
+		}
+		found = true
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			frame, err := Frame(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Valid {
+				checkValid(t, impl, v, frame)
+			} else {
+				checkInvalid(t, impl, frame)
+			}
+		})
+	}
+	if !found {
+		t.Fatalf("no conformance vectors for version %d", impl.Version)
+	}
+}
+
+func checkValid(t *testing.T, impl Implementation, v Vector, frame []byte) {
+	reader := bytes.NewReader(frame)
+	data := impl.NewParser().ParseReader(reader)
+	if data.ParserError != nil {
+		t.Fatalf("ParseReader: %v", data.ParserError)
+	}
+	checkData(t, "ParseReader", v, data)
+	if reader.Len() != 0 {
+		t.Errorf("ParseReader left %d bytes unread", reader.Len())
+	}
+	data.Release()
+
+	msgs := impl.NewParser().Parse(frame)
+	if len(msgs) != 1 || msgs[0].ParserError != nil {
+		t.Fatalf("Parse: expected one message, got %d", len(msgs))
+	}
+	checkData(t, "Parse", v, msgs[0])
+
+	encoded, err := Build(impl.NewEncoder, v)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.Equal(encoded, frame) {
+		t.Errorf("Encode:\n got %x\nwant %x", encoded, frame)
+	}
+}
+
+func checkData(t *testing.T, method string, v Vector, data *qc.QTPData) {
+	h := data.Header
+	if h.Version != v.Version || h.Seq != v.Seq || h.Encode != v.Encode || h.MsgType != v.MsgType ||
+		h.ACKType != v.ACKType || h.HasChecksum() != v.Checksum || int(h.DataLength) != len(v.Payload) {
+		t.Errorf("%s: unexpected header %+v", method, h)
+	}
+	if !bytes.Equal(data.Data, v.Payload) {
+		t.Errorf("%s: unexpected payload %x", method, data.Data)
+	}
+	if len(h.Extensions) != len(v.Extensions) {
+		t.Fatalf("%s: expected %d extensions, got %d", method, len(v.Extensions), len(h.Extensions))
+	}
+	for i, ext := range v.Extensions {
+		if h.Extensions[i].Type != ext.Type || !bytes.Equal(h.Extensions[i].Value, ext.Value) {
+			t.Errorf("%s: unexpected extension %d: %+v", method, i, h.Extensions[i])
+		}
+	}
+}
+
+func checkInvalid(t *testing.T, impl Implementation, frame []byte) {
+	data := impl.NewParser().ParseReader(bytes.NewReader(frame))
+	if data.ParserError == nil {
+		t.Error("ParseReader accepted an invalid frame")
+	}
+	data.Release()
+	for _, msg := range impl.NewParser().Parse(frame) {
+		if msg.ParserError == nil {
+			t.Error("Parse accepted an invalid frame")
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package conformance
+
+import (
+	"QuantumUtils/qnet/qc"
+	v1 "QuantumUtils/qnet/qc/v1"
+	v2 "QuantumUtils/qnet/qc/v2"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "根据vectors.json重新生成testdata中的消息文件")
+
+// TestUpdate 使用 go test -run TestUpdate -update 重新生成消息文件
+func TestUpdate(t *testing.T) {
+	if !*update {
+		t.Skip("run with -update to regenerate testdata")
+	}
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		frame, err := Build(func(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder {
+			encoder, err := qc.NewEncoder(v.Version, seqGenerator)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return encoder
+		}, v)
+		if err != nil {
+			t.Fatal(v.Name, err)
+		}
+		if err := os.WriteFile(filepath.Join("testdata", v.File), frame, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestV1(t *testing.T) {
+	Run(t, Implementation{Version: qc.BaseVersion, NewParser: v1.NewQMsgParser, NewEncoder: v1.NewQMsgEncoder})
+	Run(t, Implementation{Version: qc.BaseVersion, NewParser: v1.NewPooledQMsgParser, NewEncoder: v1.NewQMsgEncoder})
+}
+
+func TestV2(t *testing.T) {
+	Run(t, Implementation{Version: v2.Version, NewParser: v2.NewQMsgParser, NewEncoder: v2.NewQMsgEncoder})
+}
+
+func TestVersioned(t *testing.T) {
+	for _, version := range qc.Versions() {
+		version := version
+		Run(t, Implementation{
+			Version: version,
+			NewParser: func() qc.QTPParser {
+				return qc.NewVersionedParser(qc.ParserOptions{})
+			},
+			NewEncoder: func(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder {
+				encoder, _ := qc.NewEncoder(version, seqGenerator)
+				return encoder
+			},
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"encoding/json"
+)
+
+// 控制消息用于连接双方交换协议层面的信息（如能力协商），不会交给业务回调处理
+// 控制消息以序列号为ControlSeq的ACK消息发送，旧版本的对端会将其视为未知的ACK直接忽略，因此不会影响兼容性
+
+// ControlSeq 控制消息的序列号
+const ControlSeq uint64 = 0
+
+const (
+	// ControlHello 连接建立时交换的能力声明
+	ControlHello = "hello"
+	// ControlStreamCredit 分片传输中接收方归还的发送额度，见StreamCredit
+	ControlStreamCredit = "stream-credit"
+	// ControlChannelOpen 发起逻辑通道，见ChannelOpen
+	ControlChannelOpen = "channel-open"
+	// ControlChannelAccept 对逻辑通道请求的应答，见ChannelAccept
+	ControlChannelAccept = "channel-accept"
+	// ControlChannelClose 关闭逻辑通道，见ChannelClose
+	ControlChannelClose = "channel-close"
+	// ControlAuthChallenge 服务端发送的认证质询，见AuthChallenge
+	ControlAuthChallenge = "auth-challenge"
+	// ControlAuthResponse 客户端对认证质询的应答，见AuthResponse
+	ControlAuthResponse = "auth-response"
+	// ControlAuthResult 服务端的认证结果，见AuthResult
+	ControlAuthResult = "auth-result"
+)
+
+// ControlConfig 控制消息的配置
+var ControlConfig = QTPConfig{
+	Encode:  JSON,
+	MsgType: ACK,
+	ACKType: NoACK,
+}
+
+// Control 控制消息
+type Control struct {
+	// 控制消息类型
+	Type string `json:"type"`
+	// 控制消息内容
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Hello 连接建立时双方交换的能力声明
+type Hello struct {
+	// 支持的协议版本
+	Versions []int `json:"versions,omitempty"`
+	// 可解压的压缩算法名称
+	Compress []string `json:"compress,omitempty"`
+	// 支持的协议特性
+	Features []string `json:"features,omitempty"`
+	// 本端的加密握手随机数，支持加密时与对端的随机数共同派生连接的密钥
+	Salt []byte `json:"salt,omitempty"`
+}
+
+// HasFeature 判断是否支持某一协议特性
+func (h Hello) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// IsControl 判断消息是否为控制消息
+func (d *QTPData) IsControl() bool {
+	return d.Header.MsgType == ACK && d.Header.Seq == ControlSeq
+}
+
+// NewControl 创建一个控制消息
+func NewControl(controlType string, body any) (*Control, *errors.QError) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return &Control{Type: controlType, Body: raw}, nil
+}
+
+// ParseControl 解析控制消息
+func ParseControl(data []byte) (*Control, *errors.QError) {
+	control := Control{}
+	if err := json.Unmarshal(data, &control); err != nil {
+		return nil, errors.New("控制消息解析失败，" + err.Error())
+	}
+	return &control, nil
+}
+
+// Decode 将控制消息内容解析至v
+func (c *Control) Decode(v any) *errors.QError {
+	if err := json.Unmarshal(c.Body, v); err != nil {
+		return errors.New("控制消息解析失败，" + err.Error())
+	}
+	return nil
+}
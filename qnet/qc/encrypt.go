@@ -0,0 +1,39 @@
+package qc
+
+import "QuantumUtils/errors"
+
+// 双方均配置了预共享密钥时，DATA消息的数据以AES-256-GCM加密，消息头扩展中记录所用的密钥ID
+// 加密在压缩之后进行，接收方先解密再解压；ACK与控制消息不加密
+// 消息类型、ACK类型、编码、压缩算法与除加密外的消息头扩展参与认证，接收方拒绝nonce重复的消息
+
+// FeatureEncryption 支持AES-GCM加密的能力声明
+const FeatureEncryption = "aes-gcm"
+
+// DecryptError 消息解密或认证失败时的异常类型
+const DecryptError errors.Kind = "Decrypt"
+
+// StaleError 加密消息的密钥在写出或重发前已随重连更换时的异常类型，消息未送达，需由调用方重新发送
+const StaleError errors.Kind = "Stale"
+
+// ReplayError 收到nonce或签名随机数已被使用的消息时的异常类型，重复的RETRY消息会被直接丢弃
+const ReplayError errors.Kind = "Replay"
+
+// Encryption 获取加密数据所用的密钥ID，未加密时返回false
+func (e Extensions) Encryption() (uint8, bool) {
+	value, ok := e.Get(ExtEncryption)
+	if !ok || len(value) != 1 {
+		return 0, false
+	}
+	return value[0], true
+}
+
+// SetEncryption 设置加密数据所用的密钥ID
+func (e *Extensions) SetEncryption(keyID uint8) {
+	e.Set(ExtEncryption, []byte{keyID})
+}
+
+// Encrypted 消息数据在传输中是否经过加密
+func (d *QTPData) Encrypted() bool {
+	_, ok := d.Header.Extensions.Encryption()
+	return ok
+}
@@ -0,0 +1,174 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"encoding/binary"
+	"time"
+)
+
+// UnsupportedError 对端协商的协议版本或能力不支持所需功能的异常类型
+const UnsupportedError errors.Kind = "Unsupported"
+
+// 消息头扩展从v2版本协议开始支持，以Type(1byte)+Length(2byte)+Value的形式依次排列在消息头之后
+// 未知类型的扩展会被原样保留，由上层决定是否使用
+
+// ExtType 扩展类型
+type ExtType uint8
+
+const (
+	// ExtTraceID 链路追踪ID
+	ExtTraceID ExtType = iota + 1
+	// ExtContentType 数据内容类型
+	ExtContentType
+	// ExtTTL 消息有效期，以毫秒为单位的uint32
+	ExtTTL
+	// ExtRoute 消息路由名称
+	ExtRoute
+	// ExtStream 分片传输信息，见StreamChunk
+	ExtStream
+	// ExtChannel 逻辑通道ID，uint32
+	ExtChannel
+	// ExtEncryption 加密数据所用的密钥ID，uint8
+	ExtEncryption
+	// ExtSignature 消息签名，签名者ID加64byte的Ed25519签名
+	ExtSignature
+	// ExtSignedAt 签名时间与随机数，以毫秒为单位的Unix时间int64加uint64随机数，参与签名
+	ExtSignedAt
+)
+
+// ExtMaxLength 单个扩展值及扩展区总长度的上限
+const ExtMaxLength = 0xFFFF
+
+// Extension 消息头扩展
+type Extension struct {
+	Type  ExtType
+	Value []byte
+}
+
+// Extensions 消息头扩展列表
+type Extensions []Extension
+
+// Get 获取第一个指定类型的扩展值
+func (e Extensions) Get(t ExtType) ([]byte, bool) {
+	for _, ext := range e {
+		if ext.Type == t {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set 设置指定类型的扩展值，已存在时覆盖
+func (e *Extensions) Set(t ExtType, value []byte) {
+	for i, ext := range *e {
+		if ext.Type == t {
+			(*e)[i].Value = value
+			return
+		}
+	}
+	*e = append(*e, Extension{Type: t, Value: value})
+}
+
+// Del 删除指定类型的扩展
+func (e *Extensions) Del(t ExtType) {
+	result := (*e)[:0]
+	for _, ext := range *e {
+		if ext.Type != t {
+			result = append(result, ext)
+		}
+	}
+	*e = result
+}
+
+// Size 扩展编码后的byte长度
+func (e Extensions) Size() int {
+	size := 0
+	for _, ext := range e {
+		size += 3 + len(ext.Value)
+	}
+	return size
+}
+
+// Clone 深拷贝扩展，拷贝结果不再引用原消息的缓冲
+func (e Extensions) Clone() Extensions {
+	if e == nil {
+		return nil
+	}
+	result := make(Extensions, len(e))
+	for i, ext := range e {
+		result[i] = Extension{Type: ext.Type, Value: append([]byte(nil), ext.Value...)}
+	}
+	return result
+}
+
+// TraceID 获取链路追踪ID
+func (e Extensions) TraceID() string {
+	v, _ := e.Get(ExtTraceID)
+	return string(v)
+}
+
+// SetTraceID 设置链路追踪ID
+func (e *Extensions) SetTraceID(traceID string) {
+	e.Set(ExtTraceID, []byte(traceID))
+}
+
+// ContentType 获取数据内容类型
+func (e Extensions) ContentType() string {
+	v, _ := e.Get(ExtContentType)
+	return string(v)
+}
+
+// SetContentType 设置数据内容类型
+func (e *Extensions) SetContentType(contentType string) {
+	e.Set(ExtContentType, []byte(contentType))
+}
+
+// TTL 获取消息有效期
+func (e Extensions) TTL() (time.Duration, bool) {
+	v, ok := e.Get(ExtTTL)
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return time.Duration(binary.LittleEndian.Uint32(v)) * time.Millisecond, true
+}
+
+// SetTTL 设置消息有效期，精度为毫秒
+func (e *Extensions) SetTTL(ttl time.Duration) {
+	e.Set(ExtTTL, binary.LittleEndian.AppendUint32(nil, uint32(ttl.Milliseconds())))
+}
+
+// Route 获取消息路由名称
+func (e Extensions) Route() string {
+	v, _ := e.Get(ExtRoute)
+	return string(v)
+}
+
+// SetRoute 设置消息路由名称
+func (e *Extensions) SetRoute(route string) {
+	e.Set(ExtRoute, []byte(route))
+}
+
+// Extension 获取消息头中指定类型的扩展值
+func (d *QTPData) Extension(t ExtType) ([]byte, bool) {
+	return d.Header.Extensions.Get(t)
+}
+
+// TraceID 获取消息的链路追踪ID
+func (d *QTPData) TraceID() string {
+	return d.Header.Extensions.TraceID()
+}
+
+// ContentType 获取消息的数据内容类型
+func (d *QTPData) ContentType() string {
+	return d.Header.Extensions.ContentType()
+}
+
+// TTL 获取消息的有效期
+func (d *QTPData) TTL() (time.Duration, bool) {
+	return d.Header.Extensions.TTL()
+}
+
+// Route 获取消息的路由名称
+func (d *QTPData) Route() string {
+	return d.Header.Extensions.Route()
+}
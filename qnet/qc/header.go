@@ -0,0 +1,91 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"bytes"
+	"encoding/binary"
+)
+
+// FormatError 消息格式不符合QTP规范的异常类型
+const FormatError errors.Kind = "Format"
+
+// ParseHeader 解析各版本共用的前HeaderLength个byte至header，并校验版本号与各枚举值
+func ParseHeader(buf []byte, version uint8, header *QTPHeader) *errors.QError {
+	header.HeaderFlag = HeaderFlag
+	header.Version = version
+	if len(buf) < HeaderLength {
+		err := errors.NewKind(FormatError, "解析失败，byte切片长度不足，非QMsg协议类型")
+		return err
+	}
+
+	if !bytes.Equal(buf[:5], HeaderFlag[:]) {
+		err := errors.NewKind(FormatError, "解析失败，协议Flag解析失败，非QMsg协议类型")
+		return err
+	}
+
+	if buf[5] != version {
+		err := errors.NewKind(FormatError, "解析失败，协议版本与解析器版本不符合")
+		return err
+	}
+
+	header.Seq = binary.LittleEndian.Uint64(buf[6:14])
+
+	// 编码方式由上层的编码注册表解释，这里只校验是否处于合法范围
+	if buf[14] > byte(MaxEncode) {
+		err := errors.NewKind(FormatError, "解析失败，数据编码类型解析失败")
+		return err
+	}
+	header.Encode = Encode(buf[14])
+
+	switch buf[15] {
+	case byte(ACK):
+		{
+			header.MsgType = ACK
+			break
+		}
+	case byte(DATA):
+		{
+			header.MsgType = DATA
+			break
+		}
+	case byte(RETRY):
+		{
+			header.MsgType = RETRY
+			break
+		}
+	default:
+		{
+			err := errors.NewKind(FormatError, "解析失败，消息类型解析失败")
+			return err
+		}
+
+	}
+
+	switch buf[16] {
+	case byte(NoACK):
+		{
+			header.ACKType = NoACK
+			break
+		}
+	case byte(SyncACK):
+		{
+			header.ACKType = SyncACK
+			break
+		}
+	case byte(AsyncACK):
+		{
+			header.ACKType = AsyncACK
+			break
+		}
+	default:
+		{
+			err := errors.NewKind(FormatError, "解析失败，ACK机制解析失败")
+			return err
+		}
+
+	}
+
+	header.DataLength = binary.LittleEndian.Uint32(buf[17:21])
+	header.Reserved = binary.LittleEndian.Uint16(buf[21:HeaderLength])
+	return nil
+}
@@ -0,0 +1,31 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"strconv"
+)
+
+const (
+	// FrameSizeError 消息长度超过限制的异常类型
+	FrameSizeError errors.Kind = "FrameSize"
+	// BufferLimitError 等待处理的消息占用的内存超过限制的异常类型
+	BufferLimitError errors.Kind = "BufferLimit"
+	// RateLimitError 消息速率超过限制的异常类型
+	RateLimitError errors.Kind = "RateLimit"
+	// ConnRejectedError 连接未被准入的异常类型
+	ConnRejectedError errors.Kind = "ConnRejected"
+)
+
+// DefaultMaxFrameSize 默认的单个消息数据最大byte长度，16MB
+const DefaultMaxFrameSize = 16 << 20
+
+// NackRateLimited Nack.Code，消息速率超过对端的限制
+const NackRateLimited = "rate-limited"
+
+// CheckFrameSize 校验消息长度是否超过maxSize，maxSize不大于0时不限制
+func CheckFrameSize(size int, maxSize int) *errors.QError {
+	if maxSize > 0 && size > maxSize {
+		return errors.NewKind(FrameSizeError, "消息长度"+strconv.Itoa(size)+"byte超过了限制的"+strconv.Itoa(maxSize)+"byte")
+	}
+	return nil
+}
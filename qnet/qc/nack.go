@@ -0,0 +1,57 @@
+package qc
+
+import "QuantumUtils/errors"
+
+// 接收方拒绝处理消息时，以带有数据的ACK消息（NACK）代替普通的ACK，数据为JSON编码的Nack
+// 发送方收到NACK后结束消息的生命周期，LCE收到NackError类型的异常；旧版本的发送方会忽略ACK中的数据，将其视为普通的ACK
+
+// NackError 消息被对端拒绝时LCE收到的异常类型
+const NackError errors.Kind = "Nack"
+
+// RouteNotFoundError 对端没有处理消息路由的回调时LCE收到的异常类型
+const RouteNotFoundError errors.Kind = "RouteNotFound"
+
+// NackRouteNotFound Nack.Code，对端没有处理消息路由的回调
+const NackRouteNotFound = "route-not-found"
+
+// PermissionDeniedError 对端的授权策略拒绝处理消息时LCE收到的异常类型
+const PermissionDeniedError errors.Kind = "PermissionDenied"
+
+// NackPermissionDenied Nack.Code，对端的授权策略拒绝处理消息
+const NackPermissionDenied = "permission-denied"
+
+// SignatureError 消息缺少签名或签名校验失败时的异常类型
+const SignatureError errors.Kind = "Signature"
+
+// NackSignatureInvalid Nack.Code，消息缺少签名或签名校验失败
+const NackSignatureInvalid = "signature-invalid"
+
+// DroppedError 消息被本端的出站拦截器丢弃、没有发送时LCE收到的异常类型
+const DroppedError errors.Kind = "Dropped"
+
+// Nack NACK消息的数据
+type Nack struct {
+	// 拒绝原因
+	Reason string `json:"reason"`
+	// 拒绝类型，为空时表示回调拒绝了消息，见NackRouteNotFound、NackPermissionDenied、NackSignatureInvalid与NackRateLimited
+	Code string `json:"code,omitempty"`
+}
+
+// Reject 拒绝处理该消息，回调结束后以NACK代替ACK应答发送方，多次调用时以最后一次为准
+// NoACK消息无需应答，调用无效
+func (d *QTPData) Reject(reason string) {
+	d.RejectWith(Nack{Reason: reason})
+}
+
+// RejectWith 以nack拒绝处理该消息，见Reject
+func (d *QTPData) RejectWith(nack Nack) {
+	d.rejection = &nack
+}
+
+// Rejected 获取拒绝处理该消息的NACK，未被拒绝时返回false
+func (d *QTPData) Rejected() (Nack, bool) {
+	if d.rejection == nil {
+		return Nack{}, false
+	}
+	return *d.rejection, true
+}
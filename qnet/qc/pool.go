@@ -0,0 +1,81 @@
+package qc
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	// 缓冲池最小的容量等级，64byte
+	minPoolShift = 6
+	// 缓冲池最大的容量等级，1MB，超过该大小的缓冲不会被回收
+	maxPoolShift = 20
+)
+
+// 按容量分级的byte缓冲池，每一级的容量为2的幂
+var bufferPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+// QTPData对象池
+var dataPool = sync.Pool{New: func() any { return &QTPData{} }}
+
+// 获取size所在的容量等级，超过最大等级时返回-1
+func poolIndex(size int) int {
+	if size <= 1<<minPoolShift {
+		return 0
+	}
+	shift := bits.Len(uint(size - 1))
+	if shift > maxPoolShift {
+		return -1
+	}
+	return shift - minPoolShift
+}
+
+// GetBuffer 从缓冲池中获取一个长度为size的缓冲，使用完毕后需调用PutBuffer归还
+func GetBuffer(size int) *[]byte {
+	index := poolIndex(size)
+	if index < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if v := bufferPools[index].Get(); v != nil {
+		buf := v.(*[]byte)
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make([]byte, size, 1<<(index+minPoolShift))
+	return &buf
+}
+
+// PutBuffer 将缓冲归还至缓冲池，归还后不可再使用该缓冲
+func PutBuffer(buf *[]byte) {
+	c := cap(*buf)
+	// 只回收容量恰好为某一等级的缓冲
+	if c < 1<<minPoolShift || c&(c-1) != 0 {
+		return
+	}
+	index := bits.Len(uint(c)) - 1 - minPoolShift
+	if index >= len(bufferPools) {
+		return
+	}
+	bufferPools[index].Put(buf)
+}
+
+// AcquireData 从对象池中获取一个QTPData，其Data为缓冲池中长度为size的缓冲
+// 使用完毕后需调用QTPData.Release归还
+func AcquireData(size int) *QTPData {
+	data := dataPool.Get().(*QTPData)
+	data.buf = GetBuffer(size)
+	data.Data = *data.buf
+	return data
+}
+
+// Release 归还QTPData所持有的缓冲，仅对AcquireData获取的QTPData生效，其余情况无任何操作
+// Release后不可再访问该QTPData及其Data
+func (d *QTPData) Release() {
+	if d == nil || d.buf == nil {
+		return
+	}
+	PutBuffer(d.buf)
+	*d = QTPData{}
+	dataPool.Put(d)
+}
@@ -27,8 +27,13 @@ const (
 	JSON Encode = iota
 	// BINARY 二进制byte流
 	BINARY
+	// GOB encoding/gob编码
+	GOB
 )
 
+// MaxEncode 编码方式标识的最大值，最高位保留，用户注册的编码方式建议从64开始
+const MaxEncode Encode = 0x7F
+
 const (
 	// ACK ACK消息
 	ACK MsgType = iota
@@ -47,6 +52,13 @@ const (
 	AsyncACK
 )
 
+const (
+	// ReservedCompressMask 预留字节的低4位，标识数据所使用的压缩算法，为0时表明数据未被压缩
+	ReservedCompressMask uint16 = 0x000F
+	// ReservedChecksum 预留字节的第5位，标识消息末尾带有CRC32C校验和
+	ReservedChecksum uint16 = 0x0010
+)
+
 // QTPHeader QTP协议消息头，共占23byte
 type QTPHeader struct {
 	// 标志头，占5byte
@@ -65,6 +77,18 @@ type QTPHeader struct {
 	DataLength uint32
 	// 预留位置，占2byte
 	Reserved uint16
+	// 消息头扩展，v2版本协议开始支持
+	Extensions Extensions
+}
+
+// HasChecksum 消息末尾是否带有校验和
+func (h QTPHeader) HasChecksum() bool {
+	return h.Reserved&ReservedChecksum != 0
+}
+
+// CompressID 获取数据所使用的压缩算法标识，为0时表明数据未被压缩
+func (h QTPHeader) CompressID() uint8 {
+	return uint8(h.Reserved & ReservedCompressMask)
 }
 
 type QTPData struct {
@@ -72,14 +96,33 @@ type QTPData struct {
 	Data   []byte
 	// 若不为nil，则表明该QTPData无法被正常解析
 	ParserError *errors.QError
+	// Data所属的池化缓冲，为nil时表明Data不来自缓冲池
+	buf *[]byte
+	// 回调拒绝处理该消息的原因，见Reject
+	rejection *Nack
 }
 type QTPConfig struct {
 	Encode  Encode
 	MsgType MsgType
 	ACKType ACKType
+	// 数据所使用的压缩算法标识，为0时表明数据未被压缩
+	Compress uint8
+	// 是否在消息末尾追加校验和
+	Checksum bool
+	// 消息头扩展，v2版本协议开始支持
+	Extensions Extensions
+}
+
+// Reserved 根据配置生成消息头的预留字节
+func (c QTPConfig) Reserved() uint16 {
+	reserved := uint16(c.Compress) & ReservedCompressMask
+	if c.Checksum {
+		reserved |= ReservedChecksum
+	}
+	return reserved
 }
 
-// QTPParser QTP协议解析器
+// QTPParser QTP协议解析器，解析器不持有任何连接相关的状态，可被多个goroutine并发使用
 type QTPParser interface {
 	// Parse 将数据包解析为QTPData，同时解决粘包问题，解析失败的会被丢弃
 	Parse(buf []byte) []*QTPData
@@ -91,6 +134,10 @@ type QTPParser interface {
 type QTPEncoder interface {
 	// Encode 包装数据
 	Encode(buf []byte, config QTPConfig) (uint64, []byte, *errors.QError)
+	// AppendEncode 包装数据并追加到dst之后，dst容量足够时不会产生内存分配
+	AppendEncode(dst []byte, buf []byte, config QTPConfig) (uint64, []byte, *errors.QError)
+	// EncodeTo 包装数据并直接写入writer，返回序列号与写入的byte数
+	EncodeTo(writer io.Writer, buf []byte, config QTPConfig) (uint64, int, *errors.QError)
 }
 
 // QTPSeqGenerator QTP序列号生成器
@@ -0,0 +1,197 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"bufio"
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 各版本的QTP协议通过RegisterVersion注册解析器与包装器，连接双方在能力声明中交换支持的版本，并使用双方都支持的最高版本发送消息
+// 各版本消息头的前HeaderLength个byte布局保持一致，解析时根据第6个byte的版本号选择解析器
+
+// BaseVersion 所有实现都必须支持的协议版本，控制消息始终使用该版本发送
+const BaseVersion uint8 = 1
+
+// ParserOptions 解析器选项
+type ParserOptions struct {
+	// 是否使用缓冲池，开启后ParseReader返回的QTPData需在使用完毕后调用Release归还
+	Pooled bool
+	// 单个消息数据（v2版本协议包含扩展区）的最大byte长度，超过时在分配内存前拒绝该消息，不大于0时不限制
+	MaxFrameSize int
+}
+
+// ParserFactory 解析器构造方法
+type ParserFactory func(opts ParserOptions) QTPParser
+
+// EncoderFactory 包装器构造方法
+type EncoderFactory func(seqGenerator QTPSeqGenerator) QTPEncoder
+
+type versionImpl struct {
+	parser  ParserFactory
+	encoder EncoderFactory
+}
+
+var (
+	versionMu sync.RWMutex
+	versions  = make(map[uint8]versionImpl)
+)
+
+// RegisterVersion 注册某一版本的解析器与包装器，版本重复注册时会panic
+func RegisterVersion(version uint8, parser ParserFactory, encoder EncoderFactory) {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+	if _, ok := versions[version]; ok {
+		panic("QTP协议版本重复注册: " + strconv.Itoa(int(version)))
+	}
+	versions[version] = versionImpl{parser: parser, encoder: encoder}
+}
+
+// Versions 获取所有已注册的协议版本，按从低到高排列
+func Versions() []uint8 {
+	versionMu.RLock()
+	defer versionMu.RUnlock()
+	result := make([]uint8, 0, len(versions))
+	for version := range versions {
+		result = append(result, version)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func getVersion(version uint8) (versionImpl, *errors.QError) {
+	versionMu.RLock()
+	defer versionMu.RUnlock()
+	impl, ok := versions[version]
+	if !ok {
+		return versionImpl{}, errors.New("不支持的QTP协议版本: " + strconv.Itoa(int(version)))
+	}
+	return impl, nil
+}
+
+// NewParser 获取某一版本的解析器
+func NewParser(version uint8, opts ParserOptions) (QTPParser, *errors.QError) {
+	impl, err := getVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return impl.parser(opts), nil
+}
+
+// NewEncoder 获取某一版本的包装器
+func NewEncoder(version uint8, seqGenerator QTPSeqGenerator) (QTPEncoder, *errors.QError) {
+	impl, err := getVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return impl.encoder(seqGenerator), nil
+}
+
+// NegotiateVersion 选出双方都支持的最高版本，remote为空（旧版本对端）或没有共同版本时使用BaseVersion
+func NegotiateVersion(local []uint8, remote []uint8) uint8 {
+	result := BaseVersion
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r && l > result {
+				result = l
+			}
+		}
+	}
+	return result
+}
+
+// 可预读的Reader
+type peeker interface {
+	Peek(n int) ([]byte, error)
+}
+
+// VersionedParser 根据消息头中的版本号选择对应版本的解析器，各版本的解析器按需创建并复用
+type VersionedParser struct {
+	opts    ParserOptions
+	parsers map[uint8]QTPParser
+	mu      sync.Mutex
+}
+
+// NewVersionedParser 新建一个根据版本号选择解析器的解析器
+func NewVersionedParser(opts ParserOptions) *VersionedParser {
+	return &VersionedParser{
+		opts:    opts,
+		parsers: make(map[uint8]QTPParser),
+	}
+}
+
+func (p *VersionedParser) get(version uint8) (QTPParser, *errors.QError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parser, ok := p.parsers[version]
+	if ok {
+		return parser, nil
+	}
+	parser, err := NewParser(version, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	p.parsers[version] = parser
+	return parser, nil
+}
+
+// Parse 按消息头中的长度依次解析buf中的完整消息，每个消息根据各自的版本号选择解析器，末尾不完整的消息会被丢弃
+func (p *VersionedParser) Parse(buf []byte) []*QTPData {
+	msgSlice, _ := ParseFrames(buf, p.ParseFrame)
+	return msgSlice
+}
+
+// ParseFrame 根据buf起始处消息头中的版本号选择解析器解析单个消息
+func (p *VersionedParser) ParseFrame(buf []byte) (*QTPData, int) {
+	if len(buf) < 6 {
+		return nil, 0
+	}
+	parser, err := p.get(buf[5])
+	if err != nil {
+		return &QTPData{ParserError: errors.NewKind(FormatError, err.Error())}, 0
+	}
+	frameParser, ok := parser.(QTPFrameParser)
+	if !ok {
+		return &QTPData{ParserError: errors.NewKind(FormatError, "QTP协议版本"+strconv.Itoa(int(buf[5]))+"的解析器不支持按长度解析")}, 0
+	}
+	return frameParser.ParseFrame(buf)
+}
+
+// ParseReader 预读消息头中的版本号后交由对应版本的解析器解析，reader可预读（如bufio.Reader）时不会产生额外的内存分配
+func (p *VersionedParser) ParseReader(reader io.Reader) *QTPData {
+	var prefix []byte
+	peek, ok := reader.(peeker)
+	if ok {
+		b, err := peek.Peek(6)
+		if err != nil {
+			return &QTPData{ParserError: errors.New(err.Error())}
+		}
+		prefix = b
+	} else {
+		prefix = make([]byte, 6)
+		if _, err := io.ReadFull(reader, prefix); err != nil {
+			return &QTPData{ParserError: errors.New(err.Error())}
+		}
+		reader = io.MultiReader(bytes.NewReader(prefix), reader)
+	}
+	if !bytes.Equal(prefix[:5], HeaderFlag[:]) {
+		err := errors.NewKind(FormatError, "解析失败，协议Flag解析失败，非QMsg协议类型")
+		err.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		return &QTPData{ParserError: err}
+	}
+	parser, err := p.get(prefix[5])
+	if err != nil {
+		err = errors.NewKind(FormatError, err.Error())
+		err.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		return &QTPData{ParserError: err}
+	}
+	return parser.ParseReader(reader)
+}
+
+// NewBufferedReader 为连接包装一个可预读的Reader，供VersionedParser使用
+func NewBufferedReader(reader io.Reader) *bufio.Reader {
+	return bufio.NewReader(reader)
+}
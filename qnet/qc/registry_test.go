@@ -0,0 +1,72 @@
+package qc_test
+
+import (
+	"QuantumUtils/qnet/qc"
+	// 注册v1版本协议
+	_ "QuantumUtils/qnet/qc/v1"
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		local, remote []uint8
+		want          uint8
+	}{
+		{[]uint8{1, 2}, []uint8{1, 2, 3}, 2},
+		{[]uint8{1, 2, 3}, []uint8{1}, 1},
+		{[]uint8{1, 2}, nil, qc.BaseVersion},
+		{[]uint8{2}, []uint8{3}, qc.BaseVersion},
+	}
+	for _, c := range cases {
+		if got := qc.NegotiateVersion(c.local, c.remote); got != c.want {
+			t.Errorf("NegotiateVersion(%v, %v) = %d, want %d", c.local, c.remote, got, c.want)
+		}
+	}
+}
+
+func TestVersionedParser(t *testing.T) {
+	conf := qc.QTPConfig{Encode: qc.JSON, MsgType: qc.DATA, ACKType: qc.SyncACK}
+	encoder, err := qc.NewEncoder(qc.BaseVersion, fixedSeq(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, frame, err := encoder.Encode([]byte(`{"v":1}`), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := append(append([]byte(nil), frame...), frame...)
+
+	parser := qc.NewVersionedParser(qc.ParserOptions{})
+	// 可预读与不可预读的Reader均可解析
+	for _, reader := range []interface{ Read([]byte) (int, error) }{
+		bufio.NewReader(bytes.NewReader(stream)),
+		bytes.NewBuffer(append([]byte(nil), stream...)),
+	} {
+		for i := 0; i < 2; i++ {
+			data := parser.ParseReader(reader)
+			if data.ParserError != nil {
+				t.Fatal(data.ParserError)
+			}
+			if data.Header.Seq != 3 || string(data.Data) != `{"v":1}` {
+				t.Fatalf("unexpected frame %+v", data)
+			}
+		}
+	}
+
+	unknown := append([]byte(nil), frame...)
+	unknown[5] = 0xEE
+	if data := parser.ParseReader(bytes.NewReader(unknown)); data.ParserError == nil {
+		t.Error("expected error for unregistered version")
+	}
+	if msgs := parser.Parse(frame); len(msgs) != 1 || msgs[0].ParserError != nil {
+		t.Errorf("Parse failed: %+v", msgs)
+	}
+}
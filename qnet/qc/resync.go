@@ -0,0 +1,43 @@
+package qc
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// 判断buf是否以已注册版本的合法消息头开头
+func validHeader(buf []byte) bool {
+	if len(buf) < HeaderLength || !bytes.Equal(buf[:5], HeaderFlag[:]) {
+		return false
+	}
+	if _, err := getVersion(buf[5]); err != nil {
+		return false
+	}
+	var header QTPHeader
+	return ParseHeader(buf, buf[5], &header) == nil
+}
+
+// Resync 跳过reader中不属于合法消息头的数据，直至reader以合法的消息头开头，返回跳过的byte数
+// 仅校验各版本共用的前HeaderLength个byte，reader读取失败时返回对应的error
+func Resync(reader *bufio.Reader) (int, error) {
+	skipped := 0
+	for {
+		buf, err := reader.Peek(HeaderLength)
+		if err != nil {
+			return skipped, err
+		}
+		if validHeader(buf) {
+			return skipped, nil
+		}
+		// 跳至下一个消息头Flag处，未找到时保留末尾可能属于Flag的部分
+		discard := len(buf) - len(HeaderFlag) + 1
+		if pos := bytes.Index(buf[1:], HeaderFlag[:]); pos != -1 {
+			discard = pos + 1
+		}
+		n, err := reader.Discard(discard)
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+	}
+}
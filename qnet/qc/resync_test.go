@@ -0,0 +1,39 @@
+package qc_test
+
+import (
+	"QuantumUtils/qnet/qc"
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestResync(t *testing.T) {
+	encoder, err := qc.NewEncoder(qc.BaseVersion, fixedSeq(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, frame, err := encoder.Encode([]byte("data"), qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未注册的版本号与截断的Flag均视为非法数据
+	unknown := append([]byte(nil), frame...)
+	unknown[5] = 0xFF
+	garbage := append(append(append([]byte("xx"), unknown...), qc.HeaderFlag[:3]...), bytes.Repeat([]byte{0}, 40)...)
+	reader := bufio.NewReader(bytes.NewReader(append(garbage, frame...)))
+
+	skipped, rErr := qc.Resync(reader)
+	if rErr != nil {
+		t.Fatal(rErr)
+	}
+	if skipped != len(garbage) {
+		t.Errorf("expected to skip %d bytes, got %d", len(garbage), skipped)
+	}
+	if skipped, _ = qc.Resync(reader); skipped != 0 {
+		t.Errorf("aligned reader skipped %d bytes", skipped)
+	}
+
+	if _, rErr = qc.Resync(bufio.NewReader(bytes.NewReader(garbage))); rErr == nil {
+		t.Error("expected EOF when no header follows")
+	}
+}
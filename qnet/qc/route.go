@@ -0,0 +1,32 @@
+package qc
+
+import (
+	"QuantumUtils/errors"
+	"strings"
+)
+
+// 路由规则可以是完整的路由名称，也可以是以".*"结尾的前缀规则（如"user.*"匹配"user.login"与"user.profile.get"），单独的"*"匹配所有路由
+
+// CheckRoutePattern 校验路由规则，通配符只能以".*"的形式出现在末尾
+func CheckRoutePattern(pattern string) *errors.QError {
+	if pattern == "" {
+		return errors.New("路由规则不能为空")
+	}
+	wildcard := strings.Index(pattern, "*")
+	if wildcard >= 0 && (wildcard != len(pattern)-1 || (pattern != "*" && !strings.HasSuffix(pattern, ".*"))) {
+		return errors.New("非法的路由规则: " + pattern + "，通配符只能以\".*\"的形式出现在末尾")
+	}
+	return nil
+}
+
+// MatchRoute 判断路由规则是否匹配route，没有路由的消息只能被"*"匹配
+func MatchRoute(pattern string, route string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ".*"):
+		return route != "" && strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == route
+	}
+}
@@ -0,0 +1,48 @@
+package qc
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"time"
+)
+
+// Signature 获取消息签名的签名者ID与签名，不存在或格式错误时返回false
+func (e Extensions) Signature() (string, []byte, bool) {
+	value, ok := e.Get(ExtSignature)
+	if !ok || len(value) <= ed25519.SignatureSize {
+		return "", nil, false
+	}
+	split := len(value) - ed25519.SignatureSize
+	return string(value[:split]), value[split:], true
+}
+
+// SetSignature 设置消息签名，signer不能为空
+func (e *Extensions) SetSignature(signer string, signature []byte) {
+	value := make([]byte, 0, len(signer)+len(signature))
+	value = append(value, signer...)
+	value = append(value, signature...)
+	e.Set(ExtSignature, value)
+}
+
+// Signer 获取消息的签名者ID，未签名时返回false；签名需经过校验后签名者ID才可信
+func (d *QTPData) Signer() (string, bool) {
+	signer, _, ok := d.Header.Extensions.Signature()
+	return signer, ok
+}
+
+// SignedAt 获取签名时间与签名随机数，不存在或格式错误时返回false
+func (e Extensions) SignedAt() (time.Time, uint64, bool) {
+	value, ok := e.Get(ExtSignedAt)
+	if !ok || len(value) != 16 {
+		return time.Time{}, 0, false
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(value))), binary.BigEndian.Uint64(value[8:]), true
+}
+
+// SetSignedAt 设置签名时间与签名随机数
+func (e *Extensions) SetSignedAt(t time.Time, nonce uint64) {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, uint64(t.UnixMilli()))
+	binary.BigEndian.PutUint64(value[8:], nonce)
+	e.Set(ExtSignedAt, value)
+}
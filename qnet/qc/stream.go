@@ -0,0 +1,84 @@
+package qc
+
+import (
+	"bytes"
+)
+
+// QTPFrameParser 可按消息头中的长度解析单个消息的解析器
+type QTPFrameParser interface {
+	QTPParser
+	// ParseFrame 解析buf起始处的单个消息，返回消息与消息占用的byte长度，返回的消息引用buf中的数据
+	// 数据不足一个完整消息时返回nil，消息头解析失败时返回的长度为0
+	ParseFrame(buf []byte) (*QTPData, int)
+}
+
+// ParseFrames 按消息头中的长度依次解析buf中的完整消息，返回解析出的消息与已处理的byte长度
+// 消息头之前的非法数据以及消息头解析失败的Flag会被跳过，未处理的部分为末尾不完整的消息
+func ParseFrames(buf []byte, parseFrame func(buf []byte) (*QTPData, int)) ([]*QTPData, int) {
+	var msgSlice []*QTPData
+	offset := 0
+	for {
+		pos := bytes.Index(buf[offset:], HeaderFlag[:])
+		if pos == -1 {
+			// 保留末尾可能属于Flag的部分
+			if tail := len(buf) - len(HeaderFlag) + 1; tail > offset {
+				offset = tail
+			}
+			return msgSlice, offset
+		}
+		offset += pos
+		msg, n := parseFrame(buf[offset:])
+		if msg == nil {
+			return msgSlice, offset
+		}
+		msgSlice = append(msgSlice, msg)
+		if n == 0 {
+			// 消息头解析失败，跳过该Flag继续搜索
+			n = len(HeaderFlag)
+		}
+		offset += n
+	}
+}
+
+// StreamDecoder 增量解析数据流，数据可按任意长度分块写入，末尾不完整的消息会保留至下一次写入
+// 对同一数据流，无论如何分块，解析结果都与一次性调用Parse相同
+type StreamDecoder struct {
+	parser *VersionedParser
+	buf    []byte
+}
+
+// NewStreamDecoder 新建一个根据版本号选择解析器的增量解析器，单个消息的长度限制为DefaultMaxFrameSize
+func NewStreamDecoder() *StreamDecoder {
+	return NewStreamDecoderWithOptions(ParserOptions{MaxFrameSize: DefaultMaxFrameSize})
+}
+
+// NewStreamDecoderWithOptions 以opts新建增量解析器
+// 消息头声明的长度超过opts.MaxFrameSize时不再等待后续数据，Feed返回FrameSizeError类型的解析失败消息并跳过该消息头
+// opts.MaxFrameSize不大于0时不限制，此时声明了超长长度的消息头会使缓冲无限增长，只应用于可信的数据
+func NewStreamDecoderWithOptions(opts ParserOptions) *StreamDecoder {
+	return &StreamDecoder{parser: NewVersionedParser(opts)}
+}
+
+// Feed 写入一块数据并返回其中已完整的消息
+// 返回的消息引用内部缓冲，但已返回的数据不会被之后的写入覆盖
+func (d *StreamDecoder) Feed(chunk []byte) []*QTPData {
+	d.buf = append(d.buf, chunk...)
+	msgSlice, n := ParseFrames(d.buf, d.parser.ParseFrame)
+	if n == len(d.buf) {
+		// 全部处理完毕时丢弃缓冲，避免之后的写入覆盖已返回的数据
+		d.buf = nil
+	} else {
+		d.buf = d.buf[n:]
+	}
+	return msgSlice
+}
+
+// Buffered 获取等待后续数据的byte长度
+func (d *StreamDecoder) Buffered() int {
+	return len(d.buf)
+}
+
+// Reset 丢弃所有等待后续数据的内容
+func (d *StreamDecoder) Reset() {
+	d.buf = nil
+}
@@ -0,0 +1,163 @@
+package qc_test
+
+import (
+	"QuantumUtils/qnet/qc"
+	// 注册v2版本协议
+	_ "QuantumUtils/qnet/qc/v2"
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"testing/quick"
+)
+
+type testFrame struct {
+	version  uint8
+	checksum bool
+	route    string
+	payload  []byte
+}
+
+// 将消息依次包装为一段数据流
+func encodeFrames(t testing.TB, frames []testFrame) []byte {
+	var stream []byte
+	for _, frame := range frames {
+		encoder, err := qc.NewEncoder(frame.version, fixedSeq(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK, Checksum: frame.checksum}
+		if frame.route != "" {
+			conf.Extensions.SetRoute(frame.route)
+		}
+		_, stream, err = encoder.AppendEncode(stream, frame.payload, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return stream
+}
+
+// 将stream按splits切分后依次写入StreamDecoder
+func feedChunks(decoder *qc.StreamDecoder, stream []byte, splits []byte) []*qc.QTPData {
+	var msgs []*qc.QTPData
+	for i := 0; len(stream) > 0; i++ {
+		n := len(stream)
+		if len(splits) > 0 {
+			n = int(splits[i%len(splits)])%len(stream) + 1
+		}
+		msgs = append(msgs, decoder.Feed(stream[:n])...)
+		stream = stream[n:]
+	}
+	return msgs
+}
+
+func sameMessages(a, b []*qc.QTPData) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i].ParserError == nil) != (b[i].ParserError == nil) ||
+			a[i].Header.Version != b[i].Header.Version ||
+			a[i].Route() != b[i].Route() ||
+			!bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
+
+// 任意分块方式写入的结果都与原始消息一致
+func TestStreamDecoderChunking(t *testing.T) {
+	property := func(payloads [][]byte, splits []byte, seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		frames := make([]testFrame, len(payloads))
+		for i, payload := range payloads {
+			// 随机在数据中插入消息头Flag
+			if rnd.Intn(3) == 0 {
+				payload = append(append(payload, qc.HeaderFlag[:]...), byte(rnd.Intn(3)))
+			}
+			frames[i] = testFrame{version: uint8(rnd.Intn(2) + 1), checksum: rnd.Intn(2) == 0, payload: payload}
+			if frames[i].version == 2 {
+				frames[i].route = "route"
+			}
+		}
+		decoder := qc.NewStreamDecoder()
+		msgs := feedChunks(decoder, encodeFrames(t, frames), splits)
+		if len(msgs) != len(frames) || decoder.Buffered() != 0 {
+			return false
+		}
+		for i, msg := range msgs {
+			if msg.ParserError != nil || msg.Header.Version != frames[i].version ||
+				msg.Route() != frames[i].route || !bytes.Equal(msg.Data, frames[i].payload) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamDecoderPartial(t *testing.T) {
+	stream := encodeFrames(t, []testFrame{{version: 1, payload: []byte("first")}, {version: 2, route: "r", payload: []byte("second")}})
+	decoder := qc.NewStreamDecoder()
+	msgs := decoder.Feed(stream[:len(stream)-1])
+	if len(msgs) != 1 || string(msgs[0].Data) != "first" {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	first := msgs[0].Data
+	if decoder.Buffered() == 0 {
+		t.Fatal("partial frame was dropped")
+	}
+	msgs = decoder.Feed(stream[len(stream)-1:])
+	if len(msgs) != 1 || string(msgs[0].Data) != "second" || decoder.Buffered() != 0 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+	// 之后的写入不会覆盖已返回的数据
+	decoder.Feed(stream)
+	if string(first) != "first" {
+		t.Errorf("returned data was overwritten: %q", first)
+	}
+}
+
+// 消息头声明的长度超过限制时立即返回异常，不会等待并缓冲后续数据
+func TestStreamDecoderFrameSize(t *testing.T) {
+	frames := encodeFrames(t, []testFrame{{version: 1, payload: make([]byte, 64)}, {version: 2, route: "r", payload: []byte("ok")}})
+	first := len(encodeFrames(t, []testFrame{{version: 1, payload: make([]byte, 64)}}))
+	// 将第一个消息消息头中声明的数据长度改为约4GB
+	oversized := append([]byte(nil), frames[:first]...)
+	binary.LittleEndian.PutUint32(oversized[17:21], 0xFFFFFFF0)
+	for _, decoder := range []*qc.StreamDecoder{qc.NewStreamDecoder(), qc.NewStreamDecoderWithOptions(qc.ParserOptions{MaxFrameSize: 32})} {
+		msgs := decoder.Feed(oversized[:qc.HeaderLength])
+		if len(msgs) != 1 || !msgs[0].ParserError.IsKind(qc.FrameSizeError) {
+			t.Fatalf("expected FrameSizeError, got %v", msgs)
+		}
+		if decoder.Buffered() >= qc.HeaderLength {
+			t.Errorf("oversized header still buffered: %d bytes", decoder.Buffered())
+		}
+		decoder.Reset()
+	}
+	// 限制以内的消息不受影响
+	msgs := qc.NewStreamDecoderWithOptions(qc.ParserOptions{MaxFrameSize: 64}).Feed(frames)
+	if len(msgs) != 2 || msgs[0].ParserError != nil || len(msgs[0].Data) != 64 || string(msgs[1].Data) != "ok" {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+}
+
+func FuzzStreamDecoder(f *testing.F) {
+	f.Add(encodeFrames(f, []testFrame{{version: 1, payload: []byte("data")}}), []byte{3})
+	f.Add(encodeFrames(f, []testFrame{
+		{version: 1, checksum: true, payload: qc.HeaderFlag[:]},
+		{version: 2, route: "route", payload: []byte("data")},
+	}), []byte{1, 7, 30})
+	f.Add(append([]byte("garbagexQms"), encodeFrames(f, []testFrame{{version: 2, checksum: true}})...), []byte{10})
+	f.Fuzz(func(t *testing.T, stream []byte, splits []byte) {
+		expected := qc.NewVersionedParser(qc.ParserOptions{MaxFrameSize: qc.DefaultMaxFrameSize}).Parse(stream)
+		msgs := feedChunks(qc.NewStreamDecoder(), stream, splits)
+		if !sameMessages(expected, msgs) {
+			t.Errorf("chunked decoding differs from Parse: %d vs %d messages", len(msgs), len(expected))
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"QuantumUtils/errors"
 	"QuantumUtils/qnet/qc"
 	"encoding/binary"
+	"io"
 	"math"
 )
 
@@ -19,35 +20,80 @@ func NewQMsgEncoder(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder {
 	}
 }
 
-func (e *QTPBaseEncoder) Encode(buf []byte, config qc.QTPConfig) (uint64, []byte, *errors.QError) {
-	dataLength := len(buf)
+// 校验数据长度并生成序列号
+func (e *QTPBaseEncoder) nextSeq(dataLength int, config qc.QTPConfig) (uint64, *errors.QError) {
 	if dataLength > math.MaxUint32 || dataLength < 0 {
-		return 0, nil, errors.New("QMsg协议包装失败，数据长度超过了QMsg协议最大支持的4GB")
+		return 0, errors.New("QMsg协议包装失败，数据长度超过了QMsg协议最大支持的4GB")
+	}
+	if len(config.Extensions) > 0 {
+		return 0, errors.New("QMsg协议包装失败，v1版本协议不支持消息头扩展")
 	}
+	seqG := *e.seqGenerator
+	return seqG.NextSeq(), nil
+}
 
-	var dataLengthBuf [4]byte
-	binary.LittleEndian.PutUint32(dataLengthBuf[:], uint32(dataLength))
+// 将消息头写入headerBuf，headerBuf长度需不小于HeaderLength
+func (e *QTPBaseEncoder) putHeader(headerBuf []byte, seqN uint64, dataLength int, config qc.QTPConfig) {
+	_ = headerBuf[qc.HeaderLength-1]
+	copy(headerBuf[:5], qc.HeaderFlag[:])                                           // 消息头Flag
+	headerBuf[5] = e.version                                                        // 协议版本
+	binary.LittleEndian.PutUint64(headerBuf[6:14], seqN)                            // 消息序列号
+	headerBuf[14] = byte(config.Encode)                                             // 数据编码类型
+	headerBuf[15] = byte(config.MsgType)                                            // 消息类型
+	headerBuf[16] = byte(config.ACKType)                                            // ACK机制
+	binary.LittleEndian.PutUint32(headerBuf[17:21], uint32(dataLength))             // 消息数据长度
+	binary.LittleEndian.PutUint16(headerBuf[21:qc.HeaderLength], config.Reserved()) // 两个预留字节
+}
 
-	var seqBuf [8]byte
-	seqG := *e.seqGenerator
-	seqN := seqG.NextSeq()
-	binary.LittleEndian.PutUint64(seqBuf[:], seqN)
-
-	headerBuf := []byte{
-		qc.HeaderFlag[0], qc.HeaderFlag[1], qc.HeaderFlag[2], qc.HeaderFlag[3], qc.HeaderFlag[4], // 消息头Flag
-		e.version,                                                                              // 协议版本
-		seqBuf[0], seqBuf[1], seqBuf[2], seqBuf[3], seqBuf[4], seqBuf[5], seqBuf[6], seqBuf[7], // 消息序列号
-		byte(config.Encode),                                                    // 数据编码类型
-		byte(config.MsgType),                                                   // 消息类型
-		byte(config.ACKType),                                                   // ACK机制
-		dataLengthBuf[0], dataLengthBuf[1], dataLengthBuf[2], dataLengthBuf[3], // 消息数据长度
-		0x00, 0x00, // 两个预留字节
-	}
-
-	// 把headerBuf插入到buf头部
-	result := make([]byte, qc.HeaderLength+dataLength)
-	copy(result, headerBuf)
-	copy(result[qc.HeaderLength:], buf)
-	return seqN, result, nil
+func (e *QTPBaseEncoder) Encode(buf []byte, config qc.QTPConfig) (uint64, []byte, *errors.QError) {
+	return e.AppendEncode(make([]byte, 0, qc.HeaderLength+len(buf)+qc.ChecksumLength), buf, config)
+}
 
+func (e *QTPBaseEncoder) AppendEncode(dst []byte, buf []byte, config qc.QTPConfig) (uint64, []byte, *errors.QError) {
+	seqN, err := e.nextSeq(len(buf), config)
+	if err != nil {
+		return 0, dst, err
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, qc.HeaderLength)...)
+	e.putHeader(dst[start:], seqN, len(buf), config)
+	dst = append(dst, buf...)
+	if config.Checksum {
+		dst = binary.LittleEndian.AppendUint32(dst, qc.Checksum(dst[start:]))
+	}
+	return seqN, dst, nil
+}
+
+func (e *QTPBaseEncoder) EncodeTo(writer io.Writer, buf []byte, config qc.QTPConfig) (uint64, int, *errors.QError) {
+	seqN, err := e.nextSeq(len(buf), config)
+	if err != nil {
+		return 0, 0, err
+	}
+	headerBuf := qc.GetBuffer(qc.HeaderLength)
+	defer qc.PutBuffer(headerBuf)
+	e.putHeader(*headerBuf, seqN, len(buf), config)
+
+	// 消息头与数据分两次写入，建议writer使用bufio.Writer等带缓冲的实现
+	crc := qc.Checksum(*headerBuf)
+	written, wErr := writer.Write(*headerBuf)
+	if wErr != nil {
+		return seqN, written, errors.New(wErr.Error())
+	}
+	if len(buf) > 0 {
+		n, wErr := writer.Write(buf)
+		written += n
+		if wErr != nil {
+			return seqN, written, errors.New(wErr.Error())
+		}
+	}
+	if config.Checksum {
+		checksum := (*headerBuf)[:qc.ChecksumLength]
+		binary.LittleEndian.PutUint32(checksum, qc.UpdateChecksum(crc, buf))
+		n, wErr := writer.Write(checksum)
+		written += n
+		if wErr != nil {
+			return seqN, written, errors.New(wErr.Error())
+		}
+	}
+	return seqN, written, nil
 }
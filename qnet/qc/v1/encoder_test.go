@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"QuantumUtils/qnet/qc"
+	"io"
+	"testing"
+)
+
+// 固定序列号生成器
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+var benchConf = qc.QTPConfig{
+	Encode:  qc.BINARY,
+	MsgType: qc.DATA,
+	ACKType: qc.AsyncACK,
+}
+
+func BenchmarkEncode(b *testing.B) {
+	encoder := NewQMsgEncoder(fixedSeq(1))
+	payload := make([]byte, 256)
+	b.ReportAllocs()
+	b.SetBytes(int64(qc.HeaderLength + len(payload)))
+	for i := 0; i < b.N; i++ {
+		_, _, _ = encoder.Encode(payload, benchConf)
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	encoder := NewQMsgEncoder(fixedSeq(1))
+	payload := make([]byte, 256)
+	dst := make([]byte, 0, qc.HeaderLength+len(payload))
+	b.ReportAllocs()
+	b.SetBytes(int64(qc.HeaderLength + len(payload)))
+	for i := 0; i < b.N; i++ {
+		_, dst, _ = encoder.AppendEncode(dst[:0], payload, benchConf)
+	}
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	encoder := NewQMsgEncoder(fixedSeq(1))
+	payload := make([]byte, 256)
+	b.ReportAllocs()
+	b.SetBytes(int64(qc.HeaderLength + len(payload)))
+	for i := 0; i < b.N; i++ {
+		_, _, _ = encoder.EncodeTo(io.Discard, payload, benchConf)
+	}
+}
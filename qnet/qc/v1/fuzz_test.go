@@ -0,0 +1,114 @@
+package v1
+
+import (
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qc/conformance"
+	"bytes"
+	"testing"
+)
+
+const fuzzMaxFrameSize = 1 << 20
+
+// 使用一致性测试向量作为种子语料
+func addVectors(f *testing.F) {
+	vectors, err := conformance.Vectors()
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, v := range vectors {
+		if v.Version != qc.BaseVersion {
+			continue
+		}
+		frame, err := conformance.Frame(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(frame)
+	}
+}
+
+// 根据解析出的消息头还原包装配置
+func headerConfig(header qc.QTPHeader) qc.QTPConfig {
+	return qc.QTPConfig{
+		Encode:   header.Encode,
+		MsgType:  header.MsgType,
+		ACKType:  header.ACKType,
+		Compress: header.CompressID(),
+		Checksum: header.HasChecksum(),
+	}
+}
+
+func FuzzParseReader(f *testing.F) {
+	addVectors(f)
+	f.Fuzz(func(t *testing.T, frame []byte) {
+		// 限制消息长度，避免按消息头中的数据长度分配过多内存
+		reader := bytes.NewReader(frame)
+		data := QTPBaseParser{Version: qc.BaseVersion, MaxFrameSize: fuzzMaxFrameSize}.ParseReader(reader)
+		pooled := QTPBaseParser{Version: qc.BaseVersion, Pooled: true, MaxFrameSize: fuzzMaxFrameSize}.ParseReader(bytes.NewReader(frame))
+		defer pooled.Release()
+		if (data.ParserError == nil) != (pooled.ParserError == nil) {
+			t.Fatal("pooled parser differs from unpooled parser")
+		}
+		if data.ParserError != nil {
+			return
+		}
+		if !bytes.Equal(data.Data, pooled.Data) {
+			t.Fatal("pooled parser differs from unpooled parser")
+		}
+		consumed := frame[:len(frame)-reader.Len()]
+		conf := headerConfig(data.Header)
+		// 其余预留位无法通过配置还原
+		if conf.Reserved() != data.Header.Reserved {
+			return
+		}
+		_, encoded, err := NewQMsgEncoder(fixedSeq(data.Header.Seq)).Encode(data.Data, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, consumed) {
+			t.Fatalf("re-encoded frame differs:\n got %x\nwant %x", encoded, consumed)
+		}
+	})
+}
+
+func FuzzParse(f *testing.F) {
+	addVectors(f)
+	f.Fuzz(func(t *testing.T, buf []byte) {
+		for _, msg := range NewQMsgParser().Parse(buf) {
+			if msg.ParserError == nil && int(msg.Header.DataLength) != len(msg.Data) {
+				t.Fatalf("DataLength %d but got %d bytes", msg.Header.DataLength, len(msg.Data))
+			}
+		}
+	})
+}
+
+func FuzzEncode(f *testing.F) {
+	f.Add([]byte("data"), uint64(1), uint8(qc.BINARY), uint8(qc.DATA), uint8(qc.NoACK), false)
+	f.Add(qc.HeaderFlag[:], uint64(1<<63), uint8(qc.JSON), uint8(qc.RETRY), uint8(qc.AsyncACK), true)
+	f.Add([]byte{}, uint64(0), uint8(qc.BINARY), uint8(qc.ACK), uint8(qc.SyncACK), true)
+	f.Fuzz(func(t *testing.T, payload []byte, seqN uint64, encode, msgType, ackType uint8, checksum bool) {
+		conf := qc.QTPConfig{
+			Encode:   qc.Encode(encode % 2),
+			MsgType:  qc.MsgType(msgType % 3),
+			ACKType:  qc.ACKType(ackType % 3),
+			Checksum: checksum,
+		}
+		_, frame, err := NewQMsgEncoder(fixedSeq(seqN)).Encode(payload, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := NewQMsgParser().ParseReader(bytes.NewReader(frame))
+		if data.ParserError != nil {
+			t.Fatal(data.ParserError)
+		}
+		if data.Header.Seq != seqN || headerConfig(data.Header).Reserved() != conf.Reserved() ||
+			data.Header.Encode != conf.Encode || data.Header.MsgType != conf.MsgType || data.Header.ACKType != conf.ACKType ||
+			!bytes.Equal(data.Data, payload) {
+			t.Fatalf("round trip mismatch: %+v", data.Header)
+		}
+		msgs := NewQMsgParser().Parse(frame)
+		if len(msgs) != 1 || msgs[0].ParserError != nil || !bytes.Equal(msgs[0].Data, payload) {
+			t.Fatalf("Parse returned %d messages", len(msgs))
+		}
+	})
+}
@@ -3,217 +3,133 @@ package v1
 import (
 	"QuantumUtils/errors"
 	"QuantumUtils/qnet/qc"
-	"bytes"
-	"encoding/binary"
 	"io"
 )
 
 type QTPBaseParser struct {
 	Version uint8
+	// 是否使用缓冲池，开启后ParseReader返回的QTPData需在使用完毕后调用Release归还
+	Pooled bool
+	// 单个消息数据的最大byte长度，不大于0时不限制
+	MaxFrameSize int
 }
 
-// 解析单个消息
-func (p QTPBaseParser) parseMsg(buf []byte) *qc.QTPData {
-
-	msg := qc.QTPData{
-		Data: buf[qc.HeaderLength:],
+// ParseFrame 解析buf起始处的单个消息，返回消息与消息占用的byte长度
+// 数据不足一个完整消息时返回nil，消息头解析失败时返回的长度为0
+func (p QTPBaseParser) ParseFrame(buf []byte) (*qc.QTPData, int) {
+	if len(buf) < qc.HeaderLength {
+		return nil, 0
 	}
-
-	header, err := p.parseHeader(buf)
+	msg := qc.QTPData{}
+	var header qc.QTPHeader
+	err := p.parseHeader(buf, &header)
 	if err != nil {
 		msg.ParserError = err
-		return &msg
+		return &msg, 0
 	}
 
-	msg.Data = buf[qc.HeaderLength : qc.HeaderLength+header.DataLength]
-	msg.ParserError = nil
-	msg.Header = *header
-	return &msg
-
-}
-
-// 解析消息头
-func (p QTPBaseParser) parseHeader(buf []byte) (*qc.QTPHeader, *errors.QError) {
-	header := qc.QTPHeader{
-		HeaderFlag: qc.HeaderFlag,
-		Version:    p.Version,
-	}
-	if len(buf) < qc.HeaderLength {
-		err := errors.New("解析失败，byte切片长度不足，非QMsg协议类型")
-		return nil, err
-	}
-
-	if !bytes.Equal(buf[:5], qc.HeaderFlag[:]) {
-		err := errors.New("解析失败，协议Flag解析失败，非QMsg协议类型")
-		return nil, err
+	if err = qc.CheckFrameSize(int(header.DataLength), p.MaxFrameSize); err != nil {
+		msg.ParserError = err
+		return &msg, 0
 	}
-
-	if buf[5] != p.Version {
-		err := errors.New("解析失败，协议版本与解析器版本不符合")
-		return nil, err
+	end := qc.HeaderLength + int(header.DataLength)
+	frameEnd := end
+	if header.HasChecksum() {
+		frameEnd += qc.ChecksumLength
 	}
-
-	header.Seq = binary.LittleEndian.Uint64(buf[6:14])
-
-	switch buf[14] {
-	case byte(qc.JSON):
-		{
-			header.Encode = qc.JSON
-			break
-		}
-	case byte(qc.BINARY):
-		{
-			header.Encode = qc.BINARY
-			break
-		}
-	default:
-		{
-			err := errors.New("解析失败，数据编码类型解析失败")
-			return nil, err
-		}
-
+	if len(buf) < frameEnd {
+		return nil, 0
 	}
-
-	switch buf[15] {
-	case byte(qc.ACK):
-		{
-			header.MsgType = qc.ACK
-			break
-		}
-	case byte(qc.DATA):
-		{
-			header.MsgType = qc.DATA
-			break
-		}
-	case byte(qc.RETRY):
-		{
-			header.MsgType = qc.RETRY
-			break
-		}
-	default:
-		{
-			err := errors.New("解析失败，消息类型解析失败")
-			return nil, err
-		}
-
+	msg.Header = header
+	msg.Data = buf[qc.HeaderLength:end:end]
+	if header.HasChecksum() {
+		msg.ParserError = qc.VerifyChecksum(qc.Checksum(buf[:end]), buf[end:frameEnd])
 	}
-
-	switch buf[16] {
-	case byte(qc.NoACK):
-		{
-			header.ACKType = qc.NoACK
-			break
-		}
-	case byte(qc.SyncACK):
-		{
-			header.ACKType = qc.SyncACK
-			break
-		}
-	case byte(qc.AsyncACK):
-		{
-			header.ACKType = qc.AsyncACK
-			break
-		}
-	default:
-		{
-			err := errors.New("解析失败，ACK机制解析失败")
-			return nil, err
-		}
-
-	}
-
-	header.DataLength = binary.LittleEndian.Uint32(buf[17:21])
-	header.Reserved = binary.LittleEndian.Uint16(buf[21:qc.HeaderLength])
-	return &header, nil
+	return &msg, frameEnd
 }
 
-// 分割数据包
-func (p QTPBaseParser) splitPackets(buf []byte) [][]byte {
-	var packets [][]byte
-
-	// 搜索消息头的位置
-	headerPos := bytes.Index(buf, qc.HeaderFlag[:])
-
-	for headerPos != -1 {
-
-		// 获取下一个消息头的位置
-		headerPosNext := bytes.Index(buf[headerPos+qc.HeaderLength:], qc.HeaderFlag[:])
-		if headerPosNext != -1 {
-			// 截取两个消息头之间的数据并加入结果
-			packets = append(packets, buf[headerPos:headerPosNext])
-			// 将buf更新为第二个消息头后的buf
-			buf = buf[headerPosNext:]
-			headerPos = headerPosNext
-		} else {
-			// buf只剩一个消息头，直接截取消息头之后的所有消息加入结果
-			packets = append(packets, buf[headerPos:])
-			break
-		}
-
-	}
-
-	return packets
+// 解析消息头至header
+func (p QTPBaseParser) parseHeader(buf []byte, header *qc.QTPHeader) *errors.QError {
+	return qc.ParseHeader(buf, p.Version, header)
 }
 
+// Parse 按消息头中的长度依次解析buf中的完整消息，末尾不完整的消息会被丢弃
 func (p QTPBaseParser) Parse(buf []byte) []*qc.QTPData {
-	packets := p.splitPackets(buf)
-	var msgSlice = make([]*qc.QTPData, len(packets))
-	for index, packet := range packets {
-		msg := p.parseMsg(packet)
-		msgSlice[index] = msg
-	}
+	msgSlice, _ := qc.ParseFrames(buf, p.ParseFrame)
 	return msgSlice
-
 }
 
-var lastRead *qc.QTPData = nil
-
 func (p QTPBaseParser) ParseReader(reader io.Reader) *qc.QTPData {
-	qtpData := qc.QTPData{}
-	defer func() { lastRead = &qtpData }()
-	headerBuf := make([]byte, qc.HeaderLength)
-	read, err := reader.Read(headerBuf)
-	if err != nil {
-		qtpData.ParserError = errors.New(err.Error())
-		return &qtpData
+	var qtpData *qc.QTPData
+	headerBuf := qc.GetBuffer(qc.HeaderLength)
+	defer qc.PutBuffer(headerBuf)
+	var header qc.QTPHeader
+	_, err := io.ReadFull(reader, *headerBuf)
+	if err == io.ErrUnexpectedEOF {
+		qtpData = &qc.QTPData{ParserError: errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")}
+		return qtpData
 	}
-
-	if read < qc.HeaderLength {
-
-		qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
-		return &qtpData
+	if err != nil {
+		qtpData = &qc.QTPData{ParserError: errors.New(err.Error())}
+		return qtpData
 	}
 
-	header, qErr := p.parseHeader(headerBuf)
+	qErr := p.parseHeader(*headerBuf, &header)
 	if qErr != nil {
 		qErr.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
-		qtpData.ParserError = qErr
-		return &qtpData
+		qtpData = &qc.QTPData{ParserError: qErr}
+		return qtpData
 	}
-	// 如果header中数据长度为0，则不需要继续在read数据，防止阻塞
-	if header.DataLength == 0 {
-		qtpData.Header = *header
-		qtpData.Data = make([]byte, 0)
-		return &qtpData
+	// 在分配内存前拒绝超过长度限制的消息
+	if qErr = qc.CheckFrameSize(int(header.DataLength), p.MaxFrameSize); qErr != nil {
+		qtpData = &qc.QTPData{Header: header, ParserError: qErr}
+		return qtpData
 	}
 
-	dataBuf := make([]byte, header.DataLength)
-	read, err = reader.Read(dataBuf)
-
-	if err != nil {
-		qtpData.ParserError = errors.New(err.Error())
-		return &qtpData
+	if p.Pooled {
+		qtpData = qc.AcquireData(int(header.DataLength))
+	} else {
+		qtpData = &qc.QTPData{Data: make([]byte, header.DataLength)}
+	}
+	qtpData.Header = header
+	// 如果header中数据长度为0，则不需要继续在read数据，防止阻塞
+	if header.DataLength > 0 {
+		_, err = io.ReadFull(reader, qtpData.Data)
+		if err == io.ErrUnexpectedEOF {
+			qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+			return qtpData
+		}
+		if err != nil {
+			qtpData.ParserError = errors.New(err.Error())
+			return qtpData
+		}
 	}
-	if uint32(read) != header.DataLength {
-		qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
-		return &qtpData
+
+	if header.HasChecksum() {
+		crc := qc.UpdateChecksum(qc.Checksum(*headerBuf), qtpData.Data)
+		checksum := (*headerBuf)[:qc.ChecksumLength]
+		_, err = io.ReadFull(reader, checksum)
+		if err != nil {
+			qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+			return qtpData
+		}
+		qtpData.ParserError = qc.VerifyChecksum(crc, checksum)
 	}
-	qtpData.Header = *header
-	qtpData.Data = dataBuf
-	return &qtpData
+	return qtpData
 
 }
 
+func init() {
+	qc.RegisterVersion(qc.BaseVersion, func(opts qc.ParserOptions) qc.QTPParser {
+		return QTPBaseParser{Version: qc.BaseVersion, Pooled: opts.Pooled, MaxFrameSize: opts.MaxFrameSize}
+	}, NewQMsgEncoder)
+}
+
 func NewQMsgParser() qc.QTPParser {
 	return QTPBaseParser{Version: 1}
 }
+
+// NewPooledQMsgParser 新建使用缓冲池的解析器，ParseReader返回的QTPData需在使用完毕后调用Release归还
+func NewPooledQMsgParser() qc.QTPParser {
+	return QTPBaseParser{Version: 1, Pooled: true}
+}
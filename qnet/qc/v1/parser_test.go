@@ -0,0 +1,133 @@
+package v1
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func benchmarkParseReader(b *testing.B, parser qc.QTPParser) {
+	_, frame, _ := NewQMsgEncoder(fixedSeq(1)).Encode(make([]byte, 256), benchConf)
+	reader := bytes.NewReader(frame)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	for i := 0; i < b.N; i++ {
+		reader.Reset(frame)
+		data := parser.ParseReader(reader)
+		if data.ParserError != nil {
+			b.Fatal(data.ParserError)
+		}
+		data.Release()
+	}
+}
+
+func BenchmarkParseReader(b *testing.B) {
+	benchmarkParseReader(b, NewQMsgParser())
+}
+
+func BenchmarkParseReaderPooled(b *testing.B) {
+	benchmarkParseReader(b, NewPooledQMsgParser())
+}
+
+func TestParseReaderChecksum(t *testing.T) {
+	conf := benchConf
+	conf.Checksum = true
+	encoder := NewQMsgEncoder(fixedSeq(7))
+	_, frame, err := encoder.Encode([]byte("checksum payload"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := encoder.EncodeTo(&buf, []byte("checksum payload"), conf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), frame) {
+		t.Fatal("EncodeTo and Encode produced different frames")
+	}
+
+	data := NewQMsgParser().ParseReader(bytes.NewReader(frame))
+	if data.ParserError != nil {
+		t.Fatal(data.ParserError)
+	}
+	if !data.Header.HasChecksum() || string(data.Data) != "checksum payload" {
+		t.Fatalf("unexpected frame %+v", data)
+	}
+
+	// 重发时修改消息类型后校验和依然有效
+	retry := append([]byte(nil), frame...)
+	qc.SetMsgType(retry, qc.RETRY)
+	data = NewQMsgParser().ParseReader(bytes.NewReader(retry))
+	if data.ParserError != nil || data.Header.MsgType != qc.RETRY {
+		t.Fatalf("retry frame rejected: %v", data.ParserError)
+	}
+
+	for _, pos := range []int{8, qc.HeaderLength + 3, len(frame) - 1} {
+		corrupt := append([]byte(nil), frame...)
+		corrupt[pos] ^= 0xFF
+		data = NewQMsgParser().ParseReader(bytes.NewReader(corrupt))
+		if !data.ParserError.IsKind(qc.ChecksumError) {
+			t.Errorf("corruption at %d: expected checksum error, got %v", pos, data.ParserError)
+		}
+		msgs := NewQMsgParser().Parse(corrupt)
+		if len(msgs) != 1 || !msgs[0].ParserError.IsKind(qc.ChecksumError) {
+			t.Errorf("Parse corruption at %d: expected checksum error", pos)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	encoder := NewQMsgEncoder(fixedSeq(1))
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+	// 数据中包含消息头Flag时依然按长度切分
+	payloads := [][]byte{[]byte("first"), append(qc.HeaderFlag[:], "inner"...), {}, []byte("last")}
+	stream := []byte("garbage")
+	for i, payload := range payloads {
+		conf.Checksum = i%2 == 0
+		var err *errors.QError
+		_, stream, err = encoder.AppendEncode(stream, payload, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, partial, _ := encoder.Encode([]byte("partial"), conf)
+	stream = append(stream, partial[:len(partial)-1]...)
+
+	msgs := NewQMsgParser().Parse(stream)
+	if len(msgs) != len(payloads) {
+		t.Fatalf("expected %d messages, got %d", len(payloads), len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.ParserError != nil || !bytes.Equal(msg.Data, payloads[i]) {
+			t.Errorf("message %d: %q %v", i, msg.Data, msg.ParserError)
+		}
+	}
+}
+
+// 同一个解析器可被多个连接并发使用
+func TestParserConcurrent(t *testing.T) {
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK, Checksum: true}
+	parsers := []qc.QTPParser{NewQMsgParser(), NewPooledQMsgParser()}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := bytes.Repeat([]byte{byte(i)}, 64+i)
+			_, frame, _ := NewQMsgEncoder(fixedSeq(i)).Encode(payload, conf)
+			parser := parsers[i%len(parsers)]
+			for j := 0; j < 100; j++ {
+				data := parser.ParseReader(bytes.NewReader(frame))
+				if data.ParserError != nil || data.Header.Seq != uint64(i) || !bytes.Equal(data.Data, payload) {
+					t.Errorf("goroutine %d: unexpected frame %+v", i, data.Header)
+				}
+				data.Release()
+				if msgs := parser.Parse(frame); len(msgs) != 1 || !bytes.Equal(msgs[0].Data, payload) {
+					t.Errorf("goroutine %d: Parse returned %d messages", i, len(msgs))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
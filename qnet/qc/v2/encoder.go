@@ -0,0 +1,113 @@
+package v2
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// QTPExtEncoder v2版本协议包装器，消息头之后附带扩展区
+type QTPExtEncoder struct {
+	seqGenerator qc.QTPSeqGenerator
+}
+
+func NewQMsgEncoder(seqGenerator qc.QTPSeqGenerator) qc.QTPEncoder {
+	return &QTPExtEncoder{
+		seqGenerator: seqGenerator,
+	}
+}
+
+// 校验数据与扩展长度并生成序列号
+func (e *QTPExtEncoder) nextSeq(dataLength int, config qc.QTPConfig) (uint64, *errors.QError) {
+	if dataLength > math.MaxUint32 || dataLength < 0 {
+		return 0, errors.New("QMsg协议包装失败，数据长度超过了QMsg协议最大支持的4GB")
+	}
+	for _, ext := range config.Extensions {
+		if len(ext.Value) > qc.ExtMaxLength {
+			return 0, errors.New("QMsg协议包装失败，单个扩展长度超过了64KB")
+		}
+	}
+	if config.Extensions.Size() > qc.ExtMaxLength {
+		return 0, errors.New("QMsg协议包装失败，扩展区长度超过了64KB")
+	}
+	return e.seqGenerator.NextSeq(), nil
+}
+
+// 将消息头与扩展区写入headerBuf，headerBuf长度需为HeaderLength+扩展区长度
+func (e *QTPExtEncoder) putHeader(headerBuf []byte, seqN uint64, dataLength int, config qc.QTPConfig) {
+	_ = headerBuf[HeaderLength-1]
+	copy(headerBuf[:5], qc.HeaderFlag[:])                                           // 消息头Flag
+	headerBuf[5] = Version                                                          // 协议版本
+	binary.LittleEndian.PutUint64(headerBuf[6:14], seqN)                            // 消息序列号
+	headerBuf[14] = byte(config.Encode)                                             // 数据编码类型
+	headerBuf[15] = byte(config.MsgType)                                            // 消息类型
+	headerBuf[16] = byte(config.ACKType)                                            // ACK机制
+	binary.LittleEndian.PutUint32(headerBuf[17:21], uint32(dataLength))             // 消息数据长度
+	binary.LittleEndian.PutUint16(headerBuf[21:qc.HeaderLength], config.Reserved()) // 标志位
+	binary.LittleEndian.PutUint16(headerBuf[qc.HeaderLength:HeaderLength], uint16(config.Extensions.Size()))
+
+	// 扩展区
+	pos := HeaderLength
+	for _, ext := range config.Extensions {
+		headerBuf[pos] = byte(ext.Type)
+		binary.LittleEndian.PutUint16(headerBuf[pos+1:pos+3], uint16(len(ext.Value)))
+		pos += 3
+		pos += copy(headerBuf[pos:], ext.Value)
+	}
+}
+
+func (e *QTPExtEncoder) Encode(buf []byte, config qc.QTPConfig) (uint64, []byte, *errors.QError) {
+	size := HeaderLength + config.Extensions.Size() + len(buf) + qc.ChecksumLength
+	return e.AppendEncode(make([]byte, 0, size), buf, config)
+}
+
+func (e *QTPExtEncoder) AppendEncode(dst []byte, buf []byte, config qc.QTPConfig) (uint64, []byte, *errors.QError) {
+	seqN, err := e.nextSeq(len(buf), config)
+	if err != nil {
+		return 0, dst, err
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, HeaderLength+config.Extensions.Size())...)
+	e.putHeader(dst[start:], seqN, len(buf), config)
+	dst = append(dst, buf...)
+	if config.Checksum {
+		dst = binary.LittleEndian.AppendUint32(dst, qc.Checksum(dst[start:]))
+	}
+	return seqN, dst, nil
+}
+
+func (e *QTPExtEncoder) EncodeTo(writer io.Writer, buf []byte, config qc.QTPConfig) (uint64, int, *errors.QError) {
+	seqN, err := e.nextSeq(len(buf), config)
+	if err != nil {
+		return 0, 0, err
+	}
+	headerBuf := qc.GetBuffer(HeaderLength + config.Extensions.Size())
+	defer qc.PutBuffer(headerBuf)
+	e.putHeader(*headerBuf, seqN, len(buf), config)
+
+	// 消息头与数据分两次写入，建议writer使用bufio.Writer等带缓冲的实现
+	crc := qc.Checksum(*headerBuf)
+	written, wErr := writer.Write(*headerBuf)
+	if wErr != nil {
+		return seqN, written, errors.New(wErr.Error())
+	}
+	if len(buf) > 0 {
+		n, wErr := writer.Write(buf)
+		written += n
+		if wErr != nil {
+			return seqN, written, errors.New(wErr.Error())
+		}
+	}
+	if config.Checksum {
+		checksum := (*headerBuf)[:qc.ChecksumLength]
+		binary.LittleEndian.PutUint32(checksum, qc.UpdateChecksum(crc, buf))
+		n, wErr := writer.Write(checksum)
+		written += n
+		if wErr != nil {
+			return seqN, written, errors.New(wErr.Error())
+		}
+	}
+	return seqN, written, nil
+}
@@ -0,0 +1,178 @@
+package v2
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"encoding/binary"
+	"io"
+)
+
+// v2版本协议在v1消息头（23byte）之后追加2byte的扩展区长度，随后依次为扩展区、数据与可选的校验和
+// 扩展区中的每个扩展以Type(1byte)+Length(2byte)+Value排列
+
+// Version 协议版本号
+const Version uint8 = 2
+
+// HeaderLength v2消息头固定部分的byte长度，不包含扩展区
+const HeaderLength = qc.HeaderLength + 2
+
+func init() {
+	qc.RegisterVersion(Version, func(opts qc.ParserOptions) qc.QTPParser {
+		return QTPExtParser{Pooled: opts.Pooled, MaxFrameSize: opts.MaxFrameSize}
+	}, NewQMsgEncoder)
+}
+
+// QTPExtParser v2版本协议解析器
+type QTPExtParser struct {
+	// 是否使用缓冲池，开启后ParseReader返回的QTPData需在使用完毕后调用Release归还
+	Pooled bool
+	// 单个消息扩展区与数据的最大byte长度，不大于0时不限制
+	MaxFrameSize int
+}
+
+// 解析消息头固定部分，返回扩展区长度
+func (p QTPExtParser) parseHeader(buf []byte, header *qc.QTPHeader) (int, *errors.QError) {
+	if len(buf) < HeaderLength {
+		return 0, errors.NewKind(qc.FormatError, "解析失败，byte切片长度不足，非QMsg协议类型")
+	}
+	if err := qc.ParseHeader(buf, Version, header); err != nil {
+		return 0, err
+	}
+	return int(binary.LittleEndian.Uint16(buf[qc.HeaderLength:HeaderLength])), nil
+}
+
+// 解析扩展区，扩展值引用buf中的数据
+func parseExtensions(buf []byte) (qc.Extensions, *errors.QError) {
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	var extensions qc.Extensions
+	for len(buf) > 0 {
+		if len(buf) < 3 {
+			return nil, errors.NewKind(qc.FormatError, "解析失败，扩展区格式错误")
+		}
+		length := int(binary.LittleEndian.Uint16(buf[1:3]))
+		if len(buf) < 3+length {
+			return nil, errors.NewKind(qc.FormatError, "解析失败，扩展长度超出扩展区")
+		}
+		extensions = append(extensions, qc.Extension{
+			Type:  qc.ExtType(buf[0]),
+			Value: buf[3 : 3+length : 3+length],
+		})
+		buf = buf[3+length:]
+	}
+	return extensions, nil
+}
+
+// ParseFrame 解析buf起始处的单个消息，返回消息与消息占用的byte长度
+// 数据不足一个完整消息时返回nil，消息头解析失败时返回的长度为0
+func (p QTPExtParser) ParseFrame(buf []byte) (*qc.QTPData, int) {
+	if len(buf) < HeaderLength {
+		return nil, 0
+	}
+	msg := qc.QTPData{}
+	var header qc.QTPHeader
+	extLength, err := p.parseHeader(buf, &header)
+	if err != nil {
+		msg.ParserError = err
+		return &msg, 0
+	}
+	if err = qc.CheckFrameSize(extLength+int(header.DataLength), p.MaxFrameSize); err != nil {
+		msg.ParserError = err
+		return &msg, 0
+	}
+	bodyStart := HeaderLength + extLength
+	end := bodyStart + int(header.DataLength)
+	frameEnd := end
+	if header.HasChecksum() {
+		frameEnd += qc.ChecksumLength
+	}
+	if len(buf) < frameEnd {
+		return nil, 0
+	}
+	header.Extensions, err = parseExtensions(buf[HeaderLength:bodyStart])
+	if err != nil {
+		msg.ParserError = err
+		return &msg, frameEnd
+	}
+	msg.Header = header
+	msg.Data = buf[bodyStart:end:end]
+	if header.HasChecksum() {
+		msg.ParserError = qc.VerifyChecksum(qc.Checksum(buf[:end]), buf[end:frameEnd])
+	}
+	return &msg, frameEnd
+}
+
+// Parse 按消息头中的长度依次解析buf中的完整消息，末尾不完整的消息会被丢弃
+func (p QTPExtParser) Parse(buf []byte) []*qc.QTPData {
+	msgSlice, _ := qc.ParseFrames(buf, p.ParseFrame)
+	return msgSlice
+}
+
+func (p QTPExtParser) ParseReader(reader io.Reader) *qc.QTPData {
+	headerBuf := qc.GetBuffer(HeaderLength)
+	defer qc.PutBuffer(headerBuf)
+	var header qc.QTPHeader
+	_, err := io.ReadFull(reader, *headerBuf)
+	if err == io.ErrUnexpectedEOF {
+		return &qc.QTPData{ParserError: errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")}
+	}
+	if err != nil {
+		return &qc.QTPData{ParserError: errors.New(err.Error())}
+	}
+
+	extLength, qErr := p.parseHeader(*headerBuf, &header)
+	if qErr != nil {
+		qErr.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		return &qc.QTPData{ParserError: qErr}
+	}
+
+	// 扩展区与数据读入同一块缓冲，在分配内存前拒绝超过长度限制的消息
+	size := extLength + int(header.DataLength)
+	if qErr = qc.CheckFrameSize(size, p.MaxFrameSize); qErr != nil {
+		return &qc.QTPData{Header: header, ParserError: qErr}
+	}
+	var qtpData *qc.QTPData
+	if p.Pooled {
+		qtpData = qc.AcquireData(size)
+	} else {
+		qtpData = &qc.QTPData{Data: make([]byte, size)}
+	}
+	body := qtpData.Data
+	if size > 0 {
+		_, err = io.ReadFull(reader, body)
+		if err == io.ErrUnexpectedEOF {
+			qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+			return qtpData
+		}
+		if err != nil {
+			qtpData.ParserError = errors.New(err.Error())
+			return qtpData
+		}
+	}
+	header.Extensions, qErr = parseExtensions(body[:extLength])
+	if qErr != nil {
+		qErr.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		qtpData.ParserError = qErr
+		return qtpData
+	}
+	qtpData.Header = header
+	qtpData.Data = body[extLength:]
+
+	if header.HasChecksum() {
+		crc := qc.UpdateChecksum(qc.Checksum(*headerBuf), body)
+		checksum := (*headerBuf)[:qc.ChecksumLength]
+		_, err = io.ReadFull(reader, checksum)
+		if err != nil {
+			qtpData.ParserError = errors.New("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+			return qtpData
+		}
+		qtpData.ParserError = qc.VerifyChecksum(crc, checksum)
+	}
+	return qtpData
+}
+
+// NewQMsgParser 新建v2版本协议解析器
+func NewQMsgParser() qc.QTPParser {
+	return QTPExtParser{}
+}
@@ -0,0 +1,103 @@
+package v2
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"bytes"
+	"testing"
+	"time"
+)
+
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+func testConfig() qc.QTPConfig {
+	conf := qc.QTPConfig{Encode: qc.JSON, MsgType: qc.DATA, ACKType: qc.AsyncACK, Checksum: true}
+	conf.Extensions.SetTraceID("trace-1")
+	conf.Extensions.SetContentType("application/json")
+	conf.Extensions.SetTTL(1500 * time.Millisecond)
+	conf.Extensions.SetRoute("user.login")
+	conf.Extensions.Set(qc.ExtType(200), []byte{1, 2, 3})
+	return conf
+}
+
+func TestEncodeParseExtensions(t *testing.T) {
+	conf := testConfig()
+	payload := []byte(`{"user":"quantum"}`)
+	encoder := NewQMsgEncoder(fixedSeq(9))
+	_, frame, err := encoder.Encode(payload, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, _, err := encoder.EncodeTo(&buf, payload, conf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), frame) {
+		t.Fatal("EncodeTo and Encode produced different frames")
+	}
+
+	for _, parser := range []qc.QTPParser{QTPExtParser{}, QTPExtParser{Pooled: true}} {
+		data := parser.ParseReader(bytes.NewReader(frame))
+		if data.ParserError != nil {
+			t.Fatal(data.ParserError)
+		}
+		if data.Header.Version != Version || data.Header.Seq != 9 || !bytes.Equal(data.Data, payload) {
+			t.Fatalf("unexpected frame %+v", data.Header)
+		}
+		if data.TraceID() != "trace-1" || data.ContentType() != "application/json" || data.Route() != "user.login" {
+			t.Errorf("unexpected extensions %+v", data.Header.Extensions)
+		}
+		if ttl, ok := data.TTL(); !ok || ttl != 1500*time.Millisecond {
+			t.Errorf("unexpected ttl %v", ttl)
+		}
+		if v, ok := data.Extension(qc.ExtType(200)); !ok || !bytes.Equal(v, []byte{1, 2, 3}) {
+			t.Errorf("unknown extension not preserved: %v", v)
+		}
+		data.Release()
+	}
+
+	corrupt := append([]byte(nil), frame...)
+	corrupt[HeaderLength+4] ^= 0xFF
+	if data := NewQMsgParser().ParseReader(bytes.NewReader(corrupt)); !data.ParserError.IsKind(qc.ChecksumError) {
+		t.Errorf("expected checksum error, got %v", data.ParserError)
+	}
+}
+
+func TestParseFrames(t *testing.T) {
+	encoder := NewQMsgEncoder(fixedSeq(1))
+	conf := testConfig()
+	var stream []byte
+	// 数据中包含消息头Flag时依然按长度切分
+	payloads := [][]byte{[]byte("first"), qc.HeaderFlag[:], {}}
+	for _, payload := range payloads {
+		var err *errors.QError
+		_, stream, err = encoder.AppendEncode(stream, payload, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, partial, _ := encoder.Encode([]byte("partial"), conf)
+	stream = append(stream, partial[:len(partial)-3]...)
+
+	msgs := NewQMsgParser().Parse(stream)
+	if len(msgs) != len(payloads) {
+		t.Fatalf("expected %d messages, got %d", len(payloads), len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.ParserError != nil || !bytes.Equal(msg.Data, payloads[i]) || msg.Route() != "user.login" {
+			t.Errorf("message %d: %+v", i, msg)
+		}
+	}
+}
+
+func TestExtensionLimits(t *testing.T) {
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+	conf.Extensions.Set(qc.ExtTraceID, make([]byte, qc.ExtMaxLength+1))
+	if _, _, err := NewQMsgEncoder(fixedSeq(1)).Encode(nil, conf); err == nil {
+		t.Error("expected error for oversized extension")
+	}
+}
@@ -3,9 +3,20 @@ package qio
 import (
 	"QuantumUtils/errors"
 	"QuantumUtils/goroutine"
+	"QuantumUtils/logger"
+	"QuantumUtils/qnet/compress"
 	"QuantumUtils/qnet/connect"
+	"QuantumUtils/qnet/encrypt"
 	"QuantumUtils/qnet/qc"
-	v1 "QuantumUtils/qnet/qc/v1"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// 注册v1、v2版本协议
+	_ "QuantumUtils/qnet/qc/v1"
+	_ "QuantumUtils/qnet/qc/v2"
 )
 
 // QTPReaderAccessor QTPReader存取器
@@ -23,12 +34,74 @@ func (q *QTPReaderAccessor) SetQTPReader(r *QTPReader) {
 // QTPReader 从连接中持续读取QTP数据
 type QTPReader struct {
 	connect.QTPConnAccessor
+	logger.QLoggerAccessor
 	// DATA以及RETRY消息类型的通道
 	DATAChan chan *qc.QTPData
 	// ACK消息类型通道
 	ACKChan chan *qc.QTPData
+	// 控制消息通道
+	ControlChan chan *qc.QTPData
 	// 断连后的错误消息通道
 	ErrorChan chan *errors.QError
+	// 消息解析器，根据消息头中的版本号选择对应版本的解析器
+	parser qc.QTPParser
+	// 容错配置
+	rsConf ResyncConfig
+	// 诊断信息
+	diag   ReaderDiagnostics
+	diagMu sync.Mutex
+	// 从连接中读取的byte数
+	bytesRead atomic.Int64
+	// 单个消息数据的最大byte长度
+	maxFrameSize int
+	// 已读取但尚未处理完毕的消息数据的最大byte总长度
+	maxBufferedBytes int64
+	// 已读取但尚未处理完毕的消息数据的byte总长度
+	buffered atomic.Int64
+	// 连接的加密会话，为nil时不解密
+	session *encrypt.Session
+	// 对端尚未通过认证，期间只接受控制消息
+	unauthenticated atomic.Bool
+}
+
+// ReaderDiagnostics QTPReader的诊断信息
+type ReaderDiagnostics struct {
+	// 成功解析的消息数量
+	Frames uint64
+	// 从连接中读取的byte数
+	BytesRead int64
+	// 最后一个成功解析的消息头，不包含消息数据
+	LastHeader qc.QTPHeader
+	// 最后一个消息的解析时间
+	LastReadTime time.Time
+	// 最后一次解析异常
+	LastError *errors.QError
+	// 容错模式下已容忍的损坏次数
+	Corruptions int
+	// 容错模式下累计跳过的byte数
+	SkippedBytes int64
+	// 已读取但尚未处理完毕的消息数据的byte总长度
+	BufferedBytes int64
+}
+
+// 统计读取byte数的Reader
+type countingReader struct {
+	reader io.Reader
+	count  *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// ResyncConfig 容错模式配置，开启后遇到损坏的消息时跳至下一个合法的消息头继续读取，而不是直接断开连接
+type ResyncConfig struct {
+	// 是否开启容错模式，只跳过格式错误与校验和错误的消息，解密失败与重放的消息依然断开连接
+	Enable bool
+	// 最多容忍的损坏次数，超过后断开连接
+	MaxCorruptions int
 }
 
 type QTPReaderConfig struct {
@@ -36,29 +109,91 @@ type QTPReaderConfig struct {
 	DataCap int
 	// ACK消息缓存长度
 	ACKCap int
+	// 控制消息缓存长度
+	ControlCap int
+	// 是否使用缓冲池读取消息，开启后QTPData会在回调结束后被回收，回调中若需保留Data需自行拷贝
+	Pooled bool
+	// 容错模式配置
+	RSConfig ResyncConfig
+	// 单个消息数据（包括解压后的数据）的最大byte长度，超过时断开连接，不大于0时不限制
+	MaxFrameSize int
+	// 已读取但尚未处理完毕的消息数据的最大byte总长度，超过时断开连接，不大于0时不限制
+	MaxBufferedBytes int64
 }
 
 // 初始化通道
 func (r *QTPReader) initChan(conf QTPReaderConfig) {
 	r.DATAChan = make(chan *qc.QTPData, conf.DataCap)
 	r.ACKChan = make(chan *qc.QTPData, conf.ACKCap)
+	r.ControlChan = make(chan *qc.QTPData, conf.ControlCap)
 	r.ErrorChan = make(chan *errors.QError)
 }
 
 func (r *QTPReader) start() {
-	parser := v1.NewQMsgParser()
+	reader := qc.NewBufferedReader(countingReader{reader: r.GetQTPConn(), count: &r.bytesRead})
 	for {
-		qtpData := parser.ParseReader(r.GetQTPConn())
+		if r.rsConf.Enable {
+			skipped, err := qc.Resync(reader)
+			if skipped > 0 && !r.tolerate(errors.NewKind(qc.FormatError, "跳过了"+strconv.Itoa(skipped)+"byte非法数据"), skipped) {
+				break
+			}
+			if err != nil {
+				r.ErrorChan <- errors.New(err.Error())
+				break
+			}
+		}
+		qtpData := r.parser.ParseReader(reader)
+		r.record(qtpData)
 		if qtpData.ParserError != nil {
 			// reader解析错误
 			//receiver.serverError(qtpData.ParserError)
-			r.ErrorChan <- qtpData.ParserError
+			if r.corrupted(qtpData.ParserError) && r.tolerate(qtpData.ParserError, 0) {
+				qtpData.Release()
+				continue
+			}
+			r.fail(qtpData.ParserError)
+			break
+		}
+		if r.unauthenticated.Load() && !qtpData.IsControl() {
+			// 未通过认证的对端发送的消息不缓存，在解密之前丢弃，不占用重放窗口，认证通过后可由对端重发
+			qtpData.Release()
+			continue
+		}
+		if qtpData.Header.MsgType != qc.ACK {
+			qtpData = r.decrypt(qtpData)
+			if qtpData.ParserError != nil {
+				if qtpData.Header.MsgType == qc.RETRY && qtpData.ParserError.IsKind(qc.ReplayError) {
+					// 原消息已送达，重发的消息直接丢弃
+					continue
+				}
+				r.record(qtpData)
+				// 解密、认证失败与重放不是传输中的损坏，容错模式下同样断开连接
+				r.fail(qtpData.ParserError)
+				break
+			}
+		}
+		if qtpData.Header.CompressID() != compress.NONE {
+			qtpData = r.decompress(qtpData)
+			if qtpData.ParserError != nil {
+				r.record(qtpData)
+				// 解压失败时消息边界依然完整，可直接丢弃该消息
+				if r.rsConf.Enable && !qtpData.ParserError.IsKind(qc.FrameSizeError) && r.tolerate(qtpData.ParserError, 0) {
+					continue
+				}
+				r.fail(qtpData.ParserError)
+				break
+			}
+		}
+		if !r.acquire(qtpData) {
 			break
 		}
 		switch qtpData.Header.MsgType {
 		case qc.ACK:
 			{
-
+				if qtpData.IsControl() {
+					r.ControlChan <- qtpData
+					break
+				}
 				r.ACKChan <- qtpData
 				break
 			}
@@ -78,6 +213,138 @@ func (r *QTPReader) start() {
 	}
 }
 
+// 向ErrorChan发送异常，超过长度或内存限制时直接关闭连接
+func (r *QTPReader) fail(err *errors.QError) {
+	if err.IsKind(qc.FrameSizeError) || err.IsKind(qc.BufferLimitError) {
+		_ = r.GetQTPConn().Close()
+	}
+	r.ErrorChan <- err
+}
+
+// 占用消息数据长度的缓冲额度，超过限制时发送异常并返回false
+func (r *QTPReader) acquire(qtpData *qc.QTPData) bool {
+	size := int64(len(qtpData.Data))
+	buffered := r.buffered.Add(size)
+	if r.maxBufferedBytes > 0 && buffered > r.maxBufferedBytes {
+		r.buffered.Add(-size)
+		qtpData.Release()
+		r.fail(errors.NewKind(qc.BufferLimitError, "等待处理的消息数据共"+strconv.FormatInt(buffered, 10)+"byte，超过了限制的"+strconv.FormatInt(r.maxBufferedBytes, 10)+"byte"))
+		return false
+	}
+	return true
+}
+
+// Consumed 消息处理完毕后调用，归还其占用的缓冲额度，需在QTPData.Release之前调用
+// 从DATAChan、ACKChan与ControlChan中取出的每个消息都需要调用一次，否则会被视为一直未处理完毕
+func (r *QTPReader) Consumed(qtpData *qc.QTPData) {
+	r.buffered.Add(-int64(len(qtpData.Data)))
+}
+
+// 判断异常是否由损坏的消息导致，连接读取失败等异常无法容忍
+func (r *QTPReader) corrupted(err *errors.QError) bool {
+	return r.rsConf.Enable && (err.IsKind(qc.FormatError) || err.IsKind(qc.ChecksumError))
+}
+
+// 记录解析结果至诊断信息
+func (r *QTPReader) record(qtpData *qc.QTPData) {
+	r.diagMu.Lock()
+	defer r.diagMu.Unlock()
+	if qtpData.ParserError != nil {
+		r.diag.LastError = qtpData.ParserError
+		return
+	}
+	r.diag.Frames++
+	r.diag.LastHeader = qtpData.Header
+	// 扩展可能引用池化缓冲，需要拷贝
+	r.diag.LastHeader.Extensions = qtpData.Header.Extensions.Clone()
+	r.diag.LastReadTime = time.Now()
+}
+
+// Diagnostics 获取诊断信息的快照，可在任意goroutine中调用
+func (r *QTPReader) Diagnostics() ReaderDiagnostics {
+	r.diagMu.Lock()
+	diag := r.diag
+	r.diagMu.Unlock()
+	diag.LastHeader.Extensions = diag.LastHeader.Extensions.Clone()
+	diag.BytesRead = r.bytesRead.Load()
+	diag.BufferedBytes = r.buffered.Load()
+	return diag
+}
+
+// 记录一次损坏，未超过阈值时返回true，超过阈值时向ErrorChan发送异常并返回false
+func (r *QTPReader) tolerate(err *errors.QError, skipped int) bool {
+	r.diagMu.Lock()
+	r.diag.Corruptions++
+	r.diag.SkippedBytes += int64(skipped)
+	r.diag.LastError = err
+	corruptions, skippedBytes := r.diag.Corruptions, r.diag.SkippedBytes
+	r.diagMu.Unlock()
+	if corruptions > r.rsConf.MaxCorruptions {
+		qErr := errors.NewKind(qc.FormatError, "损坏的消息次数超过了"+strconv.Itoa(r.rsConf.MaxCorruptions)+"次，最后一次异常: "+err.Error())
+		qErr.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		r.ErrorChan <- qErr
+		return false
+	}
+	if l := r.GetLogger(); l != nil {
+		l.Warn("QTPReader跳过损坏的消息（第" + strconv.Itoa(corruptions) + "次，累计跳过" + strconv.FormatInt(skippedBytes, 10) + "byte）: " + err.Error())
+	}
+	return true
+}
+
+// 解压数据，解压后的QTPData不再持有池化缓冲
+func (r *QTPReader) decompress(qtpData *qc.QTPData) *qc.QTPData {
+	header := qtpData.Header
+	data, err := compress.DecompressLimit(header, qtpData.Data, r.maxFrameSize)
+	qtpData.Release()
+	if err != nil {
+		err.WithMessage("数据格式不安全，不符合QTP规范，请求断开客户端连接")
+		return &qc.QTPData{Header: header, ParserError: err}
+	}
+	header.Reserved &^= qc.ReservedCompressMask
+	header.DataLength = uint32(len(data))
+	return &qc.QTPData{Header: header, Data: data}
+}
+
+// 解密DATA与RETRY消息的数据，未加密且不要求加密时原样返回，解密后的QTPData不再持有池化缓冲
+func (r *QTPReader) decrypt(qtpData *qc.QTPData) *qc.QTPData {
+	_, encrypted := qtpData.Header.Extensions.Encryption()
+	if !encrypted && (r.session == nil || !r.session.Required()) {
+		return qtpData
+	}
+	header := qtpData.Header
+	var data []byte
+	var err *errors.QError
+	switch {
+	case !encrypted:
+		err = errors.NewKind(qc.DecryptError, "收到未加密的消息")
+	case r.session == nil:
+		err = errors.NewKind(qc.DecryptError, "收到加密的消息，但未配置预共享密钥")
+	default:
+		data, err = r.session.Open(header, qtpData.Data)
+	}
+	qtpData.Release()
+	if err != nil {
+		return &qc.QTPData{Header: header, ParserError: err}
+	}
+	header.DataLength = uint32(len(data))
+	return &qc.QTPData{Header: header, Data: data}
+}
+
+// SetSession 设置连接的加密会话，需在Start前调用
+func (r *QTPReader) SetSession(session *encrypt.Session) {
+	r.session = session
+}
+
+// RequireAuth 对端通过认证前只接受控制消息，其他消息直接丢弃，需在Start前调用
+func (r *QTPReader) RequireAuth() {
+	r.unauthenticated.Store(true)
+}
+
+// Authenticated 对端已通过认证，之后收到的消息正常处理，需在通知对端认证结果之前调用
+func (r *QTPReader) Authenticated() {
+	r.unauthenticated.Store(false)
+}
+
 // Start 启动
 func (r *QTPReader) Start() {
 	goroutine.CheckAndGetters(func() any {
@@ -91,13 +358,32 @@ func NewQTPReader(conn connect.QTPConn, conf QTPReaderConfig) *QTPReader {
 	reader := QTPReader{}
 	reader.SetQTPConn(conn)
 	reader.initChan(conf)
+	reader.parser = qc.NewVersionedParser(qc.ParserOptions{Pooled: conf.Pooled, MaxFrameSize: conf.MaxFrameSize})
+	reader.rsConf = conf.RSConfig
+	reader.maxFrameSize = conf.MaxFrameSize
+	reader.maxBufferedBytes = conf.MaxBufferedBytes
 	return &reader
 }
 
 // QTPReaderConfigDefault 默认配置
 func QTPReaderConfigDefault() QTPReaderConfig {
 	return QTPReaderConfig{
-		DataCap: 1000,
-		ACKCap:  1000,
+		DataCap:    1000,
+		ACKCap:     1000,
+		ControlCap: 16,
+		Pooled:     false,
+		RSConfig:   ResyncConfigDefault(),
+		// 16MB
+		MaxFrameSize: qc.DefaultMaxFrameSize,
+		// 64MB
+		MaxBufferedBytes: 64 << 20,
+	}
+}
+
+// ResyncConfigDefault 默认容错配置，默认关闭
+func ResyncConfigDefault() ResyncConfig {
+	return ResyncConfig{
+		Enable:         false,
+		MaxCorruptions: 16,
 	}
 }
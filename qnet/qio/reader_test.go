@@ -0,0 +1,326 @@
+package qio
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/encrypt"
+	"QuantumUtils/qnet/qc"
+	v1 "QuantumUtils/qnet/qc/v1"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// 构造一段混有损坏消息的数据流，其中只有first与last两个消息是完好的
+func corruptStream(t *testing.T) []byte {
+	encoder := v1.NewQMsgEncoder(fixedSeq(1))
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+	frame := func(data string, checksum bool) []byte {
+		conf.Checksum = checksum
+		_, b, err := encoder.Encode([]byte(data), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	var stream bytes.Buffer
+	stream.WriteString("garbage")
+	stream.Write(frame("first", false))
+	// 校验和错误
+	broken := frame("broken", true)
+	broken[qc.HeaderLength] ^= 0xFF
+	stream.Write(broken)
+	// 非法的消息类型
+	badType := frame("badType", false)
+	badType[15] = 0xFF
+	stream.Write(badType)
+	// 截断的消息头
+	stream.Write(qc.HeaderFlag[:])
+	stream.Write(frame("last", false))
+	return stream.Bytes()
+}
+
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+func readStream(t *testing.T, conf QTPReaderConfig, stream []byte) *QTPReader {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	reader := NewQTPReader(client, conf)
+	reader.Start()
+	go func() {
+		_, _ = server.Write(stream)
+	}()
+	return reader
+}
+
+func TestReaderResync(t *testing.T) {
+	conf := QTPReaderConfigDefault()
+	conf.RSConfig.Enable = true
+	reader := readStream(t, conf, corruptStream(t))
+	for _, want := range []string{"first", "last"} {
+		select {
+		case data := <-reader.DATAChan:
+			if string(data.Data) != want {
+				t.Errorf("expected %q, got %q", want, data.Data)
+			}
+		case err := <-reader.ErrorChan:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	// 相邻的非法消息类型与截断的消息头在一次跳过中处理
+	if diag := reader.Diagnostics(); diag.Corruptions != 3 {
+		t.Errorf("expected 3 corruptions, got %d", diag.Corruptions)
+	}
+}
+
+func TestReaderResyncThreshold(t *testing.T) {
+	conf := QTPReaderConfigDefault()
+	conf.RSConfig.Enable = true
+	conf.RSConfig.MaxCorruptions = 1
+	reader := readStream(t, conf, corruptStream(t))
+	<-reader.DATAChan
+	select {
+	case err := <-reader.ErrorChan:
+		if !err.IsKind(qc.FormatError) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected disconnect after threshold")
+	}
+
+	// 未开启容错模式时遇到非法数据立即断开
+	reader = readStream(t, QTPReaderConfigDefault(), corruptStream(t))
+	select {
+	case err := <-reader.ErrorChan:
+		if !err.IsKind(qc.FormatError) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-reader.DATAChan:
+		t.Error("received data after garbage without resync")
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+// 容错模式不跳过解密失败与重放的消息
+func TestReaderResyncDecryptError(t *testing.T) {
+	keyring, _ := encrypt.NewKeyring(1, []byte("0123456789abcdef0123456789abcdef"))
+	econf := encrypt.ConfigDefault()
+	econf.Keyring = keyring
+	encoder, _ := qc.NewEncoder(2, fixedSeq(1))
+	for _, want := range []errors.Kind{qc.DecryptError, qc.ReplayError} {
+		sealer, _ := encrypt.NewSession(econf)
+		opener, _ := encrypt.NewSession(econf)
+		_ = sealer.SetPeerSalt(opener.Salt())
+		_ = opener.SetPeerSalt(sealer.Salt())
+		frame := func(data string) []byte {
+			conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+			keyID, sealed, err := sealer.Seal(conf, []byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Extensions.SetEncryption(keyID)
+			_, b, err := encoder.Encode(sealed, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		first := frame("first")
+		second := first
+		if want == qc.DecryptError {
+			second = frame("tampered")
+			second[len(second)-1] ^= 0xFF
+		}
+
+		conf := QTPReaderConfigDefault()
+		conf.RSConfig = ResyncConfig{Enable: true, MaxCorruptions: 10}
+		client, server := net.Pipe()
+		reader := NewQTPReader(client, conf)
+		reader.SetSession(opener)
+		reader.Start()
+		go func() {
+			_, _ = server.Write(append(append([]byte(nil), first...), second...))
+		}()
+		select {
+		case data := <-reader.DATAChan:
+			if string(data.Data) != "first" {
+				t.Errorf("%s: unexpected data %q", want, data.Data)
+			}
+		case err := <-reader.ErrorChan:
+			t.Fatalf("%s: %v", want, err)
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timeout", want)
+		}
+		select {
+		case err := <-reader.ErrorChan:
+			if !err.IsKind(want) {
+				t.Errorf("expected %s, got %v", want, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: frame was tolerated instead of closing the connection", want)
+		}
+		if diag := reader.Diagnostics(); diag.Corruptions != 0 {
+			t.Errorf("%s: counted as %d corruptions", want, diag.Corruptions)
+		}
+		_ = client.Close()
+		_ = server.Close()
+	}
+}
+
+func TestReaderDiagnostics(t *testing.T) {
+	encoder, _ := qc.NewEncoder(2, fixedSeq(7))
+	conf := qc.QTPConfig{Encode: qc.JSON, MsgType: qc.DATA, ACKType: qc.AsyncACK}
+	var stream []byte
+	for _, route := range []string{"first", "last"} {
+		conf.Extensions.SetRoute(route)
+		var err *errors.QError
+		_, stream, err = encoder.AppendEncode(stream, []byte(`{}`), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rConf := QTPReaderConfigDefault()
+	rConf.Pooled = true
+	reader := readStream(t, rConf, stream)
+	for i := 0; i < 2; i++ {
+		data := <-reader.DATAChan
+		data.Release()
+	}
+
+	diag := reader.Diagnostics()
+	if diag.Frames != 2 || diag.BytesRead != int64(len(stream)) || diag.LastError != nil {
+		t.Errorf("unexpected diagnostics %+v", diag)
+	}
+	if diag.LastHeader.Seq != 7 || diag.LastHeader.Extensions.Route() != "last" || diag.LastReadTime.IsZero() {
+		t.Errorf("unexpected last header %+v", diag.LastHeader)
+	}
+}
+
+func TestReaderFrameSizeLimit(t *testing.T) {
+	_, frame, _ := v1.NewQMsgEncoder(fixedSeq(1)).Encode(nil, qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK})
+	// 消息头声明1GB的数据长度
+	binary.LittleEndian.PutUint32(frame[17:21], 1<<30)
+
+	conf := QTPReaderConfigDefault()
+	conf.MaxFrameSize = 1024
+	client, server := net.Pipe()
+	defer server.Close()
+	reader := NewQTPReader(client, conf)
+	reader.Start()
+	go func() {
+		_, _ = server.Write(frame)
+	}()
+	select {
+	case err := <-reader.ErrorChan:
+		if !err.IsKind(qc.FrameSizeError) {
+			t.Errorf("expected FrameSizeError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	// 超过限制时连接被关闭
+	if _, err := server.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open")
+	}
+}
+
+func TestReaderBufferLimit(t *testing.T) {
+	_, frame, _ := v1.NewQMsgEncoder(fixedSeq(1)).Encode(make([]byte, 60), qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK})
+	conf := QTPReaderConfigDefault()
+	conf.MaxBufferedBytes = 100
+	client, server := net.Pipe()
+	defer server.Close()
+	reader := NewQTPReader(client, conf)
+	reader.Start()
+
+	// 及时处理的消息不会超过限制
+	for i := 0; i < 3; i++ {
+		if _, err := server.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		reader.Consumed(<-reader.DATAChan)
+	}
+	if diag := reader.Diagnostics(); diag.BufferedBytes != 0 {
+		t.Errorf("expected no buffered bytes, got %d", diag.BufferedBytes)
+	}
+
+	// 未处理的消息超过限制时断开连接
+	go func() {
+		_, _ = server.Write(append(append([]byte(nil), frame...), frame...))
+	}()
+	select {
+	case err := <-reader.ErrorChan:
+		if !err.IsKind(qc.BufferLimitError) {
+			t.Errorf("expected BufferLimitError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if len(reader.DATAChan) != 1 {
+		t.Errorf("expected 1 buffered message, got %d", len(reader.DATAChan))
+	}
+}
+
+func TestReaderRequireAuth(t *testing.T) {
+	frame := func(seq uint64, data string, msgType qc.MsgType) []byte {
+		conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: msgType, ACKType: qc.NoACK}
+		_, b, err := v1.NewQMsgEncoder(fixedSeq(seq)).Encode([]byte(data), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	client, server := net.Pipe()
+	defer server.Close()
+	reader := NewQTPReader(client, QTPReaderConfigDefault())
+	reader.RequireAuth()
+	reader.Start()
+
+	// 认证通过前只接受控制消息，业务消息与ACK不会被缓存
+	var stream []byte
+	stream = append(stream, frame(1, "early", qc.DATA)...)
+	stream = append(stream, frame(2, "ack", qc.ACK)...)
+	stream = append(stream, frame(qc.ControlSeq, "control", qc.ACK)...)
+	go func() {
+		_, _ = server.Write(stream)
+	}()
+	select {
+	case data := <-reader.ControlChan:
+		if string(data.Data) != "control" {
+			t.Errorf("unexpected control %q", data.Data)
+		}
+		reader.Consumed(data)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+	if len(reader.DATAChan) != 0 || len(reader.ACKChan) != 0 {
+		t.Errorf("messages buffered before authentication: %d DATA, %d ACK", len(reader.DATAChan), len(reader.ACKChan))
+	}
+	if diag := reader.Diagnostics(); diag.BufferedBytes != 0 {
+		t.Errorf("expected no buffered bytes, got %d", diag.BufferedBytes)
+	}
+
+	reader.Authenticated()
+	go func() {
+		_, _ = server.Write(frame(3, "late", qc.DATA))
+	}()
+	select {
+	case data := <-reader.DATAChan:
+		if string(data.Data) != "late" {
+			t.Errorf("unexpected message %q", data.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -32,9 +32,10 @@ type retrySet struct {
 	rConfig RetryConfig
 }
 
-func (r *retrySet) append(seq uint64, data []byte, retryH func(data []byte), lce LCE) *retryData {
+func (r *retrySet) append(seq uint64, data []byte, retryH func(data []byte), lce LCE, stale func() bool) *retryData {
 	rData := &retryData{
 		encodedData: data,
+		stale:       stale,
 		retryCount:  0,
 		sendTime:    time.Now(),
 		retryH:      retryH,
@@ -56,19 +57,39 @@ func (r *retrySet) get(seq uint64) *retryData {
 	return data
 }
 
-// 结束消息的生命周期
-func (r *retrySet) delete(seq uint64) {
-	seqStr := strconv.FormatUint(seq, 10)
-	rData, ok := r.maps.Get(seqStr)
-
+// 结束消息的生命周期，err不为nil时表明消息被对端拒绝，LCE会收到该异常
+// 同一序列号的ACK与NACK可能并发到达，只有从集合中取出消息的一方会通知done，done的容量为1，重复通知会阻塞
+func (r *retrySet) delete(seq uint64, err *errors.QError) {
+	rData, ok := r.maps.Pop(strconv.FormatUint(seq, 10))
 	if !ok {
-		//fmt.Println("比写早了！")
 		return
 	}
-	r.maps.Remove(seqStr)
+	rData.err = err
 	rData.done <- true
 }
 
+// 以reason结束所有数据已失效的消息的生命周期
+func (r *retrySet) failStale(reason string) {
+	for _, seqStr := range r.maps.Keys() {
+		if rData, ok := r.maps.Get(seqStr); !ok || rData.stale == nil || !rData.stale() {
+			continue
+		}
+		if rData, ok := r.maps.Pop(seqStr); ok {
+			rData.err = errors.NewKind(qc.StaleError, reason)
+			rData.done <- true
+		}
+	}
+}
+
+// 消息写出失败，取消该消息的生命周期
+func (r *retrySet) cancel(seq uint64) {
+	rData, ok := r.maps.Pop(strconv.FormatUint(seq, 10))
+	if !ok {
+		return
+	}
+	rData.wg.Done()
+}
+
 type retryData struct {
 	wg sync.WaitGroup
 	// QTP包装后的data
@@ -87,6 +108,10 @@ type retryData struct {
 	seq uint64
 	// 重发配置
 	rConfig RetryConfig
+	// 对端拒绝消息时的异常
+	err *errors.QError
+	// 判断数据是否已失效，为nil时永不失效
+	stale func() bool
 }
 
 func (r *retryData) startLife() {
@@ -101,7 +126,7 @@ func (r *retryData) startLife() {
 	case <-r.done:
 		{
 			timer.Stop()
-			go r.lce(r.seq, nil)
+			go r.lce(r.seq, r.err)
 			return
 		}
 	}
@@ -124,12 +149,15 @@ func (r *retryData) getTimeoutTimer() *time.Timer {
 
 func (r *retryData) retry() {
 	// 将header改为retry
-	r.encodedData[15] = byte(qc.RETRY)
+	qc.SetMsgType(r.encodedData, qc.RETRY)
 	// retry计数器
 	count := 0
 	for count < r.rConfig.RetryAttempts {
-		// 执行retry方法
-		r.retryH(r.encodedData)
+		// 数据失效后不再重发，等待FailStale结束生命周期
+		if r.stale == nil || !r.stale() {
+			// 执行retry方法
+			r.retryH(r.encodedData)
+		}
 		// retry后检测是否收到ACK，2S后没收到则继续retry
 		select {
 		case <-time.After(time.Second * 2):
@@ -138,7 +166,7 @@ func (r *retryData) retry() {
 			}
 		case <-r.done:
 			{
-				go r.lce(r.seq, nil)
+				go r.lce(r.seq, r.err)
 				return
 			}
 		}
@@ -6,6 +6,8 @@ import (
 	"QuantumUtils/qnet/connect"
 	"QuantumUtils/qnet/qc"
 	cmap "github.com/orcaman/concurrent-map/v2"
+	"net"
+	"sync"
 	"time"
 )
 
@@ -18,6 +20,30 @@ type QTPWriterConfig struct {
 	RConfig RetryConfig
 	// 发送缓冲区长度
 	SendCap int
+	// 合并写配置
+	BConfig BatchConfig
+}
+
+// BatchConfig 合并写配置，开启后Writer会将队列中的多条消息合并为一次Write，减少系统调用与TLS记录数量
+type BatchConfig struct {
+	// 是否开启合并写
+	Enable bool
+	// 单次合并写的最大消息数
+	MaxBatchSize int
+	// 单次合并写的最大字节数，单条消息超过该值时单独写出，不大于0时使用默认值
+	MaxBatchBytes int
+	// 队列为空时等待更多消息的最长时间，为0时不等待，有多少写多少
+	FlushDelay time.Duration
+}
+
+// BatchConfigDefault Get默认合并写配置，默认不开启
+func BatchConfigDefault() BatchConfig {
+	return BatchConfig{
+		Enable:        false,
+		MaxBatchSize:  64,
+		MaxBatchBytes: 64 * 1024,
+		FlushDelay:    0,
+	}
 }
 
 // QTPWriterAccessor QTPWriter存取器
@@ -41,11 +67,19 @@ type QTPWriter struct {
 	rs retrySet
 	// 重发消息配置
 	rConfig RetryConfig
-
+	// 合并写配置
+	bConfig BatchConfig
+	// 合并写缓冲区
+	buf []byte
+	// 关闭通知，关闭后Writer处理完队列中剩余的消息后退出
+	done   chan struct{}
 	closed bool
+	// 保护closed，保证关闭后不会再有请求进入SendChan
+	mu sync.RWMutex
 }
 
 func (q *QTPWriter) start() {
+	batch := make([]*SendReq, 0, q.bConfig.MaxBatchSize)
 	for {
 		select {
 		case sr, ok := <-q.SendChan:
@@ -54,64 +88,175 @@ func (q *QTPWriter) start() {
 					// 通道关闭，退出循环
 					return
 				}
-				switch sr.Config.ACKType {
-				case qc.NoACK:
-					{
-						_, err := q.GetQTPConn().Write(sr.Data)
-						if err != nil {
-							go sr.LCE(sr.SeqN, errors.New(err.Error()))
-							continue
-						}
-						go sr.LCE(sr.SeqN, nil)
-						break
+				batch = q.flush(batch, sr)
+			}
+		case <-q.done:
+			{
+				// 已关闭，写出队列中剩余的消息后退出
+				for {
+					select {
+					case sr := <-q.SendChan:
+						batch = q.flush(batch, sr)
+					default:
+						return
 					}
-				case qc.SyncACK:
+				}
+			}
+		}
+
+	}
+}
+
+// 以sr为首条消息写出一批消息，返回可复用的batch
+func (q *QTPWriter) flush(batch []*SendReq, sr *SendReq) []*SendReq {
+	batch = append(batch[:0], sr)
+	if q.bConfig.Enable {
+		batch = q.collect(batch)
+	}
+	q.write(batch)
+	return batch
+}
+
+// 从发送队列中收集更多的消息，直到达到合并写的上限或队列为空
+func (q *QTPWriter) collect(batch []*SendReq) []*SendReq {
+	size := len(batch[0].Data)
+	var delay <-chan time.Time
+	if q.bConfig.FlushDelay > 0 {
+		timer := time.NewTimer(q.bConfig.FlushDelay)
+		defer timer.Stop()
+		delay = timer.C
+	}
+	for len(batch) < q.bConfig.MaxBatchSize && size < q.bConfig.MaxBatchBytes {
+		select {
+		case sr, ok := <-q.SendChan:
+			{
+				if !ok {
+					return batch
+				}
+				batch = append(batch, sr)
+				size += len(sr.Data)
+			}
+		default:
+			{
+				if delay == nil {
+					return batch
+				}
+				// 队列已空，等待FlushDelay内到达的消息
+				select {
+				case sr, ok := <-q.SendChan:
 					{
-						rd := q.rs.append(sr.SeqN, sr.Data, q.resend, sr.LCE)
-						_, err := q.GetQTPConn().Write(sr.Data)
-						if err != nil {
-							go sr.LCE(sr.SeqN, errors.New(err.Error()))
-							continue
+						if !ok {
+							return batch
 						}
-						// 开启消息生命周期
-						q.GetGoManager().Goroutine(msgLife, rd.startLife)
-						break
+						batch = append(batch, sr)
+						size += len(sr.Data)
 					}
-				case qc.AsyncACK:
+				case <-delay:
 					{
-						rd := q.rs.append(sr.SeqN, sr.Data, q.resend, sr.LCE)
-						_, err := q.GetQTPConn().Write(sr.Data)
-						if err != nil {
-							go sr.LCE(sr.SeqN, errors.New(err.Error()))
-							continue
-						}
-						// 开启消息生命周期
-						q.GetGoManager().Goroutine(msgLife, rd.startLife)
-						break
+						return batch
 					}
-
 				}
 			}
-		case <-time.After(time.Millisecond * 100):
+		}
+	}
+	return batch
+}
+
+// 写出一批消息，并处理每条消息的生命周期
+func (q *QTPWriter) write(batch []*SendReq) {
+	live := batch[:0]
+	for _, sr := range batch {
+		if sr.Stale != nil && sr.Stale() {
+			go sr.LCE(sr.SeqN, errors.NewKind(qc.StaleError, "消息在写出前已失效，未发送"))
+			continue
+		}
+		if sr.Config.ACKType != qc.NoACK {
+			sr.rd = q.rs.append(sr.SeqN, sr.Data, q.resend, sr.LCE, sr.Stale)
+		}
+		live = append(live, sr)
+	}
+	batch = live
+	if len(batch) == 0 {
+		return
+	}
+
+	var err error
+	if len(batch) == 1 {
+		_, err = q.GetQTPConn().Write(batch[0].Data)
+	} else {
+		err = q.writeBuffers(batch)
+	}
+
+	for _, sr := range batch {
+		if err != nil {
+			if sr.rd != nil {
+				q.rs.cancel(sr.SeqN)
+			}
+			go sr.LCE(sr.SeqN, errors.New(err.Error()))
+			continue
+		}
+		switch sr.Config.ACKType {
+		case qc.NoACK:
 			{
-				if q.closed {
-					// 如果closed为真，退出循环
-					//fmt.Println("send exit")
-					return
-				}
+				go sr.LCE(sr.SeqN, nil)
+				break
+			}
+		case qc.SyncACK, qc.AsyncACK:
+			{
+				// 开启消息生命周期
+				q.GetGoManager().Goroutine(msgLife, sr.rd.startLife)
+				break
 			}
-
 		}
+	}
+}
 
+// 将多条消息合并为一次写出，TCP连接使用writev，其余连接拷贝至合并缓冲区后写出
+func (q *QTPWriter) writeBuffers(batch []*SendReq) error {
+	conn := q.GetQTPConn()
+	if _, ok := conn.(*net.TCPConn); ok {
+		buffers := make(net.Buffers, len(batch))
+		for i, sr := range batch {
+			buffers[i] = sr.Data
+		}
+		_, err := buffers.WriteTo(conn)
+		return err
+	}
+	q.buf = q.buf[:0]
+	for _, sr := range batch {
+		q.buf = append(q.buf, sr.Data...)
 	}
+	_, err := conn.Write(q.buf)
+	return err
 }
 
 // Close 关闭Writer，该方法会阻塞，等到处理完所有send请求再关闭
 func (q *QTPWriter) Close() {
-	q.closed = true
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	q.mu.Unlock()
 	q.GetGoManager().Wait(sendH)
 }
 
+// Send 提交发送请求，Writer已关闭时返回false，此时不会调用请求的LCE
+func (q *QTPWriter) Send(sr *SendReq) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	q.SendChan <- sr
+	return true
+}
+
+// Done 返回Writer的关闭通知，Writer关闭后该通道会被关闭
+func (q *QTPWriter) Done() <-chan struct{} {
+	return q.done
+}
+
 func (q *QTPWriter) Start() {
 	goroutine.CheckAndGetters(func() any {
 		return q.GetQTPConn()
@@ -123,7 +268,17 @@ func (q *QTPWriter) Start() {
 
 // MsgFinish 完成消息的生命周期
 func (q *QTPWriter) MsgFinish(seq uint64) {
-	q.rs.delete(seq)
+	q.rs.delete(seq, nil)
+}
+
+// FailStale 以qc.StaleError类型的异常结束所有数据已失效、等待应答的消息，连接重连并更换密钥后调用
+func (q *QTPWriter) FailStale(reason string) {
+	q.rs.failStale(reason)
+}
+
+// MsgReject 消息被对端拒绝，以err结束消息的生命周期
+func (q *QTPWriter) MsgReject(seq uint64, err *errors.QError) {
+	q.rs.delete(seq, err)
 }
 
 // WaitALLMsgLCE 等待所有消息生命周期结束
@@ -148,6 +303,14 @@ func (q *QTPWriter) resend(data []byte) {
 func NewQTPWriter(conn connect.QTPConn, wConfig QTPWriterConfig) *QTPWriter {
 	writer := QTPWriter{}
 	writer.SendChan = make(chan *SendReq, wConfig.SendCap)
+	writer.done = make(chan struct{})
+	writer.bConfig = wConfig.BConfig
+	if writer.bConfig.MaxBatchSize < 1 {
+		writer.bConfig.MaxBatchSize = 1
+	}
+	if writer.bConfig.MaxBatchBytes <= 0 {
+		writer.bConfig.MaxBatchBytes = BatchConfigDefault().MaxBatchBytes
+	}
 	writer.rs = retrySet{
 		maps:    cmap.New[*retryData](),
 		rConfig: wConfig.RConfig,
@@ -163,6 +326,10 @@ type SendReq struct {
 	Config qc.QTPConfig
 	// 生命周期结束的回调
 	LCE LCE
+	// 判断Data是否已失效，如加密消息的密钥已随重连更换；失效的消息不再写出与重发，LCE收到qc.StaleError类型的异常，为nil时永不失效
+	Stale func() bool
+	// 消息的重发数据，仅在Writer内部使用
+	rd *retryData
 }
 
 // QTPWriterConfigDefault 默认配置
@@ -170,5 +337,6 @@ func QTPWriterConfigDefault() QTPWriterConfig {
 	return QTPWriterConfig{
 		RConfig: RetryConfigDefault(),
 		SendCap: 1000,
+		BConfig: BatchConfigDefault(),
 	}
 }
@@ -0,0 +1,187 @@
+package qio
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/goroutine"
+	"QuantumUtils/qnet/qc"
+	v1 "QuantumUtils/qnet/qc/v1"
+	"QuantumUtils/qnet/seq"
+	cmap "github.com/orcaman/concurrent-map/v2"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 建立一条本地TCP连接，对端持续丢弃读取到的数据
+func discardConn(b *testing.B) net.Conn {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		conn, err := listen.Accept()
+		_ = listen.Close()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conn
+}
+
+func benchmarkWriter(b *testing.B, bConf BatchConfig, size int) {
+	conn := discardConn(b)
+	defer conn.Close()
+
+	wConf := QTPWriterConfigDefault()
+	wConf.BConfig = bConf
+	writer := NewQTPWriter(conn, wConf)
+	writer.SetGoManager(&goroutine.GoManager{})
+	writer.Start()
+	defer writer.Close()
+
+	encoder := v1.NewQMsgEncoder(seq.NewSnowFlakeSeqGenerator())
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+	seqN, data, qErr := encoder.Encode(make([]byte, size), conf)
+	if qErr != nil {
+		b.Fatal(qErr)
+	}
+
+	var wg sync.WaitGroup
+	lce := func(seq uint64, err *errors.QError) {
+		if err != nil {
+			b.Error(err)
+		}
+		wg.Done()
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	wg.Add(b.N)
+	for i := 0; i < b.N; i++ {
+		writer.SendChan <- &SendReq{SeqN: seqN, Data: data, Config: conf, LCE: lce}
+	}
+	wg.Wait()
+}
+
+func BenchmarkQTPWriter(b *testing.B) {
+	batch := BatchConfigDefault()
+	batch.Enable = true
+	for _, size := range []int{16, 256, 4096} {
+		b.Run("Single/"+strconv.Itoa(size)+"B", func(b *testing.B) {
+			benchmarkWriter(b, BatchConfigDefault(), size)
+		})
+		b.Run("Batch/"+strconv.Itoa(size)+"B", func(b *testing.B) {
+			benchmarkWriter(b, batch, size)
+		})
+	}
+}
+
+func TestQTPWriterClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, server)
+	}()
+
+	writer := NewQTPWriter(client, QTPWriterConfigDefault())
+	writer.SetGoManager(&goroutine.GoManager{})
+	writer.Start()
+
+	encoder := v1.NewQMsgEncoder(seq.NewSnowFlakeSeqGenerator())
+	conf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.DATA, ACKType: qc.NoACK}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	finished := 0
+	for i := 0; i < 100; i++ {
+		seqN, data, qErr := encoder.Encode([]byte("close"), conf)
+		if qErr != nil {
+			t.Fatal(qErr)
+		}
+		wg.Add(1)
+		ok := writer.Send(&SendReq{SeqN: seqN, Data: data, Config: conf, LCE: func(seq uint64, err *errors.QError) {
+			defer wg.Done()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			finished++
+			mu.Unlock()
+		}})
+		if !ok {
+			t.Fatal("writer closed before Close")
+		}
+	}
+
+	start := time.Now()
+	writer.Close()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Close took %v", elapsed)
+	}
+	wg.Wait()
+	if finished != 100 {
+		t.Errorf("finished %d of 100 messages", finished)
+	}
+	if writer.Send(&SendReq{Config: conf}) {
+		t.Error("Send accepted a request after Close")
+	}
+}
+
+func TestBatchConfigNormalize(t *testing.T) {
+	wConf := QTPWriterConfigDefault()
+	wConf.BConfig = BatchConfig{Enable: true}
+	writer := NewQTPWriter(nil, wConf)
+	if writer.bConfig.MaxBatchSize != 1 {
+		t.Errorf("unexpected MaxBatchSize %d", writer.bConfig.MaxBatchSize)
+	}
+	if writer.bConfig.MaxBatchBytes != BatchConfigDefault().MaxBatchBytes {
+		t.Errorf("unexpected MaxBatchBytes %d", writer.bConfig.MaxBatchBytes)
+	}
+}
+
+// 同一消息的ACK与NACK并发到达时只通知一次生命周期结束
+// 生命周期已结束（如重发次数用完）时没有协程接收done，重复通知会阻塞处理ACK的协程
+func TestRetrySetDeleteOnce(t *testing.T) {
+	rs := retrySet{maps: cmap.New[*retryData](), rConfig: RetryConfigDefault()}
+	const n = 1000
+	pending := make([]*retryData, n)
+	for i := range pending {
+		pending[i] = rs.append(uint64(i), nil, func(data []byte) {}, func(seq uint64, err *errors.QError) {}, nil)
+	}
+
+	var deletes sync.WaitGroup
+	for i := 0; i < n; i++ {
+		for _, err := range []*errors.QError{nil, errors.NewKind(qc.NackError, "rejected")} {
+			deletes.Add(1)
+			go func(seq uint64, err *errors.QError) {
+				defer deletes.Done()
+				rs.delete(seq, err)
+			}(uint64(i), err)
+		}
+	}
+	finished := make(chan struct{})
+	go func() {
+		deletes.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delete blocked")
+	}
+	for i, rData := range pending {
+		if len(rData.done) != 1 {
+			t.Errorf("seq %d notified %d times", i, len(rData.done))
+		}
+	}
+	if rs.maps.Count() != 0 {
+		t.Errorf("%d messages left in the retry set", rs.maps.Count())
+	}
+}
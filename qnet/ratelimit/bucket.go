@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶，令牌以rate个/秒的速度补充，最多积累burst个
+// 取出令牌时允许透支，透支的部分需要等待补充，以此支持延迟处理
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// 获取当前时间，测试时替换
+	now func() time.Time
+}
+
+// NewBucket 创建一个装满令牌的令牌桶，rate不大于0时不限制，burst不大于0时为rate
+func NewBucket(rate float64, burst float64) *Bucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &Bucket{rate: rate, burst: burst, tokens: burst, last: time.Now(), now: time.Now}
+}
+
+// 补充令牌，需持有锁
+func (b *Bucket) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Take 取出n个令牌，返回补足透支的令牌需要等待的时间，为0时表示未透支
+func (b *Bucket) Take(n float64) time.Duration {
+	if b == nil || b.rate <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Refund 归还n个令牌，用于撤销被拒绝的消息取出的令牌
+func (b *Bucket) Refund(n float64) {
+	if b == nil || b.rate <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
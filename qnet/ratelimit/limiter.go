@@ -0,0 +1,264 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Action 超过速率限制时的处理方式
+type Action uint8
+
+const (
+	// Delay 延迟处理消息，并暂停读取该连接，超出的消息在令牌补足后处理
+	Delay Action = iota
+	// Nack 拒绝处理消息，SyncACK与AsyncACK消息以NACK应答，NoACK消息被丢弃
+	Nack
+	// Disconnect 断开连接
+	Disconnect
+)
+
+// Rate 速率限制，各项不大于0时不限制
+type Rate struct {
+	// 每秒允许的消息数量
+	Messages float64
+	// 允许瞬间到达的消息数量，不大于0时为Messages
+	MessageBurst float64
+	// 每秒允许的消息数据byte数
+	Bytes float64
+	// 允许瞬间到达的byte数，不大于0时为Bytes；单个消息超过该值时永远超过限制
+	ByteBurst float64
+}
+
+// Enabled 是否限制了速率
+func (r Rate) Enabled() bool {
+	return r.Messages > 0 || r.Bytes > 0
+}
+
+// Config 速率限制配置
+type Config struct {
+	// 每个连接的速率限制
+	PerConn Rate
+	// 同一远端IP的所有连接共享的速率限制
+	PerIP Rate
+	// Server的所有连接共享的速率限制
+	Global Rate
+	// 超过任一限制时的处理方式
+	Action Action
+}
+
+// ConfigDefault Get默认速率限制配置，默认不限制
+func ConfigDefault() Config {
+	return Config{Action: Delay}
+}
+
+// Enabled 是否限制了任一速率
+func (c Config) Enabled() bool {
+	return c.PerConn.Enabled() || c.PerIP.Enabled() || c.Global.Enabled()
+}
+
+// Stats 速率限制的统计
+type Stats struct {
+	// 未超过限制的消息数量
+	Admitted uint64
+	// 被延迟处理的消息数量
+	Delayed uint64
+	// 被拒绝的消息数量
+	Rejected uint64
+	// 因超过限制而断开的连接数量
+	Disconnected uint64
+	// 累计的延迟时间
+	DelayTotal time.Duration
+}
+
+// 并发更新的统计
+type counters struct {
+	admitted     atomic.Uint64
+	delayed      atomic.Uint64
+	rejected     atomic.Uint64
+	disconnected atomic.Uint64
+	delayTotal   atomic.Int64
+}
+
+func (c *counters) record(action Action, violated bool, wait time.Duration) {
+	if !violated {
+		c.admitted.Add(1)
+		return
+	}
+	switch action {
+	case Delay:
+		c.delayed.Add(1)
+		c.delayTotal.Add(int64(wait))
+	case Nack:
+		c.rejected.Add(1)
+	case Disconnect:
+		c.disconnected.Add(1)
+	}
+}
+
+func (c *counters) stats() Stats {
+	return Stats{
+		Admitted:     c.admitted.Load(),
+		Delayed:      c.delayed.Load(),
+		Rejected:     c.rejected.Load(),
+		Disconnected: c.disconnected.Load(),
+		DelayTotal:   time.Duration(c.delayTotal.Load()),
+	}
+}
+
+// 消息数量与byte数的令牌桶，为nil时不限制
+type buckets struct {
+	messages *Bucket
+	bytes    *Bucket
+}
+
+func newBuckets(rate Rate) buckets {
+	b := buckets{}
+	if rate.Messages > 0 {
+		b.messages = NewBucket(rate.Messages, rate.MessageBurst)
+	}
+	if rate.Bytes > 0 {
+		b.bytes = NewBucket(rate.Bytes, rate.ByteBurst)
+	}
+	return b
+}
+
+// 同一IP的连接共享的令牌桶与统计
+type ipEntry struct {
+	buckets
+	counters
+	// 使用该IP的连接数量
+	refs int
+}
+
+// Limiter Server的速率限制器，为每个连接创建ConnLimiter
+type Limiter struct {
+	conf   Config
+	global buckets
+	counters
+	mu  sync.Mutex
+	ips map[string]*ipEntry
+}
+
+// NewLimiter 以conf创建速率限制器
+func NewLimiter(conf Config) *Limiter {
+	return &Limiter{
+		conf:   conf,
+		global: newBuckets(conf.Global),
+		ips:    make(map[string]*ipEntry),
+	}
+}
+
+// Conn 为远端地址为addr的连接创建ConnLimiter，连接关闭时需调用ConnLimiter.Release
+func (l *Limiter) Conn(addr net.Addr) *ConnLimiter {
+	ip := hostOf(addr)
+	l.mu.Lock()
+	entry, ok := l.ips[ip]
+	if !ok {
+		entry = &ipEntry{buckets: newBuckets(l.conf.PerIP)}
+		l.ips[ip] = entry
+	}
+	entry.refs++
+	l.mu.Unlock()
+	return &ConnLimiter{limiter: l, ip: ip, entry: entry, own: newBuckets(l.conf.PerConn)}
+}
+
+// Stats 所有连接的统计
+func (l *Limiter) Stats() Stats {
+	return l.counters.stats()
+}
+
+// IPStats 当前存在连接的各远端IP的统计
+func (l *Limiter) IPStats() map[string]Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make(map[string]Stats, len(l.ips))
+	for ip, entry := range l.ips {
+		result[ip] = entry.counters.stats()
+	}
+	return result
+}
+
+func hostOf(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// ConnLimiter 一个连接的速率限制器
+type ConnLimiter struct {
+	limiter *Limiter
+	ip      string
+	entry   *ipEntry
+	own     buckets
+	counters
+	released atomic.Bool
+}
+
+// Action 超过限制时的处理方式
+func (c *ConnLimiter) Action() Action {
+	return c.limiter.conf.Action
+}
+
+// Admit 为一个数据长度为size的消息取出令牌，返回是否超过限制，以及Delay方式下需要延迟的时间
+// 处理方式不为Delay时，超过限制的消息不消耗令牌
+func (c *ConnLimiter) Admit(size int) (bool, time.Duration) {
+	counts := []*Bucket{c.own.messages, c.entry.messages, c.limiter.global.messages}
+	sizes := []*Bucket{c.own.bytes, c.entry.bytes, c.limiter.global.bytes}
+	wait := take(counts, 1)
+	if w := take(sizes, float64(size)); w > wait {
+		wait = w
+	}
+	violated := wait > 0
+	action := c.Action()
+	if violated && action != Delay {
+		refund(counts, 1)
+		refund(sizes, float64(size))
+	}
+	c.counters.record(action, violated, wait)
+	c.entry.counters.record(action, violated, wait)
+	c.limiter.counters.record(action, violated, wait)
+	return violated, wait
+}
+
+// 从每个令牌桶中取出n个令牌，返回最长的等待时间
+func take(buckets []*Bucket, n float64) time.Duration {
+	var wait time.Duration
+	for _, b := range buckets {
+		if w := b.Take(n); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+func refund(buckets []*Bucket, n float64) {
+	for _, b := range buckets {
+		b.Refund(n)
+	}
+}
+
+// Stats 该连接的统计
+func (c *ConnLimiter) Stats() Stats {
+	return c.counters.stats()
+}
+
+// Release 连接关闭时释放该连接占用的IP记录，重复调用无效
+func (c *ConnLimiter) Release() {
+	if !c.released.CompareAndSwap(false, true) {
+		return
+	}
+	l := c.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c.entry.refs--
+	if c.entry.refs == 0 && l.ips[c.ip] == c.entry {
+		delete(l.ips, c.ip)
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBucket(10, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	if w := b.Take(1); w != 0 {
+		t.Fatalf("unexpected wait %v", w)
+	}
+	if w := b.Take(1); w != 0 {
+		t.Fatalf("unexpected wait %v", w)
+	}
+	// 透支一个令牌，需要等待0.1秒补充
+	if w := b.Take(1); w != 100*time.Millisecond {
+		t.Fatalf("unexpected wait %v", w)
+	}
+	b.Refund(1)
+	now = now.Add(200 * time.Millisecond)
+	if w := b.Take(2); w != 0 {
+		t.Fatalf("unexpected wait %v after refill", w)
+	}
+	// 补充的令牌不超过burst
+	now = now.Add(time.Hour)
+	if w := b.Take(3); w != 100*time.Millisecond {
+		t.Fatalf("unexpected wait %v", w)
+	}
+
+	var unlimited *Bucket
+	if w := unlimited.Take(100); w != 0 {
+		t.Fatalf("nil bucket should not limit, got %v", w)
+	}
+	if w := NewBucket(0, 0).Take(100); w != 0 {
+		t.Fatalf("zero rate should not limit, got %v", w)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	conf := ConfigDefault()
+	conf.Action = Nack
+	conf.PerConn = Rate{Messages: 1, MessageBurst: 2}
+	conf.PerIP = Rate{Messages: 1, MessageBurst: 3}
+	conf.Global = Rate{Bytes: 1, ByteBurst: 100}
+	l := NewLimiter(conf)
+
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	a := l.Conn(addr)
+	b := l.Conn(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1001})
+	for i := 0; i < 2; i++ {
+		if violated, _ := a.Admit(10); violated {
+			t.Fatalf("message %d should be admitted", i)
+		}
+	}
+	// 超过连接限制
+	if violated, _ := a.Admit(10); !violated {
+		t.Fatal("per connection limit not enforced")
+	}
+	// 同一IP的另一个连接共享IP限制
+	if violated, _ := b.Admit(10); violated {
+		t.Fatal("message should be admitted")
+	}
+	if violated, _ := b.Admit(10); !violated {
+		t.Fatal("per ip limit not enforced")
+	}
+	// 其他IP只受全局限制，被拒绝的消息未消耗令牌
+	c := l.Conn(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1000})
+	if violated, _ := c.Admit(70); violated {
+		t.Fatal("message should be admitted")
+	}
+	if violated, _ := c.Admit(10); !violated {
+		t.Fatal("global limit not enforced")
+	}
+
+	if s := a.Stats(); s.Admitted != 2 || s.Rejected != 1 {
+		t.Errorf("unexpected conn stats %+v", s)
+	}
+	if s := l.Stats(); s.Admitted != 4 || s.Rejected != 3 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	ips := l.IPStats()
+	if s := ips["10.0.0.1"]; s.Admitted != 3 || s.Rejected != 2 {
+		t.Errorf("unexpected ip stats %+v", s)
+	}
+
+	// 同一IP的连接全部释放后移除IP记录，重复释放无效
+	a.Release()
+	a.Release()
+	if _, ok := l.IPStats()["10.0.0.1"]; !ok {
+		t.Fatal("ip entry removed while in use")
+	}
+	b.Release()
+	c.Release()
+	if len(l.IPStats()) != 0 {
+		t.Errorf("ip entries leaked: %v", l.IPStats())
+	}
+}
+
+func TestLimiterDelay(t *testing.T) {
+	conf := ConfigDefault()
+	conf.PerConn = Rate{Messages: 10, MessageBurst: 1}
+	l := NewLimiter(conf)
+	c := l.Conn(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	defer c.Release()
+
+	if violated, _ := c.Admit(0); violated {
+		t.Fatal("message should be admitted")
+	}
+	violated, wait := c.Admit(0)
+	if !violated || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("unexpected delay %v %v", violated, wait)
+	}
+	if s := c.Stats(); s.Delayed != 1 || s.DelayTotal != wait {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
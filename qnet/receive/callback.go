@@ -13,6 +13,10 @@ type CallBackHandler struct {
 	asyncACKLoop    []func(sender *send.QTPSender, data *qc.QTPData)
 	connectInitLoop []func(sender *send.QTPSender)
 	closedLoop      []func(err *errors.QError)
+	streamH         func(sender *send.QTPSender, stream *Stream)
+	channels        map[string]*ChannelHandler
+	router          router
+	inbound         []InboundInterceptor
 }
 
 // NewCallBackHandler 新建一个回调接收器
@@ -45,6 +49,21 @@ func (h *CallBackHandler) ConnClosed(f func(err *errors.QError)) {
 	h.closedLoop = append(h.closedLoop, f)
 }
 
+// Stream 设置分片传输回调，每个数据流在独立的协程中回调，重复设置时覆盖
+// stream仅在回调中有效，回调返回后未读取的分片会被丢弃；未设置回调时收到的分片全部丢弃
+func (h *CallBackHandler) Stream(f func(sender *send.QTPSender, stream *Stream)) {
+	h.streamH = f
+}
+
+// Channel 接受对端发起的名称为name的逻辑通道，通道内收到的消息交给handler处理，重复设置时覆盖
+// 未设置的名称会被拒绝
+func (h *CallBackHandler) Channel(name string, handler *ChannelHandler) {
+	if h.channels == nil {
+		h.channels = make(map[string]*ChannelHandler)
+	}
+	h.channels[name] = handler
+}
+
 // CallBacker 回调接收器
 type CallBacker struct {
 	Handler *CallBackHandler
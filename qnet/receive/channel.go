@@ -0,0 +1,141 @@
+package receive
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/send"
+	"strconv"
+)
+
+// ChannelHandler 逻辑通道的回调集合，每个通道在独立的协程中按消息顺序回调
+// NoACK与SyncACK回调在通道协程中依次执行，AsyncACK回调在独立的协程中执行；任意回调阻塞时只影响所在的通道
+type ChannelHandler struct {
+	openedLoop   []func(ch *send.Channel)
+	noACKLoop    []func(ch *send.Channel, data *qc.QTPData)
+	syncACKLoop  []func(ch *send.Channel, data *qc.QTPData)
+	asyncACKLoop []func(ch *send.Channel, data *qc.QTPData)
+	closedLoop   []func(ch *send.Channel, err *errors.QError)
+}
+
+// NewChannelHandler 新建一个逻辑通道回调集合
+func NewChannelHandler() *ChannelHandler {
+	return &ChannelHandler{}
+}
+
+// Opened 添加通道建立时回调
+func (h *ChannelHandler) Opened(f func(ch *send.Channel)) {
+	h.openedLoop = append(h.openedLoop, f)
+}
+
+// NoACK 添加NoACK回调
+func (h *ChannelHandler) NoACK(f func(ch *send.Channel, data *qc.QTPData)) {
+	h.noACKLoop = append(h.noACKLoop, f)
+}
+
+// SyncACK 添加同步确认回调
+func (h *ChannelHandler) SyncACK(f func(ch *send.Channel, data *qc.QTPData)) {
+	h.syncACKLoop = append(h.syncACKLoop, f)
+}
+
+// AsyncACK 添加异步确认回调
+func (h *ChannelHandler) AsyncACK(f func(ch *send.Channel, data *qc.QTPData)) {
+	h.asyncACKLoop = append(h.asyncACKLoop, f)
+}
+
+// Closed 添加通道关闭时回调，本端主动关闭时err为nil
+func (h *ChannelHandler) Closed(f func(ch *send.Channel, err *errors.QError)) {
+	h.closedLoop = append(h.closedLoop, f)
+}
+
+// OpenChannel 在sender所在的连接上向对端发起名称为name的逻辑通道，通道内收到的消息交给handler处理
+func OpenChannel(sender *send.QTPSender, name string, handler *ChannelHandler) (*send.Channel, *errors.QError) {
+	ch, err := sender.OpenChannel(name)
+	if err != nil {
+		return nil, err
+	}
+	go serveChannel(ch, handler)
+	return ch, nil
+}
+
+// 对端发起通道时根据名称选择回调并执行入站拦截器，未注册的名称与拦截器未放行的通道会被拒绝
+func (receiver *QTPReceiver) acceptChannel(ch *send.Channel) *qc.Nack {
+	handler := receiver.GetCallBacker().Handler.channels[ch.Name()]
+	if handler == nil {
+		return &qc.Nack{Reason: "未注册名称为" + ch.Name() + "的通道"}
+	}
+	if nack, ok := receiver.admitChannel(ch); !ok {
+		return &nack
+	}
+	go serveChannel(ch, handler)
+	return nil
+}
+
+// 以不含数据的消息代表对端发起的通道交给入站拦截器，路由为通道名称
+func (receiver *QTPReceiver) admitChannel(ch *send.Channel) (qc.Nack, bool) {
+	data := &qc.QTPData{Header: qc.QTPHeader{Version: receiver.GetCallBacker().Sender.Version(), MsgType: qc.DATA, ACKType: qc.SyncACK}}
+	data.Header.Extensions.SetRoute(ch.Name())
+	data.Header.Extensions.SetChannel(ch.ID())
+	if receiver.GetCallBacker().Handler.admit(receiver.GetCallBacker().Sender, data, "通道"+ch.Name()+"被入站拦截器拒绝") {
+		return qc.Nack{}, true
+	}
+	nack, _ := data.Rejected()
+	return nack, false
+}
+
+// 将属于逻辑通道的消息交给对应的通道，通道不存在时丢弃并应答NACK
+func (receiver *QTPReceiver) channelData(data *qc.QTPData) {
+	if receiver.GetCallBacker().Sender.DeliverChannel(data) {
+		return
+	}
+	id, _ := data.Channel()
+	reason := "通道" + strconv.FormatUint(uint64(id), 10) + "不存在"
+	receiver.GetLogger().Warn("丢弃了不存在的通道中的消息，" + reason)
+	if data.Header.ACKType == qc.NoACK {
+		receiver.release(data)
+		return
+	}
+	data.Reject(reason)
+	receiver.finish(data)
+}
+
+// 依次处理通道内的消息，直至通道关闭
+func serveChannel(ch *send.Channel, handler *ChannelHandler) {
+	for _, f := range handler.openedLoop {
+		f(ch)
+	}
+	sender := ch.Sender()
+	release := func(data *qc.QTPData) {
+		sender.GetQTPReader().Consumed(data)
+		data.Release()
+	}
+	for {
+		data, ok := ch.Next()
+		if !ok {
+			break
+		}
+		switch data.Header.ACKType {
+		case qc.NoACK:
+			for _, f := range handler.noACKLoop {
+				f(ch, data)
+			}
+			release(data)
+		case qc.SyncACK:
+			for _, f := range handler.syncACKLoop {
+				f(ch, data)
+			}
+			finish(sender, data)
+		case qc.AsyncACK:
+			go func(data *qc.QTPData) {
+				for _, f := range handler.asyncACKLoop {
+					f(ch, data)
+				}
+				finish(sender, data)
+			}(data)
+		default:
+			release(data)
+		}
+	}
+	for _, f := range handler.closedLoop {
+		f(ch, ch.Err())
+	}
+}
@@ -0,0 +1,59 @@
+package receive
+
+import (
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/codec"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/send"
+)
+
+// Context 类型化回调的上下文
+type Context struct {
+	// 消息所在连接的QTPSender
+	Sender *send.QTPSender
+	// 原始消息，仅在回调中有效
+	Data *qc.QTPData
+}
+
+// Header 消息头
+func (c *Context) Header() qc.QTPHeader {
+	return c.Data.Header
+}
+
+// Route 消息路由名称
+func (c *Context) Route() string {
+	return c.Data.Route()
+}
+
+// Principal 消息所在连接认证通过的主体，未认证时返回nil
+func (c *Context) Principal() *auth.Principal {
+	return c.Sender.Principal()
+}
+
+// Nack 拒绝处理该消息，回调结束后以NACK应答发送方
+func (c *Context) Nack(reason string) {
+	c.Data.Reject(reason)
+}
+
+// Handle 添加一个类型化的回调，按消息头中的编码方式将数据解码为T后回调，NoACK、SyncACK与AsyncACK消息都会回调
+// 编码方式未注册或解码失败时不回调，并以NACK应答发送方
+// 同一个CallBackHandler上的所有Handle都会收到全部消息，收到不同类型的消息时使用HandleRoute按路由区分
+func Handle[T any](h *CallBackHandler, f func(ctx *Context, v T)) {
+	callback := typed(f)
+	h.NoACK(callback)
+	h.SyncACK(callback)
+	h.AsyncACK(callback)
+}
+
+// 将类型化的回调包装为普通回调
+func typed[T any](f func(ctx *Context, v T)) func(sender *send.QTPSender, data *qc.QTPData) {
+	return func(sender *send.QTPSender, data *qc.QTPData) {
+		ctx := &Context{Sender: sender, Data: data}
+		var v T
+		if err := codec.Unmarshal(data.Header.Encode, data.Data, &v); err != nil {
+			ctx.Nack("消息解码失败：" + err.Error())
+			return
+		}
+		f(ctx, v)
+	}
+}
@@ -0,0 +1,80 @@
+package receive
+
+import (
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/send"
+	"fmt"
+)
+
+// InboundHandler 入站消息的处理函数
+type InboundHandler func(sender *send.QTPSender, data *qc.QTPData)
+
+// InboundInterceptor 入站拦截器，在消息交给NoACK、SyncACK、AsyncACK回调与路由之前执行
+// 调用next继续处理消息；不调用next时消息不再交给后续的拦截器与回调，此时可通过data.Reject以NACK拒绝消息
+// 拦截器的执行方式与消息的ACK类型对应，与回调在同一个协程中执行
+// 数据流在收到第一个分片时经过拦截器，data为第一个分片，路由为数据流的路由；拦截器在接收协程中执行，不应阻塞
+// 对端发起逻辑通道时经过拦截器，data不含数据，路由为通道名称，data.Channel()返回通道ID
+// 未放行的数据流与逻辑通道被拒绝，未调用data.Reject时以默认原因拒绝；数据流的其他分片与逻辑通道内的消息不经过拦截器
+type InboundInterceptor func(sender *send.QTPSender, data *qc.QTPData, next InboundHandler)
+
+// Use 按顺序添加入站拦截器，先添加的拦截器在外层，需在连接建立前调用
+func (h *CallBackHandler) Use(interceptors ...InboundInterceptor) {
+	h.inbound = append(h.inbound, interceptors...)
+}
+
+// 依次执行入站拦截器，最后执行回调与路由
+func (h *CallBackHandler) handle(sender *send.QTPSender, data *qc.QTPData) {
+	h.intercept(0, sender, data, h.callbacks)
+}
+
+// 对数据流的第一个分片或对端发起的逻辑通道执行入站拦截器，返回是否放行，未放行且未拒绝时以reason拒绝
+func (h *CallBackHandler) admit(sender *send.QTPSender, data *qc.QTPData, reason string) bool {
+	admitted := false
+	h.intercept(0, sender, data, func(sender *send.QTPSender, data *qc.QTPData) {
+		admitted = true
+	})
+	if _, rejected := data.Rejected(); !admitted && !rejected {
+		data.Reject(reason)
+	}
+	return admitted
+}
+
+func (h *CallBackHandler) intercept(i int, sender *send.QTPSender, data *qc.QTPData, last InboundHandler) {
+	if i == len(h.inbound) {
+		last(sender, data)
+		return
+	}
+	h.inbound[i](sender, data, func(sender *send.QTPSender, data *qc.QTPData) {
+		h.intercept(i+1, sender, data, last)
+	})
+}
+
+// 执行消息ACK类型对应的回调，随后按路由分发
+func (h *CallBackHandler) callbacks(sender *send.QTPSender, data *qc.QTPData) {
+	var loop []func(sender *send.QTPSender, data *qc.QTPData)
+	switch data.Header.ACKType {
+	case qc.NoACK:
+		loop = h.noACKLoop
+	case qc.SyncACK:
+		loop = h.syncACKLoop
+	case qc.AsyncACK:
+		loop = h.asyncACKLoop
+	}
+	for _, f := range loop {
+		f(sender, data)
+	}
+	h.router.dispatch(sender, data)
+}
+
+// Recover 将回调中的panic转换为NACK的入站拦截器，避免单个消息导致进程退出
+func Recover() InboundInterceptor {
+	return func(sender *send.QTPSender, data *qc.QTPData, next InboundHandler) {
+		defer func() {
+			if r := recover(); r != nil {
+				sender.GetLogger().Error(fmt.Sprintf("回调处理消息时发生panic，Seq:%d，%v", data.Header.Seq, r))
+				data.Reject(fmt.Sprintf("回调处理消息时发生异常：%v", r))
+			}
+		}()
+		next(sender, data)
+	}
+}
@@ -5,21 +5,16 @@ import (
 	"QuantumUtils/goroutine"
 	"QuantumUtils/logger"
 	"QuantumUtils/qnet/connect"
+	"QuantumUtils/qnet/guard"
 	"QuantumUtils/qnet/qc"
-	v1 "QuantumUtils/qnet/qc/v1"
 	"QuantumUtils/qnet/qio"
-	"strconv"
+	"QuantumUtils/qnet/ratelimit"
+	"QuantumUtils/qnet/send"
+	"net"
+	"sync"
 	"time"
 )
 
-type seqSeq struct {
-	Seq uint64
-}
-
-func (s seqSeq) NextSeq() uint64 {
-	return s.Seq
-}
-
 // QTPReceiver QTP消息接收器
 type QTPReceiver struct {
 	connect.QTPConnAccessor
@@ -27,6 +22,14 @@ type QTPReceiver struct {
 	qio.QTPReaderAccessor
 	qio.QTPWriterAccessor
 	CallBackerAccessor
+	// 接收中的数据流
+	streams map[uint64]*receiveStream
+	// 保护streams，连接重连时会在其他协程中结束数据流
+	streamMu sync.Mutex
+	// 连接的速率限制器，为nil时不限制
+	limiter *ratelimit.ConnLimiter
+	// 连接的准入记录，为nil时未启用准入控制
+	guard *guard.Conn
 }
 
 func (receiver *QTPReceiver) handleData() {
@@ -38,6 +41,23 @@ func (receiver *QTPReceiver) handleData() {
 				if !ok {
 					return
 				}
+				if admitted, err := receiver.admit(qtpData); err != nil {
+					// 超过速率限制，断开连接
+					_ = receiver.GetQTPConn().Close()
+					receiver.shutdown(err)
+					receiver.Discard()
+					return
+				} else if !admitted {
+					break
+				}
+				if chunk, ok := qtpData.Stream(); ok {
+					receiver.streamChunk(qtpData, chunk)
+					break
+				}
+				if _, ok := qtpData.Channel(); ok {
+					receiver.channelData(qtpData)
+					break
+				}
 				switch qtpData.Header.ACKType {
 				case qc.NoACK:
 					{
@@ -56,54 +76,109 @@ func (receiver *QTPReceiver) handleData() {
 					}
 				}
 			}
-		case <-time.After(100 * time.Millisecond):
+		case err := <-receiver.GetQTPReader().ErrorChan:
 			{
-				select {
-				case err := <-receiver.GetQTPReader().ErrorChan:
-					{
-						receiver.GetQTPWriter().Close()
-						receiver.connClosed(err)
-						return
-					}
-				default:
-					continue
-
-				}
+				receiver.shutdown(err)
+				return
 			}
 
 		}
 	}
 }
 
+// 连接断开后清理连接上的资源并回调ConnClosed
+func (receiver *QTPReceiver) shutdown(err *errors.QError) {
+	receiver.GetQTPWriter().Close()
+	receiver.closeStreams(err)
+	receiver.GetCallBacker().Sender.CloseStreams(err)
+	receiver.GetCallBacker().Sender.CloseChannels(err)
+	if receiver.limiter != nil {
+		receiver.limiter.Release()
+	}
+	receiver.guard.Close(err)
+	receiver.connClosed(err)
+}
+
+// 检查消息速率，超过限制时按配置的方式处理：延迟后返回true，拒绝时返回false，需要断开连接时返回异常
+func (receiver *QTPReceiver) admit(data *qc.QTPData) (bool, *errors.QError) {
+	if receiver.limiter == nil {
+		return true, nil
+	}
+	violated, wait := receiver.limiter.Admit(int(data.Header.DataLength))
+	if !violated {
+		return true, nil
+	}
+	switch receiver.limiter.Action() {
+	case ratelimit.Delay:
+		// 暂停处理该连接的消息，未处理的消息积压后Reader停止读取
+		time.Sleep(wait)
+		return true, nil
+	case ratelimit.Nack:
+		if data.Header.ACKType == qc.NoACK {
+			receiver.release(data)
+			return false, nil
+		}
+		data.RejectWith(qc.Nack{Reason: "消息速率超过限制", Code: qc.NackRateLimited})
+		receiver.finish(data)
+		return false, nil
+	default:
+		receiver.release(data)
+		return false, errors.NewKind(qc.RateLimitError, "消息速率超过限制，断开连接")
+	}
+}
+
+// SetLimiter 设置连接的速率限制器，连接断开时释放，需在Start前调用
+func (receiver *QTPReceiver) SetLimiter(limiter *ratelimit.ConnLimiter) {
+	receiver.limiter = limiter
+}
+
+// SetGuard 设置连接的准入记录，连接断开时以断开的异常关闭，需在Start前调用
+func (receiver *QTPReceiver) SetGuard(conn *guard.Conn) {
+	receiver.guard = conn
+}
+
 func (receiver *QTPReceiver) noACK(data *qc.QTPData) {
 	go func() {
-		for _, f := range receiver.GetCallBacker().Handler.noACKLoop {
-			f(receiver.GetCallBacker().Sender, data)
-		}
+		defer receiver.release(data)
+		receiver.GetCallBacker().Handler.handle(receiver.GetCallBacker().Sender, data)
 	}()
 }
 func (receiver *QTPReceiver) syncACK(data *qc.QTPData) {
-	for _, f := range receiver.GetCallBacker().Handler.syncACKLoop {
-		f(receiver.GetCallBacker().Sender, data)
-	}
-	receiver.sendACK(data.Header.Seq)
-
+	receiver.GetCallBacker().Handler.handle(receiver.GetCallBacker().Sender, data)
+	receiver.finish(data)
 }
 
 func (receiver *QTPReceiver) asyncACK(data *qc.QTPData) {
-	fc := make(chan bool)
-	go func() {
-		for _, f := range receiver.GetCallBacker().Handler.asyncACKLoop {
-			f(receiver.GetCallBacker().Sender, data)
-		}
-		fc <- true
-	}()
 	go func() {
-		<-fc
-		receiver.sendACK(data.Header.Seq)
+		receiver.GetCallBacker().Handler.handle(receiver.GetCallBacker().Sender, data)
+		receiver.finish(data)
 	}()
 
 }
+
+// 消息处理完毕，归还缓冲后应答ACK，回调拒绝了消息时应答NACK
+func (receiver *QTPReceiver) finish(data *qc.QTPData) {
+	finish(receiver.GetCallBacker().Sender, data)
+}
+
+func finish(sender *send.QTPSender, data *qc.QTPData) {
+	dataSeq := data.Header.Seq
+	nack, rejected := data.Rejected()
+	sender.GetQTPReader().Consumed(data)
+	data.Release()
+	if rejected {
+		sender.SendNack(dataSeq, nack)
+		return
+	}
+	sender.SendACK(dataSeq)
+}
+
+// 消息处理完毕，归还缓冲额度与池化缓冲
+func (receiver *QTPReceiver) release(data *qc.QTPData) {
+	receiver.GetQTPReader().Consumed(data)
+	data.Release()
+}
+
 func (receiver *QTPReceiver) connClosed(err *errors.QError) {
 	for _, f := range receiver.GetCallBacker().Handler.closedLoop {
 		f(err)
@@ -115,36 +190,8 @@ func (receiver *QTPReceiver) connInit() {
 	}
 }
 
-// ack消息的配置
-var ackConf = qc.QTPConfig{
-	Encode:  qc.BINARY,
-	MsgType: qc.ACK,
-	ACKType: qc.NoACK,
-}
-
-func (receiver *QTPReceiver) ackLCE(dataSeq uint64, err *errors.QError) {
-	if err != nil {
-		err.WithMessage("ACK消息未成功发送,Seq:" + strconv.FormatUint(dataSeq, 10))
-		receiver.GetLogger().Warn(err.ErrorStackMessage())
-	}
-}
-
 func (receiver *QTPReceiver) sendACK(dataSeq uint64) {
-	seqG := seqSeq{Seq: dataSeq}
-	encoder := v1.NewQMsgEncoder(&seqG)
-	_, ackByte, err := encoder.Encode(make([]byte, 0), ackConf)
-	if err != nil {
-		err.WithMessage("ACK消息未成功发送,Seq:" + strconv.FormatUint(dataSeq, 10))
-		receiver.GetLogger().Warn(err.ErrorStackMessage())
-		return
-	}
-	sendR := &qio.SendReq{
-		SeqN:   dataSeq,
-		Data:   ackByte,
-		Config: ackConf,
-		LCE:    receiver.ackLCE,
-	}
-	receiver.GetQTPWriter().SendChan <- sendR
+	receiver.GetCallBacker().Sender.SendACK(dataSeq)
 }
 
 // Start 开启receiver(async)
@@ -163,11 +210,38 @@ func (receiver *QTPReceiver) Start() {
 		func() any {
 			return receiver.GetCallBacker()
 		})
+	receiver.GetCallBacker().Sender.SetChannelAcceptor(receiver.acceptChannel)
 	receiver.connInit()
 
 	go receiver.handleData()
 }
 
+// Reconnected 连接重连后调用，结束接收中的数据流，对端在新连接上不会继续发送这些数据流的分片
+func (receiver *QTPReceiver) Reconnected(conn net.Conn) {
+	receiver.closeStreams(errors.New("连接已重连，分片传输中止"))
+}
+
+// Discard 丢弃连接上收到的所有消息直到连接断开，用于未通过认证的连接，调用后不能再调用Start
+func (receiver *QTPReceiver) Discard() {
+	go func() {
+		for {
+			select {
+			case data, ok := <-receiver.GetQTPReader().DATAChan:
+				if !ok {
+					return
+				}
+				receiver.release(data)
+			case err := <-receiver.GetQTPReader().ErrorChan:
+				if receiver.limiter != nil {
+					receiver.limiter.Release()
+				}
+				receiver.guard.Close(err)
+				return
+			}
+		}
+	}()
+}
+
 // NewQTPReceiver 创建一个QTPReceiver
 func NewQTPReceiver() *QTPReceiver {
 	return &QTPReceiver{}
@@ -0,0 +1,132 @@
+package receive
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/send"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 路由按消息头扩展中的路由名称（见qc.Extensions.SetRoute）选择回调
+// 路由规则可以是完整的路由名称，也可以是以".*"结尾的前缀规则（如"user.*"匹配"user.login"与"user.profile.get"），单独的"*"匹配所有路由
+// 完整名称优先于前缀规则，前缀规则之间较长者优先；没有匹配的路由时交给Fallback，未设置Fallback时以NACK应答发送方
+
+// 路由回调
+type routeHandler func(sender *send.QTPSender, data *qc.QTPData)
+
+// 前缀路由
+type prefixRoute struct {
+	pattern string
+	prefix  string
+	f       routeHandler
+}
+
+// 路由表，可在运行中增删路由
+type router struct {
+	mu       sync.RWMutex
+	exact    map[string]routeHandler
+	prefixes []prefixRoute
+	fallback routeHandler
+}
+
+// 是否设置了任意路由或Fallback
+func (r *router) enabled() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.exact) > 0 || len(r.prefixes) > 0 || r.fallback != nil
+}
+
+func (r *router) add(pattern string, f routeHandler) *errors.QError {
+	if err := qc.CheckRoutePattern(pattern); err != nil {
+		return err
+	}
+	wildcard := strings.Index(pattern, "*")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if wildcard < 0 {
+		if r.exact == nil {
+			r.exact = make(map[string]routeHandler)
+		}
+		r.exact[pattern] = f
+		return nil
+	}
+	r.removePrefix(pattern)
+	r.prefixes = append(r.prefixes, prefixRoute{pattern: pattern, prefix: strings.TrimSuffix(pattern, "*"), f: f})
+	sort.SliceStable(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+	return nil
+}
+
+func (r *router) remove(pattern string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.exact[pattern]; ok {
+		delete(r.exact, pattern)
+		return true
+	}
+	return r.removePrefix(pattern)
+}
+
+func (r *router) removePrefix(pattern string) bool {
+	for i, route := range r.prefixes {
+		if route.pattern == pattern {
+			r.prefixes = append(r.prefixes[:i:i], r.prefixes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 查找路由对应的回调，没有匹配的路由时返回Fallback
+func (r *router) match(route string) routeHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if f, ok := r.exact[route]; ok {
+		return f
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(route, prefix.prefix) {
+			return prefix.f
+		}
+	}
+	return r.fallback
+}
+
+// 按路由分发消息，没有匹配的路由时拒绝消息
+func (r *router) dispatch(sender *send.QTPSender, data *qc.QTPData) {
+	if !r.enabled() {
+		return
+	}
+	if f := r.match(data.Route()); f != nil {
+		f(sender, data)
+		return
+	}
+	data.RejectWith(qc.Nack{Reason: "路由" + data.Route() + "不存在", Code: qc.NackRouteNotFound})
+}
+
+// Route 添加或替换路由规则pattern的回调，可在运行中调用，规则格式见包说明
+// 回调的执行方式与消息的ACK类型对应（NoACK在独立协程中、SyncACK依次、AsyncACK在独立协程中），并在NoACK、SyncACK、AsyncACK回调之后执行
+// 设置了任意路由后，没有匹配路由且未设置Fallback的消息会以qc.RouteNotFoundError拒绝
+func (h *CallBackHandler) Route(pattern string, f func(sender *send.QTPSender, data *qc.QTPData)) *errors.QError {
+	return h.router.add(pattern, f)
+}
+
+// RemoveRoute 删除路由规则pattern，可在运行中调用，规则不存在时返回false
+func (h *CallBackHandler) RemoveRoute(pattern string) bool {
+	return h.router.remove(pattern)
+}
+
+// Fallback 设置没有匹配路由的消息的回调，为nil时取消，可在运行中调用
+func (h *CallBackHandler) Fallback(f func(sender *send.QTPSender, data *qc.QTPData)) {
+	h.router.mu.Lock()
+	defer h.router.mu.Unlock()
+	h.router.fallback = f
+}
+
+// HandleRoute 添加一个类型化的路由回调，解码方式与失败时的处理见Handle
+func HandleRoute[T any](h *CallBackHandler, pattern string, f func(ctx *Context, v T)) *errors.QError {
+	return h.Route(pattern, typed(f))
+}
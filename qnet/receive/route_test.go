@@ -0,0 +1,57 @@
+package receive
+
+import (
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/send"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	h := NewCallBackHandler()
+	var hit string
+	route := func(name string) func(sender *send.QTPSender, data *qc.QTPData) {
+		return func(sender *send.QTPSender, data *qc.QTPData) { hit = name }
+	}
+	for _, pattern := range []string{"user.login", "user.*", "user.profile.*", "*"} {
+		if err := h.Route(pattern, route(pattern)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pattern := range []string{"", "user*", "*.login", "user.*.get"} {
+		if err := h.Route(pattern, route(pattern)); err == nil {
+			t.Errorf("expected pattern %q to be rejected", pattern)
+		}
+	}
+	cases := map[string]string{
+		"user.login":       "user.login",
+		"user.logout":      "user.*",
+		"user.profile.get": "user.profile.*",
+		"order.create":     "*",
+		"user":             "*",
+	}
+	for name, want := range cases {
+		hit = ""
+		h.router.match(name)(nil, nil)
+		if hit != want {
+			t.Errorf("route %q matched %q, want %q", name, hit, want)
+		}
+	}
+
+	if !h.RemoveRoute("*") || h.RemoveRoute("*") {
+		t.Error("unexpected RemoveRoute result")
+	}
+	data := &qc.QTPData{}
+	data.Header.Extensions.SetRoute("order.create")
+	h.router.dispatch(nil, data)
+	if nack, ok := data.Rejected(); !ok || nack.Code != qc.NackRouteNotFound {
+		t.Errorf("expected route-not-found, got %+v", nack)
+	}
+	h.Fallback(route("fallback"))
+	data = &qc.QTPData{}
+	data.Header.Extensions.SetRoute("order.create")
+	hit = ""
+	h.router.dispatch(nil, data)
+	if _, rejected := data.Rejected(); rejected || hit != "fallback" {
+		t.Errorf("expected fallback, got %q", hit)
+	}
+}
@@ -0,0 +1,230 @@
+package receive
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Stream 分片传输的数据流，按顺序读取对端通过SendStream发送的数据
+// 每个分片被读取完毕后才会向对端归还发送额度，读取缓慢时对端的发送会被阻塞
+type Stream struct {
+	// 第一个分片的消息头，可从中获取编码方式与消息头扩展
+	Header qc.QTPHeader
+	id     uint64
+	mu     sync.Mutex
+	cond   *sync.Cond
+	// 已收到但尚未读取的分片
+	chunks []*qc.QTPData
+	// 正在读取的分片
+	current *qc.QTPData
+	offset  int
+	// 已收到最后一个分片
+	end bool
+	// 读取结束后返回的异常，为nil时返回io.EOF
+	err error
+	// 不再读取，之后收到的分片直接丢弃
+	discarded bool
+	// 分片读取完毕后调用，归还缓冲与发送额度
+	consumed func(data *qc.QTPData)
+}
+
+func newStream(id uint64, header qc.QTPHeader, consumed func(data *qc.QTPData)) *Stream {
+	header.Extensions = header.Extensions.Clone()
+	header.Extensions.Del(qc.ExtStream)
+	s := &Stream{
+		Header:   header,
+		id:       id,
+		consumed: consumed,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// ID 数据流ID
+func (s *Stream) ID() uint64 {
+	return s.id
+}
+
+// Read 实现io.Reader，数据流正常结束时返回io.EOF，对端中止或连接断开时返回对应的异常
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		if s.current != nil {
+			n := copy(p, s.current.Data[s.offset:])
+			s.offset += n
+			if s.offset == len(s.current.Data) {
+				s.consumed(s.current)
+				s.current = nil
+			}
+			if n > 0 || len(p) == 0 {
+				return n, nil
+			}
+			continue
+		}
+		s.mu.Lock()
+		for len(s.chunks) == 0 && !s.end && s.err == nil {
+			s.cond.Wait()
+		}
+		if len(s.chunks) == 0 {
+			err := s.err
+			s.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		s.current = s.chunks[0]
+		s.chunks[0] = nil
+		s.chunks = s.chunks[1:]
+		s.offset = 0
+		s.mu.Unlock()
+	}
+}
+
+// 加入一个分片
+func (s *Stream) push(data *qc.QTPData, chunk qc.StreamChunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if chunk.Aborted() {
+		s.err = errors.New("对端中止了分片传输")
+	}
+	if chunk.End() {
+		s.end = true
+	}
+	if s.discarded {
+		s.consumed(data)
+	} else {
+		s.chunks = append(s.chunks, data)
+	}
+	s.cond.Broadcast()
+}
+
+// 以异常结束数据流，已收到的分片依然可以被读取
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && !s.end {
+		s.err = err
+	}
+	s.end = true
+	s.cond.Broadcast()
+}
+
+// 回调结束后丢弃未读取的分片，使对端的发送不被阻塞
+func (s *Stream) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discarded = true
+	if s.current != nil {
+		s.consumed(s.current)
+		s.current = nil
+	}
+	for _, data := range s.chunks {
+		s.consumed(data)
+	}
+	s.chunks = nil
+}
+
+// 处理分片消息，分片在收到后立即确认，在被读取后归还发送额度
+func (receiver *QTPReceiver) streamChunk(data *qc.QTPData, chunk qc.StreamChunk) {
+	if chunk.Index == 0 && data.Header.MsgType != qc.RETRY {
+		handler := receiver.GetCallBacker().Handler
+		if !handler.admit(receiver.GetCallBacker().Sender, data, "数据流被入站拦截器拒绝") {
+			// 以NACK应答第一个分片，发送方随即中止数据流，已发出的分片因数据流不存在而被丢弃
+			receiver.finish(data)
+			return
+		}
+	}
+	dataSeq := data.Header.Seq
+	defer receiver.sendACK(dataSeq)
+	rs, opened, expected := receiver.trackChunk(data, chunk)
+	if rs == nil {
+		// 重发的分片
+		receiver.release(data)
+		return
+	}
+	if opened {
+		receiver.startStream(rs.stream)
+	}
+	if !expected {
+		receiver.release(data)
+		rs.stream.fail(errors.New("分片传输失败，缺少序号为" + strconv.FormatUint(uint64(rs.next), 10) + "的分片"))
+		return
+	}
+	rs.stream.push(data, chunk)
+}
+
+// 在streams中记录分片，返回分片所属的数据流、是否为新数据流，以及分片是否为下一个需要的分片
+// 重发的分片返回nil，分片不连续时结束数据流；推送分片与启动回调在锁外进行，避免归还额度时阻塞重连
+func (receiver *QTPReceiver) trackChunk(data *qc.QTPData, chunk qc.StreamChunk) (*receiveStream, bool, bool) {
+	receiver.streamMu.Lock()
+	defer receiver.streamMu.Unlock()
+	if receiver.streams == nil {
+		receiver.streams = make(map[uint64]*receiveStream)
+	}
+	rs := receiver.streams[chunk.ID]
+	opened := false
+	if rs == nil {
+		// 同一连接上的重发说明原分片已送达，未知数据流的重发分片来自已结束的数据流或重连前的旧连接
+		if chunk.Index != 0 || data.Header.MsgType == qc.RETRY {
+			return nil, false, false
+		}
+		rs = receiver.openStream(data, chunk)
+		opened = true
+	}
+	if chunk.Index < rs.next {
+		return nil, false, false
+	}
+	if chunk.Index > rs.next {
+		delete(receiver.streams, chunk.ID)
+		return rs, opened, false
+	}
+	rs.next++
+	if chunk.End() {
+		delete(receiver.streams, chunk.ID)
+	}
+	return rs, opened, true
+}
+
+// 接收中的数据流
+type receiveStream struct {
+	stream *Stream
+	// 下一个分片的序号
+	next uint32
+}
+
+// 创建数据流并记录在streams中，需持有streamMu
+func (receiver *QTPReceiver) openStream(data *qc.QTPData, chunk qc.StreamChunk) *receiveStream {
+	sender := receiver.GetCallBacker().Sender
+	stream := newStream(chunk.ID, data.Header, func(data *qc.QTPData) {
+		receiver.release(data)
+		sender.GrantStream(chunk.ID, 1)
+	})
+	rs := &receiveStream{stream: stream}
+	receiver.streams[chunk.ID] = rs
+	return rs
+}
+
+// 在新协程中将数据流交给回调
+func (receiver *QTPReceiver) startStream(stream *Stream) {
+	sender := receiver.GetCallBacker().Sender
+	handler := receiver.GetCallBacker().Handler.streamH
+	go func() {
+		defer stream.discard()
+		if handler != nil {
+			handler(sender, stream)
+		}
+	}()
+}
+
+// 连接断开或重连时结束所有接收中的数据流
+func (receiver *QTPReceiver) closeStreams(err *errors.QError) {
+	receiver.streamMu.Lock()
+	defer receiver.streamMu.Unlock()
+	for id, rs := range receiver.streams {
+		rs.stream.fail(err)
+		delete(receiver.streams, id)
+	}
+}
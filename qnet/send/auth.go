@@ -0,0 +1,118 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/qc"
+	"time"
+)
+
+// Authenticate 以认证器a与对端完成认证握手，认证通过后记录并返回主体，由服务端在处理业务消息前调用
+// 认证失败或超过AuthTimeout未收到应答时通知对端并返回qc.AuthError类型的异常，调用方需随后关闭连接
+// 认证通过后在通知对端之前调用连接Reader的Authenticated，开始接受业务消息
+func (sender *QTPSender) Authenticate(a auth.Authenticator) (*auth.Principal, *errors.QError) {
+	challenge, err := a.Challenge()
+	if err != nil {
+		return nil, err
+	}
+	sender.challenged.Store(true)
+	defer sender.challenged.Store(false)
+	sender.sendControl(qc.ControlAuthChallenge, qc.AuthChallenge{Method: a.Method(), Challenge: challenge})
+	timer := time.NewTimer(sender.conf.AuthTimeout)
+	defer timer.Stop()
+	var principal *auth.Principal
+	select {
+	case response := <-sender.authResponses:
+		if response.Method != a.Method() {
+			err = errors.NewKind(qc.AuthError, "认证方式不匹配，需要"+a.Method())
+			break
+		}
+		principal, err = a.Verify(challenge, response)
+	case <-timer.C:
+		err = errors.NewKind(qc.AuthError, "等待对端认证应答超时")
+	}
+	if err != nil {
+		sender.sendControl(qc.ControlAuthResult, qc.AuthResult{Reason: err.Error()})
+		return nil, err
+	}
+	sender.principal.Store(principal)
+	// 对端收到认证结果后才会发送业务消息
+	if reader := sender.GetQTPReader(); reader != nil {
+		reader.Authenticated()
+	}
+	sender.sendControl(qc.ControlAuthResult, qc.AuthResult{OK: true, Principal: principal.Name})
+	return principal, nil
+}
+
+// Principal 获取连接认证通过的主体，服务端未配置认证器或在客户端调用时返回nil
+func (sender *QTPSender) Principal() *auth.Principal {
+	return sender.principal.Load()
+}
+
+// WaitAuth 等待服务端的认证结果，认证失败或超过AuthTimeout未收到结果时返回qc.AuthError类型的异常
+// 由配置了Credential的客户端在连接建立后调用
+func (sender *QTPSender) WaitAuth() *errors.QError {
+	timer := time.NewTimer(sender.conf.AuthTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-sender.authResults:
+		if !result.OK {
+			return errors.NewKind(qc.AuthError, "认证失败："+result.Reason)
+		}
+		return nil
+	case <-timer.C:
+		return errors.NewKind(qc.AuthError, "等待对端认证结果超时")
+	}
+}
+
+// 以配置的凭据应答服务端的认证质询，未配置凭据或认证方式不匹配时发送空应答，由服务端判定认证失败
+func (sender *QTPSender) onAuthChallenge(challenge qc.AuthChallenge) {
+	response := qc.AuthResponse{}
+	credential := sender.conf.Credential
+	switch {
+	case credential == nil:
+		sender.GetLogger().Warn("对端要求认证，但未配置凭据")
+	case credential.Method() != challenge.Method:
+		sender.GetLogger().Warn("对端要求的认证方式" + challenge.Method + "与凭据不匹配")
+		response.Method = credential.Method()
+	default:
+		r, err := credential.Respond(challenge.Challenge)
+		if err != nil {
+			sender.GetLogger().Warn(err.Error())
+			response.Method = credential.Method()
+			break
+		}
+		response = r
+	}
+	sender.sendControl(qc.ControlAuthResponse, response)
+}
+
+// 只接受对未完成的认证质询的第一个应答，没有发出质询时收到的应答被忽略
+func (sender *QTPSender) onAuthResponse(response qc.AuthResponse) {
+	if !sender.challenged.CompareAndSwap(true, false) {
+		sender.GetLogger().Warn("没有等待应答的认证质询，忽略了对端的认证应答")
+		return
+	}
+	select {
+	case sender.authResponses <- response:
+	default:
+	}
+}
+
+// 记录服务端的认证结果，只保留最近一次的结果
+func (sender *QTPSender) onAuthResult(result qc.AuthResult) {
+	if !result.OK {
+		sender.GetLogger().Warn("认证失败：" + result.Reason)
+	}
+	for {
+		select {
+		case sender.authResults <- result:
+			return
+		default:
+		}
+		select {
+		case <-sender.authResults:
+		default:
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	v2 "QuantumUtils/qnet/qc/v2"
+	"QuantumUtils/qnet/qio"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ChannelConfig 逻辑通道配置
+type ChannelConfig struct {
+	// 等待对端应答通道请求的最长时间
+	OpenTimeout time.Duration
+	// 每个通道已收到但尚未处理的消息数量上限，达到上限时会阻塞整个连接的接收
+	QueueCap int
+	// 每个通道等待发送的消息数量上限，达到上限时通道的发送方法阻塞
+	SendCap int
+}
+
+// ChannelConfigDefault Get默认逻辑通道配置
+func ChannelConfigDefault() ChannelConfig {
+	return ChannelConfig{
+		OpenTimeout: 5 * time.Second,
+		QueueCap:    100,
+		SendCap:     100,
+	}
+}
+
+// Channel 逻辑通道，在连接上承载一个独立的会话
+// 通道内的消息按发送顺序依次处理，SyncACK消息只阻塞所在的通道，不影响连接上的其他消息
+type Channel struct {
+	sender *QTPSender
+	// 本端发送时使用的通道ID，由本端接受的通道包含ChannelAcceptor位
+	id   uint32
+	name string
+	// 收到的消息
+	incoming chan *qc.QTPData
+	// 发送请求
+	sqc chan *qio.SendReq
+	// 已提交但生命周期尚未结束的消息
+	inflight sync.WaitGroup
+	// 发起方等待的对端应答
+	accepted chan qc.ChannelAccept
+	// 通道关闭后关闭
+	closed chan struct{}
+	// 发送协程退出后关闭
+	sendDone chan struct{}
+	// 保护done，保证关闭后不会再有消息进入incoming与sqc
+	mu   sync.RWMutex
+	done bool
+	err  *errors.QError
+}
+
+func (sender *QTPSender) newChannel(id uint32, name string) *Channel {
+	ch := &Channel{
+		sender:   sender,
+		id:       id,
+		name:     name,
+		incoming: make(chan *qc.QTPData, sender.conf.CHConfig.QueueCap),
+		sqc:      make(chan *qio.SendReq, sender.conf.CHConfig.SendCap),
+		accepted: make(chan qc.ChannelAccept, 1),
+		closed:   make(chan struct{}),
+		sendDone: make(chan struct{}),
+	}
+	go ch.sendLoop()
+	return ch
+}
+
+// ID 通道ID
+func (c *Channel) ID() uint32 {
+	return c.id
+}
+
+// Name 通道名称
+func (c *Channel) Name() string {
+	return c.name
+}
+
+// Sender 通道所在连接的QTPSender
+func (c *Channel) Sender() *QTPSender {
+	return c.sender
+}
+
+// Done 通道关闭后关闭
+func (c *Channel) Done() <-chan struct{} {
+	return c.closed
+}
+
+// Err 通道关闭的原因，对端正常关闭时为qc.ChannelClosedError类型，本端主动关闭或尚未关闭时返回nil
+func (c *Channel) Err() *errors.QError {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+// 依次提交通道内的发送请求，SyncACK消息在生命周期结束后才提交下一个
+func (c *Channel) sendLoop() {
+	defer close(c.sendDone)
+	for {
+		select {
+		case sr := <-c.sqc:
+			c.submit(sr)
+		case <-c.closed:
+			for {
+				select {
+				case sr := <-c.sqc:
+					c.inflight.Done()
+					go sr.LCE(0, errors.New("通道已关闭，消息未发送"))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Channel) submit(sr *qio.SendReq) {
+	lce := sr.LCE
+	finished := make(chan struct{})
+	sr.LCE = func(seq uint64, err *errors.QError) {
+		defer c.inflight.Done()
+		close(finished)
+		lce(seq, err)
+	}
+	sr.Config.Extensions = append(qc.Extensions(nil), sr.Config.Extensions...)
+	sr.Config.Extensions.SetChannel(c.id)
+	c.sender.send(sr)
+	if sr.Config.ACKType == qc.SyncACK {
+		<-finished
+	}
+}
+
+// Send 按conf在通道内发送DATA消息
+func (c *Channel) Send(data []byte, conf qc.QTPConfig, lce qio.LCE) {
+	conf.MsgType = qc.DATA
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.done {
+		go lce(0, errors.New("通道已关闭，无法提交发送请求"))
+		return
+	}
+	c.inflight.Add(1)
+	c.sqc <- &qio.SendReq{
+		Data:   data,
+		Config: conf,
+		LCE:    lce,
+	}
+}
+
+// SendNoACK 在通道内发送NoACK消息
+func (c *Channel) SendNoACK(data []byte, encode qc.Encode, lce qio.LCE) {
+	c.Send(data, qc.QTPConfig{Encode: encode, ACKType: qc.NoACK}, lce)
+}
+
+// SendSyncACK 在通道内发送SyncACK消息，该消息生命周期结束前通道内的后续消息不会发送
+func (c *Channel) SendSyncACK(data []byte, encode qc.Encode, lce qio.LCE) {
+	c.Send(data, qc.QTPConfig{Encode: encode, ACKType: qc.SyncACK}, lce)
+}
+
+// SendAsyncACK 在通道内发送AsyncACK消息
+func (c *Channel) SendAsyncACK(data []byte, encode qc.Encode, lce qio.LCE) {
+	c.Send(data, qc.QTPConfig{Encode: encode, ACKType: qc.AsyncACK}, lce)
+}
+
+// Next 获取通道内收到的下一个消息，通道关闭后返回false，由QTPReceiver的通道协程调用
+// 返回的消息处理完毕后需调用QTPReader.Consumed与QTPData.Release
+func (c *Channel) Next() (*qc.QTPData, bool) {
+	select {
+	case data := <-c.incoming:
+		return data, true
+	case <-c.closed:
+	}
+	// 丢弃关闭后未处理的消息
+	for {
+		select {
+		case data := <-c.incoming:
+			c.sender.release(data)
+		default:
+			return nil, false
+		}
+	}
+}
+
+// 将收到的消息放入通道，通道已关闭时返回false
+func (c *Channel) deliver(data *qc.QTPData) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.done {
+		return false
+	}
+	c.incoming <- data
+	return true
+}
+
+// 关闭通道，重复关闭时返回false
+func (c *Channel) shutdown(err *errors.QError) bool {
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		return false
+	}
+	c.done = true
+	c.err = err
+	close(c.closed)
+	c.mu.Unlock()
+	c.sender.removeChannel(c)
+	return true
+}
+
+// Close 等待通道内已提交的消息生命周期结束后关闭通道，并通知对端
+func (c *Channel) Close() *errors.QError {
+	c.mu.Lock()
+	if c.done {
+		c.mu.Unlock()
+		return errors.New("通道已关闭")
+	}
+	c.done = true
+	c.mu.Unlock()
+	c.inflight.Wait()
+	c.sender.sendControl(qc.ControlChannelClose, qc.ChannelClose{ID: c.id})
+	close(c.closed)
+	<-c.sendDone
+	c.sender.removeChannel(c)
+	return nil
+}
+
+// 记录通道，本端发起的通道以本端ID为key，对端发起的通道以包含ChannelAcceptor位的ID为key
+func (sender *QTPSender) addChannel(ch *Channel) bool {
+	sender.channelMu.Lock()
+	defer sender.channelMu.Unlock()
+	if sender.channels == nil {
+		sender.channels = make(map[uint32]*Channel)
+	}
+	if _, ok := sender.channels[ch.id]; ok {
+		return false
+	}
+	sender.channels[ch.id] = ch
+	return true
+}
+
+func (sender *QTPSender) removeChannel(ch *Channel) {
+	sender.channelMu.Lock()
+	defer sender.channelMu.Unlock()
+	if sender.channels[ch.id] == ch {
+		delete(sender.channels, ch.id)
+	}
+}
+
+// 根据对端发来的通道ID获取通道，对端使用的ID与本端的ChannelAcceptor位相反
+func (sender *QTPSender) remoteChannel(id uint32) *Channel {
+	sender.channelMu.Lock()
+	defer sender.channelMu.Unlock()
+	return sender.channels[id^qc.ChannelAcceptor]
+}
+
+// SetChannelAcceptor 设置对端发起通道时的处理函数，返回非nil时以其中的原因与拒绝码拒绝，由QTPReceiver在启动时设置
+func (sender *QTPSender) SetChannelAcceptor(f func(ch *Channel) *qc.Nack) {
+	sender.channelAcceptor = f
+}
+
+// OpenChannel 向对端发起名称为name的逻辑通道，对端接受后返回，需要对端支持v2及以上版本协议与逻辑通道
+func (sender *QTPSender) OpenChannel(name string) (*Channel, *errors.QError) {
+	sender.waitHello()
+	if sender.Version() < v2.Version || !sender.channelable.Load() {
+		return nil, errors.NewKind(qc.UnsupportedError, "对端不支持逻辑通道")
+	}
+	id := sender.channelSeq.Add(1) &^ qc.ChannelAcceptor
+	ch := sender.newChannel(id, name)
+	if !sender.addChannel(ch) {
+		ch.shutdown(nil)
+		return nil, errors.New("通道ID" + strconv.FormatUint(uint64(id), 10) + "已被占用")
+	}
+	sender.sendControl(qc.ControlChannelOpen, qc.ChannelOpen{ID: id, Name: name})
+	timer := time.NewTimer(sender.conf.CHConfig.OpenTimeout)
+	defer timer.Stop()
+	select {
+	case accept := <-ch.accepted:
+		if !accept.Accepted {
+			ch.shutdown(nil)
+			return nil, rejectError(qc.Nack{Reason: "通道" + name + "：" + accept.Reason, Code: accept.Code})
+		}
+		return ch, nil
+	case <-timer.C:
+		ch.shutdown(nil)
+		return nil, errors.New("等待对端接受通道" + name + "超时")
+	case <-ch.closed:
+		return nil, ch.Err()
+	}
+}
+
+// 处理对端发起的通道
+func (sender *QTPSender) onChannelOpen(open qc.ChannelOpen) {
+	accept := qc.ChannelAccept{ID: open.ID | qc.ChannelAcceptor}
+	ch := sender.newChannel(accept.ID, open.Name)
+	switch {
+	case !sender.addChannel(ch):
+		accept.Reason = "通道ID" + strconv.FormatUint(uint64(open.ID), 10) + "已被占用"
+		ch.shutdown(nil)
+	case sender.channelAcceptor == nil:
+		accept.Reason = "未注册名称为" + open.Name + "的通道"
+		ch.shutdown(nil)
+	default:
+		if nack := sender.channelAcceptor(ch); nack != nil {
+			accept.Reason, accept.Code = nack.Reason, nack.Code
+			ch.shutdown(nil)
+			break
+		}
+		accept.Accepted = true
+	}
+	sender.sendControl(qc.ControlChannelAccept, accept)
+}
+
+func (sender *QTPSender) onChannelAccept(accept qc.ChannelAccept) {
+	if ch := sender.remoteChannel(accept.ID); ch != nil {
+		select {
+		case ch.accepted <- accept:
+		default:
+		}
+	}
+}
+
+func (sender *QTPSender) onChannelClose(c qc.ChannelClose) {
+	if ch := sender.remoteChannel(c.ID); ch != nil {
+		reason := "对端关闭了通道" + ch.name
+		if c.Reason != "" {
+			reason += "：" + c.Reason
+		}
+		ch.shutdown(errors.NewKind(qc.ChannelClosedError, reason))
+	}
+}
+
+// DeliverChannel 将属于逻辑通道的消息交给对应的通道，通道不存在或已关闭时返回false，由QTPReceiver调用
+func (sender *QTPSender) DeliverChannel(data *qc.QTPData) bool {
+	id, ok := data.Channel()
+	if !ok {
+		return false
+	}
+	ch := sender.remoteChannel(id)
+	return ch != nil && ch.deliver(data)
+}
+
+// CloseChannels 以err关闭连接上的所有逻辑通道，连接断开或重连时调用
+func (sender *QTPSender) CloseChannels(err *errors.QError) {
+	sender.channelMu.Lock()
+	channels := make([]*Channel, 0, len(sender.channels))
+	for _, ch := range sender.channels {
+		channels = append(channels, ch)
+	}
+	sender.channelMu.Unlock()
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+}
+
+// 归还消息占用的缓冲额度与池化缓冲
+func (sender *QTPSender) release(data *qc.QTPData) {
+	sender.GetQTPReader().Consumed(data)
+	data.Release()
+}
@@ -0,0 +1,344 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/compress"
+	"QuantumUtils/qnet/qc"
+	v2 "QuantumUtils/qnet/qc/v2"
+	"QuantumUtils/qnet/qio"
+	"encoding/json"
+	"math"
+	"net"
+	"time"
+)
+
+// 固定序列号生成器，用于生成控制消息
+type controlSeq struct{}
+
+func (s controlSeq) NextSeq() uint64 {
+	return qc.ControlSeq
+}
+
+// 包装一个控制消息
+func encodeControl(controlType string, body any) ([]byte, *errors.QError) {
+	control, err := qc.NewControl(controlType, body)
+	if err != nil {
+		return nil, err
+	}
+	raw, jErr := json.Marshal(control)
+	if jErr != nil {
+		return nil, errors.New(jErr.Error())
+	}
+	// 控制消息始终使用基础版本发送
+	encoder, err := qc.NewEncoder(qc.BaseVersion, controlSeq{})
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := encoder.Encode(raw, qc.ControlConfig)
+	return data, err
+}
+
+// 控制消息发送结果回调
+func (sender *QTPSender) controlLCE(seq uint64, err *errors.QError) {
+	if err != nil {
+		err.WithMessage("控制消息未成功发送")
+		sender.GetLogger().Warn(err.Error())
+	}
+}
+
+// 发送控制消息，控制消息不经过发送请求队列，直接提交至Writer
+func (sender *QTPSender) sendControl(controlType string, body any) {
+	data, err := encodeControl(controlType, body)
+	if err != nil {
+		sender.controlLCE(qc.ControlSeq, err)
+		return
+	}
+	sr := &qio.SendReq{
+		SeqN:   qc.ControlSeq,
+		Data:   data,
+		Config: qc.ControlConfig,
+		LCE:    sender.controlLCE,
+	}
+	if !sender.GetQTPWriter().Send(sr) {
+		sender.controlLCE(qc.ControlSeq, errors.New("连接已关闭"))
+	}
+}
+
+// 本端的能力声明
+func (sender *QTPSender) hello() qc.Hello {
+	versions := sender.versions()
+	hello := qc.Hello{
+		Versions: make([]int, len(versions)),
+		Compress: compress.Names(),
+		Features: []string{qc.FeatureChecksum, qc.FeatureStream, qc.FeatureChannel},
+	}
+	for i, version := range versions {
+		hello.Versions[i] = int(version)
+	}
+	if sender.session != nil {
+		hello.Features = append(hello.Features, qc.FeatureEncryption)
+		hello.Salt = sender.session.Salt()
+	}
+	return hello
+}
+
+// 向对端发送能力声明
+func (sender *QTPSender) sendHello() {
+	sender.sendControl(qc.ControlHello, sender.hello())
+}
+
+// Reconnected 连接重连后调用，重置与对端的协商结果，并在新连接上首先发送能力声明
+func (sender *QTPSender) Reconnected(conn net.Conn) {
+	sender.codec.Store(nil)
+	sender.checksum.Store(false)
+	sender.streamable.Store(false)
+	sender.channelable.Store(false)
+	sender.encryptable.Store(false)
+	if sender.session != nil {
+		// 新连接使用新的握手随机数派生密钥
+		if err := sender.session.Reset(); err != nil {
+			sender.GetLogger().QError(err)
+		}
+		// 旧密钥加密、等待应答的消息无法在新连接上重发，结束其生命周期由调用方重新发送
+		sender.GetQTPWriter().FailStale("连接已重连，等待应答的加密消息未送达，需重新发送")
+	}
+	// 数据流与逻辑通道随旧连接一同失效
+	sender.CloseStreams(errors.New("连接已重连，分片传输中止"))
+	sender.CloseChannels(errors.New("连接已重连，逻辑通道失效"))
+	sender.useVersion(qc.BaseVersion)
+	sender.helloMu.Lock()
+	select {
+	case <-sender.helloDone:
+		sender.helloDone = make(chan struct{})
+	default:
+	}
+	sender.helloMu.Unlock()
+	data, err := encodeControl(qc.ControlHello, sender.hello())
+	if err == nil {
+		_, wErr := conn.Write(data)
+		if wErr != nil {
+			err = errors.New(wErr.Error())
+		}
+	}
+	if err != nil {
+		sender.controlLCE(qc.ControlSeq, err)
+	}
+}
+
+func (sender *QTPSender) controlHandle() {
+	for {
+		select {
+		case data, ok := <-sender.GetQTPReader().ControlChan:
+			{
+				if !ok {
+					return
+				}
+				control, err := qc.ParseControl(data.Data)
+				sender.GetQTPReader().Consumed(data)
+				data.Release()
+				if err != nil {
+					sender.GetLogger().Warn(err.Error())
+					continue
+				}
+				sender.handleControl(control)
+			}
+		case <-sender.ackHDone:
+			{
+				return
+			}
+		}
+	}
+}
+
+// 处理对端发来的控制消息，未知类型的控制消息会被忽略
+func (sender *QTPSender) handleControl(control *qc.Control) {
+	switch control.Type {
+	case qc.ControlHello:
+		{
+			hello := qc.Hello{}
+			if err := control.Decode(&hello); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onHello(hello)
+		}
+	case qc.ControlStreamCredit:
+		{
+			credit := qc.StreamCredit{}
+			if err := control.Decode(&credit); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onStreamCredit(credit)
+		}
+	case qc.ControlChannelOpen:
+		{
+			open := qc.ChannelOpen{}
+			if err := control.Decode(&open); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onChannelOpen(open)
+		}
+	case qc.ControlChannelAccept:
+		{
+			accept := qc.ChannelAccept{}
+			if err := control.Decode(&accept); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onChannelAccept(accept)
+		}
+	case qc.ControlChannelClose:
+		{
+			c := qc.ChannelClose{}
+			if err := control.Decode(&c); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onChannelClose(c)
+		}
+	case qc.ControlAuthChallenge:
+		{
+			challenge := qc.AuthChallenge{}
+			if err := control.Decode(&challenge); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onAuthChallenge(challenge)
+		}
+	case qc.ControlAuthResponse:
+		{
+			response := qc.AuthResponse{}
+			if err := control.Decode(&response); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onAuthResponse(response)
+		}
+	case qc.ControlAuthResult:
+		{
+			result := qc.AuthResult{}
+			if err := control.Decode(&result); err != nil {
+				sender.GetLogger().Warn(err.Error())
+				return
+			}
+			sender.onAuthResult(result)
+		}
+	}
+}
+
+// 根据对端的能力声明完成协商
+func (sender *QTPSender) onHello(hello qc.Hello) {
+	remote := make([]uint8, 0, len(hello.Versions))
+	for _, version := range hello.Versions {
+		if version > 0 && version <= math.MaxUint8 {
+			remote = append(remote, uint8(version))
+		}
+	}
+	sender.useVersion(qc.NegotiateVersion(sender.versions(), remote))
+	defer sender.finishHello()
+	sender.checksum.Store(sender.conf.Checksum && hello.HasFeature(qc.FeatureChecksum))
+	sender.streamable.Store(hello.HasFeature(qc.FeatureStream))
+	sender.channelable.Store(hello.HasFeature(qc.FeatureChannel))
+	sender.onEncryptionHello(hello)
+	codec := compress.Negotiate(sender.conf.CConfig.Codecs, hello.Compress)
+	if codec == nil {
+		sender.codec.Store(nil)
+		return
+	}
+	sender.codec.Store(&codec)
+}
+
+// 根据对端的能力声明协商加密，对端需支持v2及以上版本协议与加密
+func (sender *QTPSender) onEncryptionHello(hello qc.Hello) {
+	if sender.session == nil {
+		return
+	}
+	if sender.Version() < v2.Version || !hello.HasFeature(qc.FeatureEncryption) {
+		sender.encryptable.Store(false)
+		if sender.session.Required() {
+			sender.GetLogger().Warn("对端不支持加密，要求加密的消息无法发送")
+		} else {
+			sender.GetLogger().Warn("对端不支持加密，消息将以明文发送")
+		}
+		return
+	}
+	if err := sender.session.SetPeerSalt(hello.Salt); err != nil {
+		sender.encryptable.Store(false)
+		sender.GetLogger().QError(err)
+		return
+	}
+	sender.encryptable.Store(true)
+}
+
+// CompressCodec 获取与对端协商出的压缩算法名称，未协商出压缩算法时返回空字符串
+func (sender *QTPSender) CompressCodec() string {
+	codec := sender.codec.Load()
+	if codec == nil {
+		return ""
+	}
+	return (*codec).Name()
+}
+
+// Checksum 是否与对端协商使用校验和
+func (sender *QTPSender) Checksum() bool {
+	return sender.checksum.Load()
+}
+
+// 本端允许使用且已注册的协议版本
+func (sender *QTPSender) versions() []uint8 {
+	registered := qc.Versions()
+	if len(sender.conf.Versions) == 0 {
+		return registered
+	}
+	result := make([]uint8, 0, len(registered))
+	for _, version := range registered {
+		for _, allowed := range sender.conf.Versions {
+			if version == allowed {
+				result = append(result, version)
+			}
+		}
+	}
+	return result
+}
+
+// 切换发送消息所使用的协议版本
+func (sender *QTPSender) useVersion(version uint8) {
+	encoder, err := qc.NewEncoder(version, sender.seqGenerator)
+	if err != nil {
+		sender.GetLogger().QError(err)
+		return
+	}
+	sender.encoder.Store(&encoder)
+	sender.version.Store(uint32(version))
+}
+
+// Version 获取与对端协商出的协议版本
+func (sender *QTPSender) Version() uint8 {
+	return uint8(sender.version.Load())
+}
+
+// 完成协商，唤醒等待协商结果的发送请求
+func (sender *QTPSender) finishHello() {
+	sender.helloMu.Lock()
+	defer sender.helloMu.Unlock()
+	select {
+	case <-sender.helloDone:
+	default:
+		close(sender.helloDone)
+	}
+}
+
+// 等待对端的能力声明，对端为旧版本时会在HelloTimeout后放弃等待
+func (sender *QTPSender) waitHello() {
+	sender.helloMu.Lock()
+	done := sender.helloDone
+	sender.helloMu.Unlock()
+	timer := time.NewTimer(sender.conf.HelloTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
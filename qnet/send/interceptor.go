@@ -0,0 +1,56 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qio"
+)
+
+// OutboundHandler 出站消息的处理函数，返回的异常会交给消息的LCE
+type OutboundHandler func(sr *qio.SendReq) *errors.QError
+
+// OutboundInterceptor 出站拦截器，在消息被压缩与包装之前执行，可修改sr.Data与sr.Config
+// 调用next继续发送；不调用next时消息不再发送，LCE收到返回的异常，返回nil时LCE收到qc.DroppedError类型的异常
+// 调用next后消息已提交，此时返回的异常被忽略，LCE的结果由消息的发送过程决定
+// 拦截器在发送协程中依次执行，不应长时间阻塞
+// 数据流只有第一个分片经过拦截器，未放行时数据流中止；数据流的其他分片与逻辑通道内的消息不经过拦截器
+type OutboundInterceptor func(sr *qio.SendReq, next OutboundHandler) *errors.QError
+
+// 依次执行出站拦截器，最后执行submit
+func (sender *QTPSender) intercept(i int, sr *qio.SendReq, submit OutboundHandler) *errors.QError {
+	if i == len(sender.conf.Interceptors) {
+		return submit(sr)
+	}
+	return sender.conf.Interceptors[i](sr, func(sr *qio.SendReq) *errors.QError {
+		return sender.intercept(i+1, sr, submit)
+	})
+}
+
+// 消息是否需要经过出站拦截器
+func intercepted(sr *qio.SendReq) bool {
+	if chunk, ok := sr.Config.Extensions.Stream(); ok {
+		return chunk.Index == 0
+	}
+	_, ok := sr.Config.Extensions.Channel()
+	return !ok
+}
+
+// 将sendRequest交给出站拦截器，拦截器未放行时结束消息的生命周期
+func (sender *QTPSender) interceptRequest(sr *qio.SendReq) {
+	if len(sender.conf.Interceptors) == 0 || !intercepted(sr) {
+		sender.sendRequest(sr)
+		return
+	}
+	submitted := false
+	err := sender.intercept(0, sr, func(sr *qio.SendReq) *errors.QError {
+		submitted = true
+		sender.sendRequest(sr)
+		return nil
+	})
+	if !submitted {
+		if err == nil {
+			err = errors.NewKind(qc.DroppedError, "消息被出站拦截器丢弃，未发送")
+		}
+		go sr.LCE(0, err)
+	}
+}
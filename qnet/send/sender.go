@@ -4,11 +4,20 @@ import (
 	"QuantumUtils/errors"
 	"QuantumUtils/goroutine"
 	"QuantumUtils/logger"
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/compress"
 	"QuantumUtils/qnet/connect"
+	"QuantumUtils/qnet/encrypt"
 	"QuantumUtils/qnet/qc"
-	v1 "QuantumUtils/qnet/qc/v1"
+	// 注册v1、v2版本协议
+	_ "QuantumUtils/qnet/qc/v1"
+	v2 "QuantumUtils/qnet/qc/v2"
 	"QuantumUtils/qnet/qio"
 	"QuantumUtils/qnet/seq"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,19 +25,57 @@ import (
 type QTPSenderConfig struct {
 	// 最大重连次数
 	ReconnectAttempts int
+	// 压缩配置
+	CConfig CompressConfig
+	// 是否为发送的消息追加CRC32C校验和，对端不支持时不会追加
+	Checksum bool
+	// 允许使用的协议版本，为空时允许所有已注册的版本
+	Versions []uint8
+	// 发送需要协商结果的消息（如带有消息头扩展）时，等待对端能力声明的最长时间
+	HelloTimeout time.Duration
+	// 分片传输配置
+	STConfig StreamConfig
+	// 逻辑通道配置
+	CHConfig ChannelConfig
+	// 出站拦截器，按顺序执行，先配置的拦截器在外层
+	Interceptors []OutboundInterceptor
+	// 客户端应答服务端认证质询时使用的凭据，为nil时无法通过需要认证的服务端
+	Credential auth.Credential
+	// 认证握手的最长等待时间
+	AuthTimeout time.Duration
+	// 加密配置
+	EConfig encrypt.Config
+}
+
+// CompressConfig 压缩配置
+type CompressConfig struct {
+	// 发送数据时按优先顺序尝试的压缩算法，对端不支持时不会压缩，为空时不压缩
+	Codecs []string
+	// 数据长度不小于该值时才会被压缩
+	Threshold int
 }
 
 // QTPSenderConfigDefault Get默认QTPSender配置
 func QTPSenderConfigDefault() QTPSenderConfig {
 	return QTPSenderConfig{
 		ReconnectAttempts: 5,
+		CConfig:           CompressConfigDefault(),
+		Checksum:          false,
+		Versions:          nil,
+		HelloTimeout:      3 * time.Second,
+		STConfig:          StreamConfigDefault(),
+		CHConfig:          ChannelConfigDefault(),
+		AuthTimeout:       10 * time.Second,
+		EConfig:           encrypt.ConfigDefault(),
 	}
 }
 
-type closedFlag struct {
-	sendRHClosed bool
-	sendHClosed  bool
-	ackHClosed   bool
+// CompressConfigDefault Get默认压缩配置，默认不压缩发送的数据
+func CompressConfigDefault() CompressConfig {
+	return CompressConfig{
+		Codecs:    nil,
+		Threshold: 1024,
+	}
 }
 
 type QTPSender struct {
@@ -37,16 +84,66 @@ type QTPSender struct {
 	qio.QTPReaderAccessor
 	goroutine.GoManagerAccessor
 	qio.QTPWriterAccessor
-	// QTP包装器
-	encoder qc.QTPEncoder
+	// QTP包装器，使用与对端协商出的协议版本
+	encoder atomic.Pointer[qc.QTPEncoder]
+	// 序列号生成器，各版本的包装器共用
+	seqGenerator qc.QTPSeqGenerator
 	// 发送请求通道
-	sqc    chan *qio.SendReq
-	closed closedFlag
+	sqc chan *qio.SendReq
+	// 是否已关闭，关闭后不再接受发送请求
+	closed bool
+	// 保护closed，保证关闭后不会再有请求进入sqc
+	mu sync.RWMutex
+	// 发送请求处理的关闭通知
+	sendRHDone chan struct{}
+	// ACK处理的关闭通知
+	ackHDone chan struct{}
+	// Sender配置
+	conf QTPSenderConfig
+	// 与对端协商出的压缩算法，为nil时不压缩
+	codec atomic.Pointer[compress.Codec]
+	// 是否与对端协商使用校验和
+	checksum atomic.Bool
+	// 与对端协商出的协议版本
+	version atomic.Uint32
+	// 收到对端能力声明后关闭
+	helloDone chan struct{}
+	// 保护helloDone
+	helloMu sync.Mutex
+	// 对端是否支持分片传输
+	streamable atomic.Bool
+	// 正在发送的数据流的发送额度
+	streams  map[uint64]*streamWindow
+	streamMu sync.Mutex
+	// 对端是否支持逻辑通道
+	channelable atomic.Bool
+	// 本端发起的通道ID
+	channelSeq atomic.Uint32
+	// 连接上的逻辑通道
+	channels  map[uint32]*Channel
+	channelMu sync.Mutex
+	// 对端发起通道时的处理函数
+	channelAcceptor func(ch *Channel) *qc.Nack
+	// 认证通过的主体
+	principal atomic.Pointer[auth.Principal]
+	// 服务端已发出认证质询且尚未收到应答
+	challenged atomic.Bool
+	// 服务端收到的认证应答
+	authResponses chan qc.AuthResponse
+	// 客户端收到的认证结果
+	authResults chan qc.AuthResult
+	// 连接的加密会话，为nil时不加密
+	session *encrypt.Session
+	// 是否与对端协商加密
+	encryptable atomic.Bool
 }
 
 func (sender *QTPSender) send(sr *qio.SendReq) {
-	if sender.closed.sendRHClosed {
+	sender.mu.RLock()
+	defer sender.mu.RUnlock()
+	if sender.closed {
 		go sr.LCE(0, errors.New("连接已主动关闭，无法提交发送请求"))
+		return
 	}
 	// 提交发送请求
 	sender.sqc <- sr
@@ -60,33 +157,72 @@ func (sender *QTPSender) sendRequestHandle() {
 				if !ok {
 					return
 				}
-				// 包装数据
-				seqN, data, err := sender.encoder.Encode(sr.Data, sr.Config)
-
-				if err != nil {
-					go sr.LCE(seqN, err)
-					continue
-				}
-				sr.SeqN = seqN
-				sr.Data = data
-				// 提交发送
-				sender.GetQTPWriter().SendChan <- sr
-				// 如果是同步确认消息，则等待消息的生命周期完成
-				if sr.Config.ACKType == qc.SyncACK {
-					sender.GetQTPWriter().WaitMsgLCE(seqN)
-				}
+				sender.interceptRequest(sr)
 			}
-		// 若100秒内无请求
-		case <-time.After(time.Millisecond * 100):
+		case <-sender.sendRHDone:
 			{
-				if sender.closed.sendRHClosed {
-					return
+				// 已关闭，处理剩下的发送请求后退出
+				for {
+					select {
+					case sr := <-sender.sqc:
+						sender.interceptRequest(sr)
+					default:
+						return
+					}
 				}
 			}
 		}
 	}
 }
 
+// 包装并提交一个发送请求
+func (sender *QTPSender) sendRequest(sr *qio.SendReq) {
+	if (len(sr.Config.Extensions) > 0 || sender.session != nil) && sender.Version() < v2.Version {
+		// 消息头扩展与加密需要v2及以上版本，等待协商完成
+		sender.waitHello()
+	}
+	if len(sr.Config.Extensions) > 0 && sender.Version() < v2.Version {
+		// v1版本协议没有扩展区，不能丢弃路由、签名等扩展后继续发送
+		go sr.LCE(0, errors.NewKind(qc.UnsupportedError, "对端协商的v"+strconv.Itoa(int(sender.Version()))+"版本协议不支持消息头扩展"))
+		return
+	}
+	sender.compress(sr)
+	if err := sender.encrypt(sr); err != nil {
+		go sr.LCE(0, err)
+		return
+	}
+	sr.Config.Checksum = sender.checksum.Load()
+	// 包装数据
+	seqN, data, err := (*sender.encoder.Load()).Encode(sr.Data, sr.Config)
+
+	if err != nil {
+		go sr.LCE(seqN, err)
+		return
+	}
+	sr.SeqN = seqN
+	sr.Data = data
+	// 提交发送
+	if !sender.GetQTPWriter().Send(sr) {
+		go sr.LCE(seqN, errors.New("连接已关闭，消息未发送"))
+		return
+	}
+	// 如果是同步确认消息，则等待消息的生命周期完成，逻辑通道内的同步确认消息由通道自行等待
+	if _, ok := sr.Config.Extensions.Channel(); !ok && sr.Config.ACKType == qc.SyncACK {
+		sender.GetQTPWriter().WaitMsgLCE(seqN)
+	}
+}
+
+// Send 按conf发送DATA消息，可通过conf.Extensions附带消息头扩展（需对端支持v2及以上版本协议）
+func (sender *QTPSender) Send(data []byte, conf qc.QTPConfig, lce qio.LCE) {
+	conf.MsgType = qc.DATA
+	sr := &qio.SendReq{
+		Data:   data,
+		Config: conf,
+		LCE:    lce,
+	}
+	sender.send(sr)
+}
+
 // SendNoACK 发送NoACK消息
 func (sender *QTPSender) SendNoACK(data []byte, encode qc.Encode, lce qio.LCE) {
 	conf := qc.QTPConfig{
@@ -132,6 +268,84 @@ func (sender *QTPSender) SendAsyncACK(data []byte, encode qc.Encode, lce qio.LCE
 	sender.send(sr)
 }
 
+// 使用协商出的压缩算法压缩数据，压缩失败或压缩后没有变小时原样发送
+func (sender *QTPSender) compress(sr *qio.SendReq) {
+	codec := sender.codec.Load()
+	if codec == nil || len(sr.Data) < sender.conf.CConfig.Threshold {
+		return
+	}
+	data, err := (*codec).Compress(sr.Data)
+	if err != nil {
+		err.WithMessage("数据压缩失败，将以未压缩的方式发送")
+		sender.GetLogger().Warn(err.Error())
+		return
+	}
+	if len(data) >= len(sr.Data) {
+		return
+	}
+	sr.Data = data
+	sr.Config.Compress = (*codec).ID()
+}
+
+// 使用与对端协商出的密钥加密数据，未协商加密且配置要求加密时返回异常
+func (sender *QTPSender) encrypt(sr *qio.SendReq) *errors.QError {
+	if sender.session == nil {
+		return nil
+	}
+	if !sender.encryptable.Load() {
+		if sender.session.Required() {
+			return errors.New("对端不支持加密，消息未发送")
+		}
+		return nil
+	}
+	// 密文只能由当前连接的密钥解密，重连更换密钥后不再写出与重发
+	session := sender.session
+	epoch := session.Epoch()
+	keyID, data, err := session.Seal(sr.Config, sr.Data)
+	if err != nil {
+		return err
+	}
+	sr.Data = data
+	sr.Config.Extensions = sr.Config.Extensions.Clone()
+	sr.Config.Extensions.SetEncryption(keyID)
+	sr.Stale = func() bool { return session.Epoch() != epoch }
+	return nil
+}
+
+// DropStale 去除重连前写入失败的数据中的加密消息，这些消息的密钥已随重连更换，在新连接上无法解密
+// 其生命周期已在Reconnected中以qc.StaleError结束，作为Reconnecter的重写处理使用
+func (sender *QTPSender) DropStale(b []byte) []byte {
+	if sender.session == nil {
+		return b
+	}
+	parser := qc.NewVersionedParser(qc.ParserOptions{})
+	var kept []byte
+	offset := 0
+	for offset < len(b) {
+		msg, n := parser.ParseFrame(b[offset:])
+		if msg == nil || n == 0 {
+			// 无法解析的部分原样写出
+			kept = append(kept, b[offset:]...)
+			break
+		}
+		if _, ok := msg.Header.Extensions.Encryption(); !ok {
+			kept = append(kept, b[offset:offset+n]...)
+		}
+		offset += n
+	}
+	return kept
+}
+
+// SetSession 设置连接的加密会话，需与该连接的QTPReader共用，需在Start前调用
+func (sender *QTPSender) SetSession(session *encrypt.Session) {
+	sender.session = session
+}
+
+// Encrypted 是否与对端协商加密
+func (sender *QTPSender) Encrypted() bool {
+	return sender.encryptable.Load()
+}
+
 func (sender *QTPSender) ackHandle() {
 	// TODO set持久化defer
 
@@ -147,21 +361,28 @@ func (sender *QTPSender) ackHandle() {
 					sender.GetLogger().QError(data.ParserError)
 					return
 				}
+				sender.GetQTPReader().Consumed(data)
 				// 只接受ACK消息
 				if data.Header.MsgType != qc.ACK {
+					data.Release()
 					continue
 				}
 				// 获取消息序列号
 				ackSeq := data.Header.Seq
+				// 带有数据的ACK为NACK，表明对端拒绝了该消息
+				if len(data.Data) > 0 {
+					sender.GetQTPWriter().MsgReject(ackSeq, nackError(data.Data))
+					data.Release()
+					continue
+				}
+				data.Release()
 				// 完成消息生命周期
 				sender.GetQTPWriter().MsgFinish(ackSeq)
 
 			}
-		case <-time.After(time.Millisecond * 100):
+		case <-sender.ackHDone:
 			{
-				if sender.closed.ackHClosed {
-					return
-				}
+				return
 			}
 
 		}
@@ -169,20 +390,113 @@ func (sender *QTPSender) ackHandle() {
 
 }
 
+// 解析NACK消息的数据
+func nackError(data []byte) *errors.QError {
+	nack := qc.Nack{}
+	if err := json.Unmarshal(data, &nack); err != nil {
+		return errors.NewKind(qc.NackError, "消息被对端拒绝")
+	}
+	return rejectError(nack)
+}
+
+// 根据拒绝码选择异常类型
+func rejectError(nack qc.Nack) *errors.QError {
+	switch nack.Code {
+	case qc.NackRouteNotFound:
+		return errors.NewKind(qc.RouteNotFoundError, "对端没有处理该消息路由的回调："+nack.Reason)
+	case qc.NackPermissionDenied:
+		return errors.NewKind(qc.PermissionDeniedError, "对端拒绝授权："+nack.Reason)
+	case qc.NackSignatureInvalid:
+		return errors.NewKind(qc.SignatureError, "对端拒绝了消息签名："+nack.Reason)
+	case qc.NackRateLimited:
+		return errors.NewKind(qc.RateLimitError, "消息速率超过对端的限制："+nack.Reason)
+	}
+	return errors.NewKind(qc.NackError, "消息被对端拒绝："+nack.Reason)
+}
+
+// ACK消息的序列号与被确认的消息相同
+type ackSeq uint64
+
+func (s ackSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+// ack消息的配置
+var ackConf = qc.QTPConfig{
+	Encode:  qc.BINARY,
+	MsgType: qc.ACK,
+	ACKType: qc.NoACK,
+}
+
+func (sender *QTPSender) ackLCE(dataSeq uint64, err *errors.QError) {
+	if err != nil {
+		err.WithMessage("ACK消息未成功发送,Seq:" + strconv.FormatUint(dataSeq, 10))
+		sender.GetLogger().Warn(err.ErrorStackMessage())
+	}
+}
+
+// SendACK 确认对端序列号为dataSeq的消息，ACK消息不经过发送请求队列，直接提交至Writer
+func (sender *QTPSender) SendACK(dataSeq uint64) {
+	sender.sendACK(dataSeq, nil)
+}
+
+// SendNack 以nack拒绝对端序列号为dataSeq的消息，对端的LCE会收到qc.NackError或nack.Code对应类型的异常
+func (sender *QTPSender) SendNack(dataSeq uint64, nack qc.Nack) {
+	data, err := json.Marshal(nack)
+	if err != nil {
+		sender.ackLCE(dataSeq, errors.New(err.Error()))
+		return
+	}
+	sender.sendACK(dataSeq, data)
+}
+
+func (sender *QTPSender) sendACK(dataSeq uint64, data []byte) {
+	conf := ackConf
+	if len(data) > 0 {
+		conf.Encode = qc.JSON
+	}
+	conf.Checksum = sender.Checksum()
+	encoder, err := qc.NewEncoder(sender.Version(), ackSeq(dataSeq))
+	var ackByte []byte
+	if err == nil {
+		_, ackByte, err = encoder.Encode(data, conf)
+	}
+	if err != nil {
+		sender.ackLCE(dataSeq, err)
+		return
+	}
+	sendR := &qio.SendReq{
+		SeqN:   dataSeq,
+		Data:   ackByte,
+		Config: conf,
+		LCE:    sender.ackLCE,
+	}
+	if !sender.GetQTPWriter().Send(sendR) {
+		sender.ackLCE(dataSeq, errors.New("连接已关闭"))
+	}
+}
+
 func (sender *QTPSender) Close() *errors.QError {
 	// 停止提交发送请求
-	sender.closed.sendRHClosed = true
+	sender.mu.Lock()
+	if sender.closed {
+		sender.mu.Unlock()
+		return errors.New("连接已主动关闭")
+	}
+	sender.closed = true
+	close(sender.sendRHDone)
+	sender.mu.Unlock()
+	sender.CloseChannels(errors.New("连接已主动关闭"))
 	// 等待处理剩下的发送请求
 	sender.GetGoManager().Wait(sendRequestHandle)
-	// 停止提交发送
-	sender.closed.sendHClosed = true
 	// 等待处理剩下的发送
 	sender.GetQTPWriter().Close()
 	// 等待剩下的消息生命周期结束
 	sender.WaitMsgLCE()
 	// 停止接受ACK
-	sender.closed.ackHClosed = true
+	close(sender.ackHDone)
 	sender.GetGoManager().Wait(ackHandle)
+	sender.GetGoManager().Wait(controlHandle)
 	// 关闭连接
 	err := sender.GetQTPConn().Close()
 	if err != nil {
@@ -192,6 +506,7 @@ func (sender *QTPSender) Close() *errors.QError {
 }
 
 var ackHandle = goroutine.Group("ackHandle")
+var controlHandle = goroutine.Group("controlHandle")
 var sendRequestHandle = goroutine.Group("sendRHandle")
 
 // Start 启动
@@ -208,7 +523,14 @@ func (sender *QTPSender) Start() {
 		return sender.GetQTPWriter()
 	})
 	sender.GetGoManager().Goroutine(ackHandle, sender.ackHandle)
+	sender.GetGoManager().Goroutine(controlHandle, sender.controlHandle)
 	sender.GetGoManager().Goroutine(sendRequestHandle, sender.sendRequestHandle)
+	sender.sendHello()
+}
+
+// SetConfig 设置Sender配置，需在Start前调用
+func (sender *QTPSender) SetConfig(conf QTPSenderConfig) {
+	sender.conf = conf
 }
 
 // SendRequest 返回有多少个发送请求等待处理
@@ -224,11 +546,18 @@ func (sender *QTPSender) WaitMsgLCE() {
 // NewSender 创建一个Sender
 func NewSender(sendCap int) *QTPSender {
 
-	encoder := v1.NewQMsgEncoder(seq.NewSnowFlakeSeqGenerator())
 	sender := &QTPSender{
-		encoder: encoder,
+		seqGenerator:  seq.NewSnowFlakeSeqGenerator(),
+		sendRHDone:    make(chan struct{}),
+		ackHDone:      make(chan struct{}),
+		conf:          QTPSenderConfigDefault(),
+		helloDone:     make(chan struct{}),
+		authResponses: make(chan qc.AuthResponse, 1),
+		authResults:   make(chan qc.AuthResult, 1),
 	}
 	sender.sqc = make(chan *qio.SendReq, sendCap)
+	// 在收到对端的能力声明之前使用基础版本
+	sender.useVersion(qc.BaseVersion)
 
 	return sender
 
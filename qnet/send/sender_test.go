@@ -0,0 +1,146 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/goroutine"
+	"QuantumUtils/qnet/auth"
+	"QuantumUtils/qnet/qc"
+	v1 "QuantumUtils/qnet/qc/v1"
+	"QuantumUtils/qnet/qio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Debug(msg string)          {}
+func (l testLogger) Info(msg string)           {}
+func (l testLogger) Warn(msg string)           { l.t.Log(msg) }
+func (l testLogger) Error(msg string)          { l.t.Log(msg) }
+func (l testLogger) QError(err *errors.QError) { l.t.Log(err.Error()) }
+
+type fixedSeq uint64
+
+func (s fixedSeq) NextSeq() uint64 {
+	return uint64(s)
+}
+
+// 模拟对端，读取每一个消息并对需要确认的消息回复ACK
+func ackPeer(conn net.Conn) {
+	header := make([]byte, qc.HeaderLength)
+	ackConf := qc.QTPConfig{Encode: qc.BINARY, MsgType: qc.ACK, ACKType: qc.NoACK}
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		dataLength := binary.LittleEndian.Uint32(header[17:21])
+		if _, err := io.CopyN(io.Discard, conn, int64(dataLength)); err != nil {
+			return
+		}
+		if qc.ACKType(header[16]) == qc.NoACK {
+			continue
+		}
+		seqN := binary.LittleEndian.Uint64(header[6:14])
+		_, ack, _ := v1.NewQMsgEncoder(fixedSeq(seqN)).Encode(nil, ackConf)
+		if _, err := conn.Write(ack); err != nil {
+			return
+		}
+	}
+}
+
+func newTestSender(t *testing.T, conn net.Conn) *QTPSender {
+	sender := NewSender(100)
+	reader := qio.NewQTPReader(conn, qio.QTPReaderConfigDefault())
+	writer := qio.NewQTPWriter(conn, qio.QTPWriterConfigDefault())
+	gm := &goroutine.GoManager{}
+	sender.SetQTPConn(conn)
+	sender.SetLogger(testLogger{t: t})
+	sender.SetGoManager(gm)
+	writer.SetGoManager(gm)
+	sender.SetQTPReader(reader)
+	sender.SetQTPWriter(writer)
+	reader.Start()
+	writer.Start()
+	sender.Start()
+	return sender
+}
+
+func TestSenderClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go ackPeer(server)
+	sender := newTestSender(t, client)
+
+	var wg sync.WaitGroup
+	lce := func(seq uint64, err *errors.QError) {
+		defer wg.Done()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		sender.SendNoACK([]byte("noACK"), qc.BINARY, lce)
+		sender.SendAsyncACK([]byte("asyncACK"), qc.BINARY, lce)
+		sender.SendSyncACK([]byte("syncACK"), qc.BINARY, lce)
+	}
+
+	start := time.Now()
+	if err := sender.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	// 关闭不再需要等待轮询间隔
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+
+	wg.Add(1)
+	sender.SendNoACK([]byte("closed"), qc.BINARY, func(seq uint64, err *errors.QError) {
+		defer wg.Done()
+		if err == nil {
+			t.Error("send after Close succeeded")
+		}
+	})
+	wg.Wait()
+}
+
+func TestAuthResponseWithoutChallenge(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	valid, _ := encodeControl(qc.ControlAuthResponse, qc.AuthResponse{Method: auth.MethodToken, Proof: []byte("s3cret")})
+	invalid, _ := encodeControl(qc.ControlAuthResponse, qc.AuthResponse{Method: auth.MethodToken, Proof: []byte("guess")})
+	go func() {
+		// 未收到质询就发送有效的令牌，收到质询后以错误的令牌应答
+		if _, err := server.Write(valid); err != nil {
+			return
+		}
+		parser := qc.NewVersionedParser(qc.ParserOptions{})
+		for {
+			data := parser.ParseReader(server)
+			if data.ParserError != nil {
+				return
+			}
+			if control, err := qc.ParseControl(data.Data); err == nil && control.Type == qc.ControlAuthChallenge {
+				if _, err := server.Write(invalid); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	sender := newTestSender(t, client)
+	defer sender.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// 质询之前收到的应答被忽略，认证以质询之后的应答为准
+	principal, err := sender.Authenticate(auth.NewSharedSecretAuthenticator("s3cret", "svc"))
+	if !err.IsKind(qc.AuthError) || principal != nil || sender.Principal() != nil {
+		t.Errorf("expected the unsolicited response to be ignored, got %v, %v", principal, err)
+	}
+}
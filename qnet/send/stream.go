@@ -0,0 +1,156 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	v2 "QuantumUtils/qnet/qc/v2"
+	"io"
+	"sync"
+)
+
+// StreamConfig 分片传输配置
+type StreamConfig struct {
+	// 单个分片数据的最大byte长度
+	ChunkSize int
+	// 对端尚未处理的分片数量上限，达到上限时SendStream阻塞，直至对端处理完分片并归还额度
+	Window int
+}
+
+// StreamConfigDefault Get默认分片传输配置
+func StreamConfigDefault() StreamConfig {
+	return StreamConfig{
+		ChunkSize: 64 << 10,
+		Window:    8,
+	}
+}
+
+// 单个数据流的发送额度
+type streamWindow struct {
+	credits chan struct{}
+	// 任意分片发送失败时关闭
+	failed chan struct{}
+	once   sync.Once
+	err    *errors.QError
+}
+
+func (w *streamWindow) fail(err *errors.QError) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.failed)
+	})
+}
+
+// 归还发送额度，超出窗口的部分会被忽略
+func (w *streamWindow) grant(credits int) {
+	for i := 0; i < credits; i++ {
+		select {
+		case w.credits <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+func (sender *QTPSender) openStream(id uint64) *streamWindow {
+	window := &streamWindow{
+		credits: make(chan struct{}, sender.conf.STConfig.Window),
+		failed:  make(chan struct{}),
+	}
+	window.grant(sender.conf.STConfig.Window)
+	sender.streamMu.Lock()
+	defer sender.streamMu.Unlock()
+	if sender.streams == nil {
+		sender.streams = make(map[uint64]*streamWindow)
+	}
+	sender.streams[id] = window
+	return window
+}
+
+func (sender *QTPSender) closeStream(id uint64) {
+	sender.streamMu.Lock()
+	defer sender.streamMu.Unlock()
+	delete(sender.streams, id)
+}
+
+// CloseStreams 以err中止连接上所有发送中的数据流，连接断开或重连时调用
+// 对端不再持有数据流的状态，不会继续归还发送额度，阻塞中的SendStream会返回err
+func (sender *QTPSender) CloseStreams(err *errors.QError) {
+	sender.streamMu.Lock()
+	defer sender.streamMu.Unlock()
+	for _, window := range sender.streams {
+		window.fail(err)
+	}
+}
+
+// 处理对端归还的发送额度
+func (sender *QTPSender) onStreamCredit(credit qc.StreamCredit) {
+	sender.streamMu.Lock()
+	window := sender.streams[credit.ID]
+	sender.streamMu.Unlock()
+	if window != nil {
+		window.grant(credit.Credits)
+	}
+}
+
+// GrantStream 通知对端数据流id已处理完credits个分片，由接收方在分片被读取后调用
+func (sender *QTPSender) GrantStream(id uint64, credits int) {
+	sender.sendControl(qc.ControlStreamCredit, qc.StreamCredit{ID: id, Credits: credits})
+}
+
+// SendStream 将reader中的数据切分为分片依次发送，直至reader返回io.EOF，适用于无法一次性放入内存或超过4GB的数据
+// 分片之间可穿插其他消息；对端尚未处理的分片达到Window时阻塞，所有分片都被对端确认后返回
+// conf中的编码方式与消息头扩展会附加在每个分片上，需要对端支持v2及以上版本协议与分片传输
+// reader返回io.EOF以外的异常时会中止数据流，对端读取时会收到异常；连接断开或重连时立即返回异常，需由调用方重新发送
+func (sender *QTPSender) SendStream(reader io.Reader, conf qc.QTPConfig) *errors.QError {
+	sender.waitHello()
+	if sender.Version() < v2.Version || !sender.streamable.Load() {
+		return errors.NewKind(qc.UnsupportedError, "对端不支持分片传输")
+	}
+	id := sender.seqGenerator.NextSeq()
+	window := sender.openStream(id)
+	defer sender.closeStream(id)
+
+	conf.ACKType = qc.AsyncACK
+	var wg sync.WaitGroup
+	lce := func(seq uint64, err *errors.QError) {
+		defer wg.Done()
+		if err != nil {
+			window.fail(err)
+		}
+	}
+	buf := make([]byte, sender.conf.STConfig.ChunkSize)
+	var readErr *errors.QError
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(reader, buf)
+		chunk := qc.StreamChunk{ID: id, Index: index}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			chunk.Flags = qc.StreamEnd
+		} else if err != nil {
+			chunk.Flags = qc.StreamAbort
+			readErr = errors.New("分片传输中止，" + err.Error())
+		}
+
+		select {
+		case <-window.credits:
+		case <-window.failed:
+			// 连接重连时已发送的分片可能要等到重发超时才结束生命周期，不再等待
+			return window.err
+		case <-sender.sendRHDone:
+			wg.Wait()
+			return errors.New("连接已主动关闭，分片传输中止")
+		}
+		chunkConf := conf
+		chunkConf.Extensions = append(qc.Extensions(nil), conf.Extensions...)
+		chunkConf.Extensions.SetStream(chunk)
+		wg.Add(1)
+		sender.Send(append([]byte(nil), buf[:n]...), chunkConf, lce)
+		if chunk.End() {
+			break
+		}
+	}
+	wg.Wait()
+	if readErr != nil {
+		return readErr
+	}
+	return window.err
+}
@@ -0,0 +1,30 @@
+package send
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/codec"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qio"
+)
+
+// Send 使用conf.Encode对应的编码方式（见codec.Register）编码v后按conf发送，编码失败时直接返回异常且不会调用lce
+func Send[T any](sender *QTPSender, v T, conf qc.QTPConfig, lce qio.LCE) *errors.QError {
+	data, err := codec.Marshal(conf.Encode, v)
+	if err != nil {
+		err.WithMessage("消息编码失败")
+		return err
+	}
+	sender.Send(data, conf, lce)
+	return nil
+}
+
+// SendChannel 使用conf.Encode对应的编码方式编码v后在通道ch内按conf发送，编码失败时直接返回异常且不会调用lce
+func SendChannel[T any](ch *Channel, v T, conf qc.QTPConfig, lce qio.LCE) *errors.QError {
+	data, err := codec.Marshal(conf.Encode, v)
+	if err != nil {
+		err.WithMessage("消息编码失败")
+		return err
+	}
+	ch.Send(data, conf, lce)
+	return nil
+}
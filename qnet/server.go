@@ -5,40 +5,94 @@ import (
 	"QuantumUtils/goroutine"
 	"QuantumUtils/logger"
 	"QuantumUtils/qnet/connect"
+	"QuantumUtils/qnet/encrypt"
+	"QuantumUtils/qnet/guard"
 	"QuantumUtils/qnet/qio"
+	"QuantumUtils/qnet/ratelimit"
 	"QuantumUtils/qnet/receive"
 	"QuantumUtils/qnet/send"
+	stderrors "errors"
 	"net"
+	"time"
+)
+
+const (
+	// Accept失败后首次重试的等待时间，连续失败时加倍
+	acceptBackoffMin = 5 * time.Millisecond
+	// Accept失败后重试的最长等待时间
+	acceptBackoffMax = time.Second
 )
 
 type QTPServer struct {
-	wConf qio.QTPWriterConfig
+	conf QTPServerConfig
 	logger.QLoggerAccessor
 	callBackH *receive.CallBackHandler
 	listener  net.Listener
+	// 速率限制器，未限制速率时为nil
+	limiter *ratelimit.Limiter
+	// 连接准入控制，未配置准入限制时为nil
+	guard *guard.Guard
 }
 
 func (server *QTPServer) Start() {
 	// 退出时关闭
 	defer connect.RegisterClose(server.listener, server.GetLogger().QError)
 
+	var backoff time.Duration
 	for {
 		conn, err := server.listener.Accept()
 		if err != nil {
-			server.GetLogger().Error(err.Error())
+			if stderrors.Is(err, net.ErrClosed) {
+				return
+			}
+			// 文件描述符耗尽等异常通常是暂时的，退避后重试，避免空转
+			if backoff *= 2; backoff < acceptBackoffMin {
+				backoff = acceptBackoffMin
+			} else if backoff > acceptBackoffMax {
+				backoff = acceptBackoffMax
+			}
+			server.GetLogger().Error(err.Error() + "，" + backoff.String() + "后重试")
+			time.Sleep(backoff)
 			continue
 		}
+		backoff = 0
+
+		var admitted *guard.Conn
+		if server.guard != nil {
+			var qErr *errors.QError
+			if admitted, qErr = server.guard.Admit(conn.RemoteAddr()); qErr != nil {
+				server.GetLogger().Debug(qErr.Error())
+				_ = conn.Close()
+				continue
+			}
+		}
 
-		go server.connHandle(conn)
+		go server.connHandle(conn, admitted)
 
 	}
 }
 
-func (server *QTPServer) connHandle(conn net.Conn) {
+// Limiter 获取速率限制器，可用于获取统计信息，未配置速率限制时返回nil
+func (server *QTPServer) Limiter() *ratelimit.Limiter {
+	return server.limiter
+}
+
+// Guard 获取连接准入控制，可用于获取统计信息与管理封禁，未配置准入限制时返回nil
+func (server *QTPServer) Guard() *guard.Guard {
+	return server.guard
+}
+
+// Addr 获取Server监听的地址
+func (server *QTPServer) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+func (server *QTPServer) connHandle(conn net.Conn, admitted *guard.Conn) {
 	// 新建receiver
 	newReceiver := receive.NewQTPReceiver()
 	// 新建sender
-	newSender := send.NewSender(server.wConf.SendCap)
+	newSender := send.NewSender(server.conf.WConf.SendCap)
+	newSender.SetConfig(server.conf.SConf)
 	newCallBacker := &receive.CallBacker{
 		Handler: server.callBackH,
 		Sender:  newSender,
@@ -48,15 +102,33 @@ func (server *QTPServer) connHandle(conn net.Conn) {
 	newReceiver.SetLogger(server.GetLogger())
 
 	newReceiver.SetCallBacker(newCallBacker)
+	newReceiver.SetGuard(admitted)
 
 	newReceiver.SetQTPConn(conn)
 	newSender.SetQTPConn(conn)
 
-	newReader := qio.NewQTPReader(conn, qio.QTPReaderConfigDefault())
+	newReader := qio.NewQTPReader(conn, server.conf.RConf)
+	newReader.SetLogger(server.GetLogger())
 	newReceiver.SetQTPReader(newReader)
 	newSender.SetQTPReader(newReader)
 
-	newWriter := qio.NewQTPWriter(conn, server.wConf)
+	if server.conf.SConf.EConfig.Keyring != nil {
+		session, err := encrypt.NewSession(server.conf.SConf.EConfig)
+		if err != nil {
+			server.GetLogger().QError(err)
+			admitted.Close(nil)
+			_ = conn.Close()
+			return
+		}
+		newSender.SetSession(session)
+		newReader.SetSession(session)
+	}
+
+	if server.limiter != nil {
+		newReceiver.SetLimiter(server.limiter.Conn(conn.RemoteAddr()))
+	}
+
+	newWriter := qio.NewQTPWriter(conn, server.conf.WConf)
 	newReceiver.SetQTPWriter(newWriter)
 	newSender.SetQTPWriter(newWriter)
 
@@ -64,14 +136,37 @@ func (server *QTPServer) connHandle(conn net.Conn) {
 	newSender.SetGoManager(newGm)
 	newWriter.SetGoManager(newGm)
 
+	if server.conf.Authenticator != nil {
+		newReader.RequireAuth()
+	}
 	newReader.Start()
 	newWriter.Start()
-	newReceiver.Start()
+	if server.conf.Authenticator == nil {
+		newReceiver.Start()
+		newSender.Start()
+		return
+	}
+	// 认证握手通过控制消息完成，认证通过前收到的其他消息由Reader直接丢弃，不会缓存，也不会交给回调
 	newSender.Start()
+	if _, err := newSender.Authenticate(server.conf.Authenticator); err != nil {
+		server.GetLogger().Warn("连接" + conn.RemoteAddr().String() + "认证失败：" + err.Error())
+		admitted.Violate("认证失败：" + err.Error())
+		newReceiver.Discard()
+		_ = newSender.Close()
+		return
+	}
+	newReceiver.Start()
 }
 
 // NewQuantumServer 创建一个基于普通连接的QuantumServer
 func NewQuantumServer(address string, callBackH *receive.CallBackHandler, wCof qio.QTPWriterConfig) (*QTPServer, *errors.QError) {
+	conf := QTPServerConfigDefault()
+	conf.WConf = wCof
+	return NewQuantumServerWithConfig(address, callBackH, conf)
+}
+
+// NewQuantumServerWithConfig 使用指定配置创建一个基于普通连接的QuantumServer
+func NewQuantumServerWithConfig(address string, callBackH *receive.CallBackHandler, conf QTPServerConfig) (*QTPServer, *errors.QError) {
 	listen, err := GetListen(address)
 	if err != nil {
 		return nil, err
@@ -81,7 +176,19 @@ func NewQuantumServer(address string, callBackH *receive.CallBackHandler, wCof q
 	server := QTPServer{
 		callBackH: callBackH,
 		listener:  listen,
-		wConf:     wCof,
+		conf:      conf,
+	}
+	if conf.RLConfig.Enabled() {
+		server.limiter = ratelimit.NewLimiter(conf.RLConfig)
+	}
+	if conf.GConfig.Enabled() {
+		g, err := guard.NewGuard(conf.GConfig)
+		if err != nil {
+			_ = listen.Close()
+			return nil, err
+		}
+		g.SetLogger(qLogger)
+		server.guard = g
 	}
 	server.SetLogger(qLogger)
 
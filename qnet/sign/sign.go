@@ -0,0 +1,100 @@
+package sign
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qio"
+	"QuantumUtils/qnet/send"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// 发送方以Ed25519私钥对消息签名，签名者ID与签名放在消息头扩展中随消息发送，接收方以受信任的公钥校验后才交给回调
+// 签名内容为固定前缀、数据编码类型、ACK机制、除签名与加密以外的消息头扩展以及压缩与加密之前的原始数据
+// 消息类型与序列号不在签名范围内，重发的消息无需重新签名
+// 签名时附带签名时间与随机数，接收方拒绝超出时间窗口或随机数重复的签名，防止签名消息被重放
+// 数据流只有第一个分片经过拦截器，签名无法覆盖后续分片，因此不支持签名：
+// 发送方不发送需要签名的数据流，接收方拒绝路由必须签名或带有签名的数据流，需签名的数据应以普通消息发送
+
+// 签名内容的固定前缀
+var signPrefix = []byte("QTP-SIG-V1")
+
+// 生成签名内容
+func signedBytes(encode qc.Encode, ackType qc.ACKType, extensions qc.Extensions, data []byte) []byte {
+	buf := make([]byte, 0, len(signPrefix)+2+extensions.Size()+len(data))
+	buf = append(buf, signPrefix...)
+	buf = append(buf, byte(encode), byte(ackType))
+	for _, ext := range extensions {
+		if ext.Type == qc.ExtSignature || ext.Type == qc.ExtEncryption {
+			continue
+		}
+		buf = append(buf, byte(ext.Type))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(ext.Value)))
+		buf = append(buf, ext.Value...)
+	}
+	return append(buf, data...)
+}
+
+// Signer 消息签名者
+type Signer struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// NewSigner 以签名者ID与Ed25519私钥创建签名者，接收方需以同一ID信任对应的公钥
+func NewSigner(id string, key ed25519.PrivateKey) (*Signer, *errors.QError) {
+	if id == "" {
+		return nil, errors.New("签名者ID不能为空")
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("非法的Ed25519私钥")
+	}
+	return &Signer{id: id, key: key}, nil
+}
+
+// ID 签名者ID
+func (s *Signer) ID() string {
+	return s.id
+}
+
+// Sign 为配置为conf、数据为data的消息签名，签名时间、随机数与签名写入conf.Extensions，签名后不能再修改conf与data
+func (s *Signer) Sign(conf *qc.QTPConfig, data []byte) {
+	conf.Extensions = conf.Extensions.Clone()
+	conf.Extensions.Del(qc.ExtSignature)
+	var nonce [8]byte
+	_, _ = rand.Read(nonce[:])
+	conf.Extensions.SetSignedAt(time.Now(), binary.BigEndian.Uint64(nonce[:]))
+	signature := ed25519.Sign(s.key, signedBytes(conf.Encode, conf.ACKType, conf.Extensions, data))
+	conf.Extensions.SetSignature(s.id, signature)
+}
+
+// Interceptor 为路由匹配routes中任一规则的消息签名的出站拦截器，routes为空时为所有消息签名
+// 签名后修改消息会导致校验失败，因此该拦截器需配置在出站拦截器的最后
+// 路由匹配的数据流无法签名，不会发送，SendStream返回qc.UnsupportedError类型的异常
+func (s *Signer) Interceptor(routes ...string) (send.OutboundInterceptor, *errors.QError) {
+	for _, pattern := range routes {
+		if err := qc.CheckRoutePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return func(sr *qio.SendReq, next send.OutboundHandler) *errors.QError {
+		if len(routes) == 0 || matchAny(routes, sr.Config.Extensions.Route()) {
+			if _, ok := sr.Config.Extensions.Stream(); ok {
+				return errors.NewKind(qc.UnsupportedError, "数据流的签名无法覆盖后续分片，路由为\""+sr.Config.Extensions.Route()+"\"的数据流未发送")
+			}
+			s.Sign(&sr.Config, sr.Data)
+		}
+		return next(sr)
+	}, nil
+}
+
+func matchAny(patterns []string, route string) bool {
+	for _, pattern := range patterns {
+		if qc.MatchRoute(pattern, route) {
+			return true
+		}
+	}
+	return false
+}
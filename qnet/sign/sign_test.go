@@ -0,0 +1,219 @@
+package sign
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/qio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 以conf与data模拟接收方解析出的消息
+func received(conf qc.QTPConfig, data []byte) *qc.QTPData {
+	return &qc.QTPData{
+		Header: qc.QTPHeader{Encode: conf.Encode, MsgType: qc.RETRY, ACKType: conf.ACKType, Extensions: conf.Extensions},
+		Data:   data,
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	signer, err := NewSigner("deployer", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust := NewTrustStore()
+	if err := trust.Add("deployer", pub); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(trust, "cmd.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	conf.Extensions.SetRoute("cmd.deploy")
+	data := []byte(`{"version":"1.2.0"}`)
+	unsigned := conf
+	signer.Sign(&conf, data)
+	if unsigned.Extensions.Size() == conf.Extensions.Size() {
+		t.Error("Sign modified the caller's extensions")
+	}
+	if err := verifier.Verify(received(conf, data)); err != nil {
+		t.Fatal(err)
+	}
+	// 加密扩展不在签名范围内
+	encrypted := unsigned
+	signer.Sign(&encrypted, data)
+	encrypted.Extensions.SetEncryption(1)
+	if err := verifier.Verify(received(encrypted, data)); err != nil {
+		t.Errorf("expected encryption extension to be ignored, got %v", err)
+	}
+
+	tampered := []byte(`{"version":"6.6.6"}`)
+	if err := verifier.Verify(received(conf, tampered)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected tampered data to fail, got %v", err)
+	}
+	rerouted := conf
+	rerouted.Extensions = conf.Extensions.Clone()
+	rerouted.Extensions.SetRoute("cmd.shutdown")
+	if err := verifier.Verify(received(rerouted, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected rerouted message to fail, got %v", err)
+	}
+	if err := verifier.Verify(received(unsigned, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected unsigned command to fail, got %v", err)
+	}
+	other := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	other.Extensions.SetRoute("status.get")
+	if err := verifier.Verify(received(other, data)); err != nil {
+		t.Errorf("expected unsigned message outside required routes to pass, got %v", err)
+	}
+
+	trust.Remove("deployer")
+	if err := verifier.Verify(received(conf, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected untrusted signer to fail, got %v", err)
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	signer, _ := NewSigner("deployer", priv)
+	trust := NewTrustStore()
+	_ = trust.Add("deployer", pub)
+	verifier, _ := NewVerifier(trust, "cmd.*")
+
+	// 签名只覆盖第一个分片，带有签名的数据流即使签名有效也被拒绝
+	first := qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.AsyncACK}
+	first.Extensions.SetRoute("files.upload")
+	first.Extensions.SetStream(qc.StreamChunk{ID: 1})
+	signer.Sign(&first, []byte("chunk"))
+	if err := verifier.Verify(received(first, []byte("chunk"))); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected signed stream to fail, got %v", err)
+	}
+	required := qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.AsyncACK}
+	required.Extensions.SetRoute("cmd.deploy")
+	required.Extensions.SetStream(qc.StreamChunk{ID: 2})
+	if err := verifier.Verify(received(required, []byte("chunk"))); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected stream on a required route to fail, got %v", err)
+	}
+	other := qc.QTPConfig{Encode: qc.BINARY, ACKType: qc.AsyncACK}
+	other.Extensions.SetRoute("files.upload")
+	other.Extensions.SetStream(qc.StreamChunk{ID: 3})
+	if err := verifier.Verify(received(other, []byte("chunk"))); err != nil {
+		t.Errorf("expected unsigned stream outside required routes to pass, got %v", err)
+	}
+
+	// 签名拦截器不发送路由匹配的数据流
+	interceptor, _ := signer.Interceptor("cmd.*")
+	sr := &qio.SendReq{Data: []byte("chunk"), Config: required}
+	err := interceptor(sr, func(sr *qio.SendReq) *errors.QError {
+		t.Error("stream on a signed route was submitted")
+		return nil
+	})
+	if !err.IsKind(qc.UnsupportedError) {
+		t.Errorf("expected UnsupportedError, got %v", err)
+	}
+	if _, _, ok := sr.Config.Extensions.Signature(); ok {
+		t.Error("stream chunk was signed")
+	}
+}
+
+func TestSignatureFreshness(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	signer, _ := NewSigner("deployer", priv)
+	trust := NewTrustStore()
+	_ = trust.Add("deployer", pub)
+	verifier, _ := NewVerifier(trust)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	conf := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	data := []byte(`{"version":"1.2.0"}`)
+	signer.Sign(&conf, data)
+	if _, _, ok := conf.Extensions.SignedAt(); !ok {
+		t.Fatal("signature carries no timestamp")
+	}
+	if err := verifier.Verify(received(conf, data)); err != nil {
+		t.Fatal(err)
+	}
+	// 同一签名再次出现视为重放，重新签名后可以再次发送
+	if err := verifier.Verify(received(conf, data)); !err.IsKind(qc.ReplayError) {
+		t.Errorf("expected replayed signature to fail, got %v", err)
+	}
+	again := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	signer.Sign(&again, data)
+	if err := verifier.Verify(received(again, data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 超出时间窗口的签名无论是否使用过都被拒绝，过期的签名记录被清理
+	late := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	signer.Sign(&late, data)
+	now = now.Add(DefaultWindow + time.Second)
+	if err := verifier.Verify(received(late, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected expired signature to fail, got %v", err)
+	}
+	early := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	signer.Sign(&early, data)
+	now = now.Add(-2 * (DefaultWindow + time.Second))
+	if err := verifier.Verify(received(early, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected future signature to fail, got %v", err)
+	}
+	now = now.Add(4 * DefaultWindow)
+	fresh := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	signer.Sign(&fresh, data)
+	verifier.now = func() time.Time { return time.Now() }
+	if err := verifier.Verify(received(fresh, data)); err != nil {
+		t.Fatal(err)
+	}
+	verifier.mu.Lock()
+	verifier.swept = time.Time{}
+	verifier.sweep(now)
+	if len(verifier.seen) != 0 {
+		t.Errorf("expired signatures not swept: %d", len(verifier.seen))
+	}
+	verifier.mu.Unlock()
+
+	// 篡改签名时间会使签名失效，关闭时间窗口后接受没有签名时间的签名
+	tampered := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	signer.Sign(&tampered, data)
+	tampered.Extensions.SetSignedAt(time.Now(), 1)
+	if err := verifier.Verify(received(tampered, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected tampered timestamp to fail, got %v", err)
+	}
+	legacy := qc.QTPConfig{Encode: qc.JSON, ACKType: qc.SyncACK}
+	legacy.Extensions.SetSignature("deployer", ed25519.Sign(priv, signedBytes(legacy.Encode, legacy.ACKType, legacy.Extensions, data)))
+	if err := verifier.Verify(received(legacy, data)); !err.IsKind(qc.SignatureError) {
+		t.Errorf("expected signature without timestamp to fail, got %v", err)
+	}
+	verifier.SetWindow(0)
+	if err := verifier.Verify(received(legacy, data)); err != nil {
+		t.Errorf("expected signature without timestamp to pass with the window disabled, got %v", err)
+	}
+}
+
+func TestLoadTrustStore(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	path := filepath.Join(t.TempDir(), "trust.json")
+	content := `{"deployer": "` + base64.StdEncoding.EncodeToString(pub) + `"}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	trust, err := LoadTrustStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := trust.Get("deployer"); !ok || !key.Equal(pub) {
+		t.Error("trusted key not loaded")
+	}
+	if err := os.WriteFile(path, []byte(`{"deployer": "c2hvcnQ="}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTrustStore(path); err == nil {
+		t.Error("expected short key to be rejected")
+	}
+}
@@ -0,0 +1,214 @@
+package sign
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TrustStore 受信任的签名者公钥，可在运行中增删
+type TrustStore struct {
+	mu   sync.RWMutex
+	keys map[string]ed25519.PublicKey
+}
+
+// NewTrustStore 创建一个空的TrustStore
+func NewTrustStore() *TrustStore {
+	return &TrustStore{keys: make(map[string]ed25519.PublicKey)}
+}
+
+// LoadTrustStore 从JSON文件加载TrustStore，文件内容为签名者ID到base64编码公钥的映射
+func LoadTrustStore(path string) (*TrustStore, *errors.QError) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	keys := map[string][]byte{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, errors.New("公钥文件解析失败，" + err.Error())
+	}
+	t := NewTrustStore()
+	for id, key := range keys {
+		if err := t.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Add 信任签名者id的公钥，已存在时替换
+func (t *TrustStore) Add(id string, key ed25519.PublicKey) *errors.QError {
+	if len(key) != ed25519.PublicKeySize {
+		return errors.New("签名者" + id + "的公钥长度非法: " + strconv.Itoa(len(key)))
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keys[id] = append(ed25519.PublicKey(nil), key...)
+	return nil
+}
+
+// Remove 不再信任签名者id
+func (t *TrustStore) Remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.keys, id)
+}
+
+// Get 获取签名者id的公钥
+func (t *TrustStore) Get(id string) (ed25519.PublicKey, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	key, ok := t.keys[id]
+	return key, ok
+}
+
+// DefaultWindow 默认的签名时间窗口
+const DefaultWindow = 5 * time.Minute
+
+// Verifier 消息签名校验器
+// 带有签名的消息必须通过校验；路由匹配required中任一规则的消息必须带有签名
+// 签名时间与当前时间相差超过时间窗口的签名视为过期，时间窗口内随机数重复的签名视为重放
+type Verifier struct {
+	trust    *TrustStore
+	required []string
+
+	mu     sync.Mutex
+	window time.Duration
+	// 时间窗口内已接受的签名，以签名者ID与随机数为key，值为签名时间
+	seen map[signedNonce]time.Time
+	// 上次清理过期签名记录的时间
+	swept time.Time
+	// 获取当前时间，测试时替换
+	now func() time.Time
+}
+
+// 一次签名的签名者ID与随机数
+type signedNonce struct {
+	signer string
+	nonce  uint64
+}
+
+// NewVerifier 以trust创建校验器，required为必须签名的路由规则，"*"表示所有消息都必须签名，时间窗口为DefaultWindow
+func NewVerifier(trust *TrustStore, required ...string) (*Verifier, *errors.QError) {
+	for _, pattern := range required {
+		if err := qc.CheckRoutePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return &Verifier{
+		trust:    trust,
+		required: required,
+		window:   DefaultWindow,
+		seen:     make(map[signedNonce]time.Time),
+		now:      time.Now,
+	}, nil
+}
+
+// SetWindow 设置签名时间窗口，需大于双方的时钟偏差与消息的最长传输时间（包括重发）
+// 不大于0时不检查签名时间与随机数，可以接受没有签名时间的旧版本签名
+func (v *Verifier) SetWindow(window time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.window = window
+}
+
+// Verify 校验消息签名，缺少必需的签名、签名者不受信任、签名无效或过期时返回qc.SignatureError类型的异常
+// 签名的随机数在时间窗口内已被接受时返回qc.ReplayError类型的异常
+// 数据流的分片见verifyStream
+func (v *Verifier) Verify(data *qc.QTPData) *errors.QError {
+	if _, ok := data.Stream(); ok {
+		return v.verifyStream(data)
+	}
+	signer, signature, ok := data.Header.Extensions.Signature()
+	if !ok {
+		if matchAny(v.required, data.Route()) {
+			return errors.NewKind(qc.SignatureError, "消息缺少签名")
+		}
+		return nil
+	}
+	key, ok := v.trust.Get(signer)
+	if !ok {
+		return errors.NewKind(qc.SignatureError, "签名者"+signer+"不受信任")
+	}
+	if !ed25519.Verify(key, signedBytes(data.Header.Encode, data.Header.ACKType, data.Header.Extensions, data.Data), signature) {
+		return errors.NewKind(qc.SignatureError, "签名者"+signer+"的签名无效")
+	}
+	return v.fresh(signer, data.Header.Extensions)
+}
+
+// 校验数据流的分片，签名只能覆盖单个分片，无法保证其他分片未被篡改
+// 路由必须签名的数据流与带有签名的数据流都返回qc.SignatureError类型的异常，避免回调将只签名了第一个分片的数据流视为可信
+func (v *Verifier) verifyStream(data *qc.QTPData) *errors.QError {
+	if signer, _, ok := data.Header.Extensions.Signature(); ok {
+		return errors.NewKind(qc.SignatureError, "签名者"+signer+"的签名无法覆盖数据流的全部分片，不接受带有签名的数据流")
+	}
+	if matchAny(v.required, data.Route()) {
+		return errors.NewKind(qc.SignatureError, "路由必须签名，数据流无法签名，需以普通消息发送")
+	}
+	return nil
+}
+
+// 检查已通过校验的签名是否在时间窗口内且未被使用过，是则记录签名的随机数
+func (v *Verifier) fresh(signer string, extensions qc.Extensions) *errors.QError {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.window <= 0 {
+		return nil
+	}
+	signedAt, nonce, ok := extensions.SignedAt()
+	if !ok {
+		return errors.NewKind(qc.SignatureError, "签名者"+signer+"的签名缺少签名时间")
+	}
+	now := v.now()
+	if age := now.Sub(signedAt); age > v.window || -age > v.window {
+		return errors.NewKind(qc.SignatureError, "签名者"+signer+"的签名时间"+signedAt.Format(time.DateTime)+"超出了"+v.window.String()+"的时间窗口")
+	}
+	v.sweep(now)
+	key := signedNonce{signer: signer, nonce: nonce}
+	if _, ok := v.seen[key]; ok {
+		return errors.NewKind(qc.ReplayError, "签名者"+signer+"的签名已被使用，消息重放")
+	}
+	v.seen[key] = signedAt
+	return nil
+}
+
+// 清理已超出时间窗口的签名记录，每个时间窗口最多清理一次，需持有锁
+func (v *Verifier) sweep(now time.Time) {
+	if now.Sub(v.swept) < v.window {
+		return
+	}
+	v.swept = now
+	for key, signedAt := range v.seen {
+		if now.Sub(signedAt) > v.window {
+			delete(v.seen, key)
+		}
+	}
+}
+
+// Interceptor 校验消息签名的入站拦截器，校验失败的消息不会交给回调，发送方的LCE收到qc.SignatureError类型的异常
+// 数据流在第一个分片时校验，路由必须签名或带有签名的数据流被拒绝；对端发起的逻辑通道没有可签名的数据，不做校验，逻辑通道内的消息不经过入站拦截器
+// 签名已被使用的RETRY消息是已送达消息的重发，直接确认而不再交给回调
+func (v *Verifier) Interceptor() receive.InboundInterceptor {
+	return func(sender *send.QTPSender, data *qc.QTPData, next receive.InboundHandler) {
+		if _, ok := data.Channel(); ok {
+			next(sender, data)
+			return
+		}
+		if err := v.Verify(data); err != nil {
+			if err.IsKind(qc.ReplayError) && data.Header.MsgType == qc.RETRY {
+				return
+			}
+			sender.GetLogger().Warn("拒绝了路由为\"" + data.Route() + "\"的消息：" + err.Error())
+			data.RejectWith(qc.Nack{Reason: err.Error(), Code: qc.NackSignatureInvalid})
+			return
+		}
+		next(sender, data)
+	}
+}
@@ -0,0 +1,467 @@
+package transfer
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 文件传输使用的消息路由
+const (
+	routeOffer  = "qtp.transfer.offer"
+	routeAccept = "qtp.transfer.accept"
+	routeResult = "qtp.transfer.result"
+	// 数据流路由的前缀，后接传输ID
+	routeData = "qtp.transfer.data:"
+)
+
+// 未完成文件的后缀，完整路径为 保存路径.传输ID.part
+const partSuffix = ".part"
+
+// Offer 发送方发起的文件传输请求
+type Offer struct {
+	// 传输ID，由文件名、长度与摘要生成，同一文件的多次传输ID相同，用于断点续传
+	ID string `json:"id"`
+	// 文件名，不包含目录，接收方拒绝包含路径分隔符、"."或".."的文件名
+	Name string `json:"name"`
+	// 文件byte长度
+	Size int64 `json:"size"`
+	// 文件的SHA-256摘要（hex）
+	Hash string `json:"hash"`
+}
+
+// Accept 接收方对Offer的应答
+type Accept struct {
+	ID       string `json:"id"`
+	Accepted bool   `json:"accepted"`
+	// 接收方已确认写入的byte数，发送方从该位置继续发送
+	Offset int64 `json:"offset"`
+	// 拒绝原因
+	Reason string `json:"reason,omitempty"`
+}
+
+// Result 接收方校验完毕后的传输结果
+type Result struct {
+	ID string `json:"id"`
+	OK bool   `json:"ok"`
+	// 失败原因
+	Reason string `json:"reason,omitempty"`
+}
+
+// Config 文件传输配置
+type Config struct {
+	// 接收方决定是否接收文件，返回文件的保存路径，返回异常时拒绝；为nil时拒绝所有文件
+	OnOffer func(offer Offer) (string, error)
+	// 传输进度回调，收发两端都会调用，transferred为已发送或已写入的byte数（包含续传前已确认的部分）
+	OnProgress func(offer Offer, transferred int64)
+	// 等待对端应答的最长时间
+	Timeout time.Duration
+	// 传输中断后重新发起续传的最大次数
+	ResumeAttempts int
+	// 两次续传之间的等待时间
+	ResumeDelay time.Duration
+}
+
+// ConfigDefault Get默认文件传输配置
+func ConfigDefault() Config {
+	return Config{
+		Timeout:        10 * time.Second,
+		ResumeAttempts: 3,
+		ResumeDelay:    time.Second,
+	}
+}
+
+// Service 文件传输服务，需在收发两端的CallBackHandler上注册
+// 发送方发送Offer，接收方应答可续传的位置，随后通过SendStream发送剩余数据，接收方写入完毕后校验SHA-256并应答Result
+type Service struct {
+	conf Config
+	mu   sync.Mutex
+	// 等待对端应答的传输
+	accepts map[string]chan Accept
+	results map[string]chan Result
+	// 接收中的传输
+	incoming map[string]*incoming
+}
+
+// 接收中的文件
+type incoming struct {
+	offer Offer
+	// 保存路径
+	path string
+	// 未完成文件的路径
+	part string
+	// 已确认写入的byte数
+	offset int64
+	// 写入未完成文件期间持有，同一文件的续传请求等待上一次写入结束后再确定续传位置
+	writing sync.Mutex
+}
+
+// NewService 新建文件传输服务
+func NewService(conf Config) *Service {
+	return &Service{
+		conf:     conf,
+		accepts:  make(map[string]chan Accept),
+		results:  make(map[string]chan Result),
+		incoming: make(map[string]*incoming),
+	}
+}
+
+// Register 在CallBackHandler上注册文件传输回调
+// 会占用CallBackHandler的Stream回调，需要同时接收其他数据流时改为在自己的Stream回调中调用HandleStream
+func (s *Service) Register(h *receive.CallBackHandler) {
+	for _, route := range []string{routeOffer, routeAccept, routeResult} {
+		_ = h.Route(route, s.HandleMessage)
+	}
+	h.Stream(func(sender *send.QTPSender, stream *receive.Stream) {
+		s.HandleStream(sender, stream)
+	})
+}
+
+// HandleMessage 处理文件传输的请求与应答消息，其他消息直接忽略
+func (s *Service) HandleMessage(sender *send.QTPSender, data *qc.QTPData) {
+	switch data.Route() {
+	case routeOffer:
+		var offer Offer
+		if json.Unmarshal(data.Data, &offer) != nil {
+			return
+		}
+		s.reply(sender, routeAccept, s.onOffer(offer))
+	case routeAccept:
+		var accept Accept
+		if json.Unmarshal(data.Data, &accept) != nil {
+			return
+		}
+		s.mu.Lock()
+		ch := s.accepts[accept.ID]
+		s.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- accept:
+			default:
+			}
+		}
+	case routeResult:
+		var result Result
+		if json.Unmarshal(data.Data, &result) != nil {
+			return
+		}
+		s.mu.Lock()
+		ch := s.results[result.ID]
+		s.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- result:
+			default:
+			}
+		}
+	}
+}
+
+// HandleStream 接收文件数据流，不是文件传输的数据流时返回false且不读取
+func (s *Service) HandleStream(sender *send.QTPSender, stream *receive.Stream) bool {
+	route := stream.Header.Extensions.Route()
+	if !strings.HasPrefix(route, routeData) {
+		return false
+	}
+	id := strings.TrimPrefix(route, routeData)
+	s.mu.Lock()
+	in := s.incoming[id]
+	s.mu.Unlock()
+	if in == nil {
+		return true
+	}
+	in.writing.Lock()
+	result, ok := s.receive(in, stream)
+	in.writing.Unlock()
+	if ok {
+		s.mu.Lock()
+		// 续传请求可能已替换为新的记录
+		if s.incoming[id] == in {
+			delete(s.incoming, id)
+		}
+		s.mu.Unlock()
+		s.reply(sender, routeResult, result)
+	}
+	return true
+}
+
+// 根据OnOffer的结果应答，存在未完成文件时从其长度处续传
+func (s *Service) onOffer(offer Offer) Accept {
+	accept := Accept{ID: offer.ID}
+	if s.conf.OnOffer == nil {
+		accept.Reason = "对端不接收文件"
+		return accept
+	}
+	if offer.ID == "" || offer.ID != offerID(offer.Name, offer.Size, offer.Hash) {
+		accept.Reason = "非法的传输请求"
+		return accept
+	}
+	if !validName(offer.Name) {
+		accept.Reason = "非法的文件名" + strconv.Quote(offer.Name)
+		return accept
+	}
+	path, err := s.conf.OnOffer(offer)
+	if err != nil {
+		accept.Reason = err.Error()
+		return accept
+	}
+	s.mu.Lock()
+	prev := s.incoming[offer.ID]
+	s.mu.Unlock()
+	if prev != nil {
+		// 连接断开后旧数据流中已收到的分片仍在写入，写入结束后未完成文件的长度才是已确认的位置
+		prev.writing.Lock()
+		prev.writing.Unlock()
+	}
+	in := &incoming{offer: offer, path: path, part: path + "." + offer.ID + partSuffix}
+	if info, err := os.Stat(in.part); err == nil && info.Size() <= offer.Size {
+		in.offset = info.Size()
+	}
+	s.mu.Lock()
+	s.incoming[offer.ID] = in
+	s.mu.Unlock()
+	accept.Accepted = true
+	accept.Offset = in.offset
+	return accept
+}
+
+// 将数据流写入未完成文件，数据流中断时保留已写入的部分并返回false
+func (s *Service) receive(in *incoming, stream *receive.Stream) (Result, bool) {
+	result := Result{ID: in.offer.ID}
+	file, err := os.OpenFile(in.part, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err == nil {
+		// 丢弃超出已确认位置的部分，与应答给发送方的续传位置保持一致
+		err = file.Truncate(in.offset)
+	}
+	if err == nil {
+		_, err = file.Seek(in.offset, io.SeekStart)
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		result.Reason = err.Error()
+		return result, true
+	}
+	// 最多写入声明长度之后1byte，用于发现超出声明长度的数据
+	limited := io.LimitReader(stream, in.offer.Size-in.offset+1)
+	_, err = io.Copy(&progressWriter{writer: file, offer: in.offer, transferred: &in.offset, onProgress: s.conf.OnProgress}, limited)
+	syncErr := file.Sync()
+	_ = file.Close()
+	if in.offset > in.offer.Size {
+		// 数据超出声明的长度，删除未完成文件，剩余的分片在回调结束后被丢弃
+		_ = os.Remove(in.part)
+		result.Reason = "数据长度超过声明的" + strconv.FormatInt(in.offer.Size, 10) + "byte"
+		return result, true
+	}
+	if err != nil {
+		return result, false
+	}
+	if syncErr != nil {
+		result.Reason = syncErr.Error()
+		return result, true
+	}
+	if in.offset != in.offer.Size {
+		// 数据流正常结束但长度不足，删除未完成文件，下一次传输从头开始
+		_ = os.Remove(in.part)
+		result.Reason = "文件长度不符，期望" + strconv.FormatInt(in.offer.Size, 10) + "byte，实际" + strconv.FormatInt(in.offset, 10) + "byte"
+		return result, true
+	}
+
+	hash, err := fileHash(in.part)
+	if err != nil {
+		result.Reason = err.Error()
+		return result, true
+	}
+	if hash != in.offer.Hash {
+		// 校验失败时删除未完成文件，下一次传输从头开始
+		_ = os.Remove(in.part)
+		result.Reason = "SHA-256校验失败，期望" + in.offer.Hash + "，实际" + hash
+		return result, true
+	}
+	if err = os.Rename(in.part, in.path); err != nil {
+		result.Reason = err.Error()
+		return result, true
+	}
+	result.OK = true
+	return result, true
+}
+
+// 以JSON发送请求或应答
+func (s *Service) reply(sender *send.QTPSender, route string, v any) *errors.QError {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	conf := qc.QTPConfig{Encode: qc.JSON, MsgType: qc.DATA, ACKType: qc.AsyncACK}
+	conf.Extensions.SetRoute(route)
+	done := make(chan *errors.QError, 1)
+	sender.Send(data, conf, func(seq uint64, err *errors.QError) {
+		done <- err
+	})
+	return <-done
+}
+
+// SendFile 向对端发送文件，对端校验通过后返回
+// 传输中断（如连接断开后重连）时从对端已确认的位置续传，最多续传ResumeAttempts次；对端拒绝时直接返回
+func (s *Service) SendFile(sender *send.QTPSender, path string) *errors.QError {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	hash, err := readerHash(file)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	offer := Offer{Name: filepath.Base(path), Size: info.Size(), Hash: hash}
+	offer.ID = offerID(offer.Name, offer.Size, offer.Hash)
+
+	var qErr *errors.QError
+	for attempt := 0; attempt <= s.conf.ResumeAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.conf.ResumeDelay)
+		}
+		var retry bool
+		qErr, retry = s.send(sender, file, offer)
+		if qErr == nil || !retry {
+			return qErr
+		}
+	}
+	qErr.WithMessage("文件" + offer.Name + "在" + strconv.Itoa(s.conf.ResumeAttempts) + "次续传后仍未发送成功")
+	return qErr
+}
+
+// 发送一次文件，返回的bool表示失败后是否可以续传
+func (s *Service) send(sender *send.QTPSender, file *os.File, offer Offer) (*errors.QError, bool) {
+	accepts := make(chan Accept, 1)
+	results := make(chan Result, 1)
+	s.mu.Lock()
+	s.accepts[offer.ID] = accepts
+	s.results[offer.ID] = results
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.accepts, offer.ID)
+		delete(s.results, offer.ID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.reply(sender, routeOffer, offer); err != nil {
+		return err, true
+	}
+	var accept Accept
+	select {
+	case accept = <-accepts:
+	case <-time.After(s.conf.Timeout):
+		return errors.New("等待对端应答超时"), true
+	}
+	if !accept.Accepted {
+		return errors.New("对端拒绝接收文件" + offer.Name + "：" + accept.Reason), false
+	}
+	if accept.Offset < 0 || accept.Offset > offer.Size {
+		return errors.New("对端应答了非法的续传位置" + strconv.FormatInt(accept.Offset, 10)), false
+	}
+	if _, err := file.Seek(accept.Offset, io.SeekStart); err != nil {
+		return errors.New(err.Error()), false
+	}
+
+	conf := qc.QTPConfig{Encode: qc.BINARY}
+	conf.Extensions.SetRoute(routeData + offer.ID)
+	transferred := accept.Offset
+	reader := &progressReader{reader: file, offer: offer, transferred: &transferred, onProgress: s.conf.OnProgress}
+	if err := sender.SendStream(reader, conf); err != nil {
+		return err, true
+	}
+	select {
+	case result := <-results:
+		if !result.OK {
+			return errors.New("文件" + offer.Name + "传输失败：" + result.Reason), true
+		}
+		return nil, false
+	case <-time.After(s.conf.Timeout):
+		return errors.New("等待对端校验结果超时"), true
+	}
+}
+
+// 文件名是否不包含目录，OnOffer通常将文件名与保存目录拼接，包含目录的文件名可写入保存目录之外
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`) && name == filepath.Base(name)
+}
+
+// 传输ID
+func offerID(name string, size int64, hash string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + strconv.FormatInt(size, 10) + "\x00" + hash))
+	return hex.EncodeToString(sum[:16])
+}
+
+func readerHash(reader io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readerHash(file)
+}
+
+// 统计发送进度的Reader
+type progressReader struct {
+	reader      io.Reader
+	offer       Offer
+	transferred *int64
+	onProgress  func(offer Offer, transferred int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if n > 0 {
+		*p.transferred += int64(n)
+		if p.onProgress != nil {
+			p.onProgress(p.offer, *p.transferred)
+		}
+	}
+	return n, err
+}
+
+// 统计写入进度的Writer，同时更新已确认写入的byte数
+type progressWriter struct {
+	writer      io.Writer
+	offer       Offer
+	transferred *int64
+	onProgress  func(offer Offer, transferred int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	if n > 0 {
+		*p.transferred += int64(n)
+		if p.onProgress != nil {
+			p.onProgress(p.offer, *p.transferred)
+		}
+	}
+	return n, err
+}
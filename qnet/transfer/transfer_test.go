@@ -0,0 +1,292 @@
+package transfer
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 文件传输的收发两端
+type testTransfer struct {
+	// 发送方的文件传输服务与连接
+	sending *Service
+	sender  *send.QTPSender
+	// 接收方的进度
+	progress *progress
+}
+
+func (tt *testTransfer) sendFile(path string) *errors.QError {
+	return tt.sending.SendFile(tt.sender, path)
+}
+
+// 启动接收文件的Server与发送文件的Client，文件保存在dir中，setup不为nil时可修改接收方的配置与回调
+func startTransfer(t *testing.T, dir string, setup func(conf *Config, cb *receive.CallBackHandler)) *testTransfer {
+	p := &progress{}
+	conf := ConfigDefault()
+	conf.ResumeDelay = 10 * time.Millisecond
+	conf.OnOffer = func(offer Offer) (string, error) {
+		if offer.Name == "rejected.bin" {
+			return "", os.ErrPermission
+		}
+		return filepath.Join(dir, offer.Name), nil
+	}
+	conf.OnProgress = p.record
+	serverCb := receive.NewCallBackHandler()
+	if setup != nil {
+		setup(&conf, serverCb)
+	}
+	receiver := NewService(conf)
+	receiver.Register(serverCb)
+	server, err := qnet.NewQuantumServerWithConfig("127.0.0.1:0", serverCb, qnet.QTPServerConfigDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+
+	sending := NewService(ConfigDefault())
+	clientCb := receive.NewCallBackHandler()
+	sending.Register(clientCb)
+	sender, err := qnet.NewQuantumClientWithConfig(server.Addr().String(), qnet.QTPClientConfigDefault(), clientCb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sender.Close() })
+	return &testTransfer{sending: sending, sender: sender, progress: p}
+}
+
+// 记录接收方的进度回调
+type progress struct {
+	mu     sync.Mutex
+	values []int64
+}
+
+func (p *progress) record(offer Offer, transferred int64) {
+	p.mu.Lock()
+	p.values = append(p.values, transferred)
+	p.mu.Unlock()
+}
+
+func (p *progress) take() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	values := p.values
+	p.values = nil
+	return values
+}
+
+func writeFile(t *testing.T, dir, name string, size int) (string, []byte) {
+	data := make([]byte, size)
+	_, _ = rand.Read(data)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func TestSendFile(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tt := startTransfer(t, dst, nil)
+	sendFile, p := tt.sendFile, tt.progress
+	path, data := writeFile(t, src, "model.bin", 300<<10)
+
+	if err := sendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	received, err := os.ReadFile(filepath.Join(dst, "model.bin"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("received file mismatch: %d bytes, %v", len(received), err)
+	}
+	values := p.take()
+	if len(values) == 0 || values[len(values)-1] != int64(len(data)) {
+		t.Errorf("unexpected progress %v", values)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dst, "*"+partSuffix)); len(parts) != 0 {
+		t.Errorf("part files left behind: %v", parts)
+	}
+
+	rejected, _ := writeFile(t, src, "rejected.bin", 10)
+	if err := sendFile(rejected); err == nil {
+		t.Error("expected rejected transfer to fail")
+	}
+}
+
+func TestSendFileResume(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tt := startTransfer(t, dst, nil)
+	sendFile, p := tt.sendFile, tt.progress
+	path, data := writeFile(t, src, "logs.tar", 500<<10)
+	offer := Offer{Name: "logs.tar", Size: int64(len(data))}
+	offer.Hash, _ = fileHash(path)
+	part := filepath.Join(dst, "logs.tar."+offerID(offer.Name, offer.Size, offer.Hash)+partSuffix)
+
+	// 上一次传输中断时已确认写入的部分
+	confirmed := 200 << 10
+	if err := os.WriteFile(part, data[:confirmed], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	received, err := os.ReadFile(filepath.Join(dst, "logs.tar"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("resumed file mismatch: %d bytes, %v", len(received), err)
+	}
+	if values := p.take(); len(values) == 0 || values[0] <= int64(confirmed) {
+		t.Errorf("transfer did not resume from %d: %v", confirmed, values)
+	}
+
+	// 未完成文件内容损坏时校验失败，删除后从头重新传输
+	corrupt := append([]byte(nil), data[:confirmed]...)
+	corrupt[0] ^= 0xFF
+	if err := os.WriteFile(part, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	received, err = os.ReadFile(filepath.Join(dst, "logs.tar"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("retransmitted file mismatch: %d bytes, %v", len(received), err)
+	}
+	if values := p.take(); len(values) == 0 || values[len(values)-1] != int64(len(data)) {
+		t.Errorf("unexpected progress %v", values)
+	}
+}
+
+func TestSendFileReconnect(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	conns := make(chan *send.QTPSender, 4)
+	var once sync.Once
+	var dropped atomic.Int64
+	tt := startTransfer(t, dst, func(conf *Config, cb *receive.CallBackHandler) {
+		cb.ConnInit(func(sender *send.QTPSender) {
+			conns <- sender
+		})
+		record := conf.OnProgress
+		conf.OnProgress = func(offer Offer, transferred int64) {
+			record(offer, transferred)
+			if transferred < 256<<10 {
+				return
+			}
+			// 传输过程中断开服务端连接，客户端重连后续传
+			once.Do(func() {
+				dropped.Store(transferred)
+				_ = (<-conns).GetQTPConn().Close()
+			})
+		}
+	})
+	path, data := writeFile(t, src, "dataset.bin", 2<<20)
+
+	if err := tt.sendFile(path); err != nil {
+		t.Fatal(err)
+	}
+	received, err := os.ReadFile(filepath.Join(dst, "dataset.bin"))
+	if err != nil || !bytes.Equal(received, data) {
+		t.Fatalf("received file mismatch: %d bytes, %v", len(received), err)
+	}
+	if dropped.Load() == 0 {
+		t.Fatal("connection was not dropped")
+	}
+	select {
+	case <-conns:
+	default:
+		t.Fatal("client did not reconnect")
+	}
+	// 续传从断开前已写入的位置继续，而不是从头开始
+	values := tt.progress.take()
+	for i, v := range values {
+		if v == dropped.Load() {
+			for _, resumed := range values[i+1:] {
+				if resumed < 128<<10 {
+					t.Fatalf("transfer restarted from the beginning: %v", values)
+				}
+			}
+			break
+		}
+	}
+}
+
+func TestSendFileSizeMismatch(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tt := startTransfer(t, dst, nil)
+	path, data := writeFile(t, src, "liar.bin", 100<<10)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for size, reason := range map[int]string{10: "超过", 200 << 10: "长度不符"} {
+		// 声明的长度与实际发送的数据不符
+		offer := Offer{Name: "liar.bin", Size: int64(size)}
+		declared := data
+		if size < len(data) {
+			declared = data[:size]
+		}
+		offer.Hash, _ = readerHash(bytes.NewReader(declared))
+		offer.ID = offerID(offer.Name, offer.Size, offer.Hash)
+		qErr, _ := tt.sending.send(tt.sender, file, offer)
+		if qErr == nil || !strings.Contains(qErr.Error(), reason) {
+			t.Errorf("size %d: expected %q, got %v", size, reason, qErr)
+		}
+		if parts, _ := filepath.Glob(filepath.Join(dst, "*")); len(parts) != 0 {
+			t.Errorf("size %d: files left behind: %v", size, parts)
+		}
+	}
+}
+
+func TestSendFileInvalidName(t *testing.T) {
+	src, root := t.TempDir(), t.TempDir()
+	dst := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var offered atomic.Int32
+	tt := startTransfer(t, dst, func(conf *Config, cb *receive.CallBackHandler) {
+		onOffer := conf.OnOffer
+		conf.OnOffer = func(offer Offer) (string, error) {
+			offered.Add(1)
+			return onOffer(offer)
+		}
+	})
+	path, data := writeFile(t, src, "escape.bin", 1<<10)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// 文件名包含目录时拒绝，不会交给OnOffer，也不会在保存目录之外写入文件
+	for _, name := range []string{"../../escape.bin", "sub/escape.bin", `..\escape.bin`, "..", ".", ""} {
+		offer := Offer{Name: name, Size: int64(len(data))}
+		offer.Hash, _ = readerHash(bytes.NewReader(data))
+		offer.ID = offerID(offer.Name, offer.Size, offer.Hash)
+		qErr, retry := tt.sending.send(tt.sender, file, offer)
+		if qErr == nil || retry || !strings.Contains(qErr.Error(), "非法的文件名") {
+			t.Errorf("name %q: expected refusal, got %v", name, qErr)
+		}
+	}
+	if n := offered.Load(); n != 0 {
+		t.Errorf("OnOffer called %d times for invalid names", n)
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("unexpected file %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
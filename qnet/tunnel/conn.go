@@ -0,0 +1,302 @@
+package tunnel
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 隧道将一个逻辑通道包装为net.Conn，写入的数据以通道内的SyncACK消息发送
+// 接收方在数据被读取完毕后才确认消息，发送方收到确认后才发送下一个消息，以此实现每个隧道独立的流量控制
+
+// 单个消息数据的最大byte长度，超过时切分为多个消息
+const chunkSize = 64 << 10
+
+// Network 隧道地址的网络名称
+const Network = "qtp"
+
+// Addr 隧道地址
+type Addr struct {
+	// 通道名称
+	Name string
+	// 通道ID
+	ID uint32
+}
+
+// Network 实现net.Addr
+func (a Addr) Network() string {
+	return Network
+}
+
+// String 实现net.Addr
+func (a Addr) String() string {
+	return a.Name + "#" + strconv.FormatUint(uint64(a.ID), 10)
+}
+
+// Conn 基于逻辑通道的net.Conn
+type Conn struct {
+	ch *send.Channel
+	mu sync.Mutex
+	// 已收到但尚未读取的数据
+	buf []byte
+	// 有新数据或通道关闭时通知
+	readable chan struct{}
+	// buf被读取完毕时通知
+	drained chan struct{}
+	// 通道关闭的原因，为nil时表示尚未关闭
+	readErr error
+	// 本端关闭后关闭
+	done      chan struct{}
+	closeOnce sync.Once
+	rDeadline *deadline
+	wDeadline *deadline
+}
+
+func newConn() *Conn {
+	return &Conn{
+		readable:  make(chan struct{}, 1),
+		drained:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		rDeadline: newDeadline(),
+		wDeadline: newDeadline(),
+	}
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// 通道处理器，所有回调都在通道协程中执行
+func (c *Conn) handler() *receive.ChannelHandler {
+	h := receive.NewChannelHandler()
+	h.SyncACK(func(ch *send.Channel, data *qc.QTPData) {
+		c.push(ch, data.Data)
+	})
+	h.Closed(func(ch *send.Channel, err *errors.QError) {
+		c.closeRead(err)
+	})
+	return h
+}
+
+// 追加收到的数据，阻塞至数据被读取完毕后返回，返回后消息才会被确认
+func (c *Conn) push(ch *send.Channel, data []byte) {
+	c.mu.Lock()
+	c.buf = append(c.buf, data...)
+	c.mu.Unlock()
+	notify(c.readable)
+	for {
+		c.mu.Lock()
+		empty := len(c.buf) == 0
+		c.mu.Unlock()
+		if empty {
+			return
+		}
+		select {
+		case <-c.drained:
+		case <-c.done:
+			return
+		case <-ch.Done():
+			return
+		}
+	}
+}
+
+// 通道关闭后，读取完剩余的数据时返回err，对端正常关闭时返回io.EOF
+func (c *Conn) closeRead(err *errors.QError) {
+	c.mu.Lock()
+	switch {
+	case err == nil || err.IsKind(qc.ChannelClosedError):
+		c.readErr = io.EOF
+	default:
+		c.readErr = err
+	}
+	c.mu.Unlock()
+	notify(c.readable)
+}
+
+// Read 实现net.Conn，对端关闭隧道后返回io.EOF
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-c.done:
+			return 0, net.ErrClosed
+		default:
+		}
+		c.mu.Lock()
+		if len(c.buf) > 0 {
+			n := copy(p, c.buf)
+			c.buf = c.buf[n:]
+			if len(c.buf) == 0 {
+				c.buf = nil
+				notify(c.drained)
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		err := c.readErr
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-c.readable:
+		case <-c.done:
+		case <-c.rDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Write 实现net.Conn，数据被对端读取后返回
+// 写入超时返回时已提交的数据依然可能被发送
+func (c *Conn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.wDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	results := make([]chan *errors.QError, 0, len(p)/chunkSize+1)
+	for offset := 0; offset < len(p); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		result := make(chan *errors.QError, 1)
+		results = append(results, result)
+		c.ch.SendSyncACK(append([]byte(nil), p[offset:end]...), qc.BINARY, func(seq uint64, err *errors.QError) {
+			result <- err
+		})
+	}
+	n := 0
+	for _, result := range results {
+		select {
+		case err := <-result:
+			if err != nil {
+				return n, err
+			}
+		case <-c.done:
+			return n, net.ErrClosed
+		case <-c.wDeadline.wait():
+			return n, os.ErrDeadlineExceeded
+		}
+		n += chunkSize
+		if n > len(p) {
+			n = len(p)
+		}
+	}
+	return n, nil
+}
+
+// Close 实现net.Conn，等待已写入的数据被对端确认后关闭隧道并通知对端
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = nil
+		// 对端已关闭通道时无需再通知
+		_ = c.ch.Close()
+	})
+	return err
+}
+
+// Channel 隧道使用的逻辑通道
+func (c *Conn) Channel() *send.Channel {
+	return c.ch
+}
+
+// LocalAddr 实现net.Conn
+func (c *Conn) LocalAddr() net.Addr {
+	return Addr{Name: c.ch.Name(), ID: c.ch.ID()}
+}
+
+// RemoteAddr 实现net.Conn，对端的通道ID与本端的ChannelAcceptor位相反
+func (c *Conn) RemoteAddr() net.Addr {
+	return Addr{Name: c.ch.Name(), ID: c.ch.ID() ^ qc.ChannelAcceptor}
+}
+
+// SetDeadline 实现net.Conn
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.rDeadline.set(t)
+	c.wDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline 实现net.Conn
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.rDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline 实现net.Conn
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.wDeadline.set(t)
+	return nil
+}
+
+// Dial 在sender所在的连接上打开名称为name的隧道，对端需通过Listen接受同名隧道
+func Dial(sender *send.QTPSender, name string) (*Conn, *errors.QError) {
+	conn := newConn()
+	ch, err := receive.OpenChannel(sender, name, conn.handler())
+	if err != nil {
+		return nil, err
+	}
+	conn.ch = ch
+	return conn, nil
+}
+
+// 读写超时，超时后wait返回的通道被关闭，重新设置时间后换用新的通道
+type deadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{expired: make(chan struct{})}
+}
+
+// 设置超时时间，零值表示不超时
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// 计时器已触发，等待其关闭expired
+		<-d.expired
+	}
+	d.timer = nil
+	select {
+	case <-d.expired:
+		d.expired = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		close(d.expired)
+		return
+	}
+	expired := d.expired
+	d.timer = time.AfterFunc(dur, func() {
+		close(expired)
+	})
+}
+
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"QuantumUtils/errors"
+	"QuantumUtils/qnet/qc"
+	"QuantumUtils/qnet/receive"
+	"QuantumUtils/qnet/send"
+	"net"
+	"sync"
+)
+
+// 等待Accept的隧道数量上限，达到上限时新隧道的建立会阻塞在对应的通道协程中
+const backlog = 16
+
+// Listener 接受对端通过Dial打开的隧道，实现net.Listener
+type Listener struct {
+	name  string
+	conns sync.Map
+	// 等待Accept的隧道
+	accept chan *Conn
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Listen 在h上接受名称为name的隧道，h可以是Server的CallBackHandler，此时所有连接上的同名隧道都由返回的Listener接受
+func Listen(h *receive.CallBackHandler, name string) *Listener {
+	l := &Listener{
+		name:   name,
+		accept: make(chan *Conn, backlog),
+		done:   make(chan struct{}),
+	}
+	handler := receive.NewChannelHandler()
+	handler.Opened(l.opened)
+	handler.SyncACK(func(ch *send.Channel, data *qc.QTPData) {
+		if conn, ok := l.conns.Load(ch); ok {
+			conn.(*Conn).push(ch, data.Data)
+		}
+	})
+	handler.Closed(func(ch *send.Channel, err *errors.QError) {
+		if conn, ok := l.conns.LoadAndDelete(ch); ok {
+			conn.(*Conn).closeRead(err)
+		}
+	})
+	h.Channel(name, handler)
+	return l
+}
+
+func (l *Listener) opened(ch *send.Channel) {
+	conn := newConn()
+	conn.ch = ch
+	l.conns.Store(ch, conn)
+	select {
+	case l.accept <- conn:
+	case <-l.done:
+		l.conns.Delete(ch)
+		_ = ch.Close()
+	}
+}
+
+// Accept 实现net.Listener，等待下一个隧道
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 实现net.Listener，之后打开的隧道会被立即关闭，已建立的隧道不受影响
+func (l *Listener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.done)
+		err = nil
+	})
+	return err
+}
+
+// Addr 实现net.Listener
+func (l *Listener) Addr() net.Addr {
+	return Addr{Name: l.name}
+}
@@ -0,0 +1,88 @@
+package tunnel
+
+import (
+	"QuantumUtils/qnet"
+	"QuantumUtils/qnet/receive"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+var _ net.Conn = (*Conn)(nil)
+var _ net.Listener = (*Listener)(nil)
+
+func TestTunnel(t *testing.T) {
+	serverCb := receive.NewCallBackHandler()
+	listener := Listen(serverCb, "echo")
+	server, err := qnet.NewQuantumServerWithConfig("127.0.0.1:0", serverCb, qnet.QTPServerConfigDefault())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Start()
+	// 将隧道中读到的数据原样写回，对端关闭后关闭
+	serverDone := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverDone <- err
+			return
+		}
+		_, err = io.Copy(conn, conn)
+		conn.Close()
+		serverDone <- err
+	}()
+
+	sender, err := qnet.NewQuantumClientWithConfig(server.Addr().String(), qnet.QTPClientConfigDefault(), receive.NewCallBackHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := Dial(sender, "unknown"); err == nil {
+		t.Error("expected dial to an unknown tunnel to fail")
+	}
+	conn, err := Dial(sender, "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 超过单个消息长度的写入
+	payload := make([]byte, 3*chunkSize+100)
+	_, _ = rand.Read(payload)
+	go func() {
+		if _, err := conn.Write(payload); err != nil {
+			t.Error(err)
+		}
+	}()
+	echoed := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echoed, payload) {
+		t.Error("echoed data mismatch")
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serverDone; err != nil {
+		t.Errorf("server side ended with %v", err)
+	}
+	if _, err := conn.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected write after close to fail, got %v", err)
+	}
+	listener.Close()
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected closed listener, got %v", err)
+	}
+}